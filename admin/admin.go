@@ -0,0 +1,281 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"dumbdns/database"
+	"dumbdns/logging"
+	"dumbdns/models"
+	"dumbdns/stats"
+)
+
+// web holds the dashboard, it is self contained and needs no
+// external assets
+//
+//go:embed web
+var web embed.FS
+
+type Admin struct {
+	HttpServer *http.Server
+	db         *database.Database
+	stats      *stats.Stats
+	token      string
+}
+
+// Start serves the admin API on addr. Every request must carry the
+// token as a bearer token in the Authorization header, except for the
+// dashboard page and the readiness check served by readiness.
+func Start(addr string, token string, db *database.Database, stats *stats.Stats, readiness http.Handler) (*Admin, error) {
+	if token == "" {
+		return nil, errors.New("admin API requires a token")
+	}
+
+	a := &Admin{
+		db:    db,
+		stats: stats,
+		token: token,
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting admin API: %w", err)
+	}
+
+	a.HttpServer = &http.Server{Handler: a.routes(readiness)}
+	go func() {
+		err := a.HttpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Errorf("admin API stopped: %v", err)
+		}
+	}()
+	logging.Infof("Starting admin API at %s\n", listener.Addr())
+
+	return a, nil
+}
+
+// routes returns the handler of every admin API path
+func (a *Admin) routes(readiness http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", a.handleDashboard)
+	mux.Handle("GET /readyz", readiness)
+	mux.HandleFunc("GET /api/stats", a.auth(a.handleStats))
+	mux.HandleFunc("GET /api/dashboard", a.auth(a.handleDashboardData))
+	mux.HandleFunc("GET /api/whitelist", a.auth(a.handleListWhitelist))
+	mux.HandleFunc("GET /api/hosts", a.auth(a.handleListHosts))
+	mux.HandleFunc("POST /api/whitelist/{domain}", a.auth(a.handleAddWhitelist))
+	mux.HandleFunc("DELETE /api/whitelist/{domain}", a.auth(a.handleRemoveWhitelist))
+	mux.HandleFunc("POST /api/blocklist/{domain}", a.auth(a.handleAddBlock))
+	mux.HandleFunc("DELETE /api/blocklist/{domain}", a.auth(a.handleRemoveBlock))
+	mux.HandleFunc("PUT /api/hosts/{domain}", a.auth(a.handleSetHost))
+	mux.HandleFunc("DELETE /api/hosts/{domain}", a.auth(a.handleRemoveHost))
+	mux.HandleFunc("POST /api/refresh", a.auth(a.handleRefresh))
+	mux.HandleFunc("DELETE /api/cache", a.auth(a.handleFlushCache))
+	mux.HandleFunc("DELETE /api/cache/{domain}", a.auth(a.handleFlushRecord))
+	mux.HandleFunc("GET /api/pause", a.auth(a.handleListPauses))
+	mux.HandleFunc("POST /api/pause", a.auth(a.handlePause))
+	mux.HandleFunc("POST /api/pause/{client}", a.auth(a.handlePause))
+	mux.HandleFunc("DELETE /api/pause", a.auth(a.handleResume))
+	mux.HandleFunc("DELETE /api/pause/{client}", a.auth(a.handleResume))
+
+	return mux
+}
+
+func (a *Admin) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleDashboard serves the dashboard page. The page itself holds no
+// data, it asks for the token and calls the authenticated API.
+func (a *Admin) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, web, "web/index.html")
+}
+
+func (a *Admin) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.db.Stats())
+}
+
+func (a *Admin) handleDashboardData(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Database models.Stats  `json:"database"`
+		Queries  stats.Summary `json:"queries"`
+	}{
+		Database: a.db.Stats(),
+		Queries:  a.stats.Summary(time.Now(), 10),
+	})
+}
+
+func (a *Admin) handleListWhitelist(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, slices.Sorted(maps.Keys(a.db.GetConfig().WhitelistDomains)))
+}
+
+func (a *Admin) handleListHosts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.db.GetConfig().Hosts)
+}
+
+func (a *Admin) handleAddWhitelist(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, a.db.AddWhitelist(r.PathValue("domain")))
+}
+
+func (a *Admin) handleRemoveWhitelist(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, a.db.RemoveWhitelist(r.PathValue("domain")))
+}
+
+func (a *Admin) handleAddBlock(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, a.db.AddBlock(r.PathValue("domain")))
+}
+
+func (a *Admin) handleRemoveBlock(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, a.db.RemoveBlock(r.PathValue("domain")))
+}
+
+// handleSetHost sets the hosts override of the domain in the path to
+// the ips or cname in the body
+func (a *Admin) handleSetHost(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		IP    string   `json:"ip"`
+		IPs   []string `json:"ips"`
+		CNAME string   `json:"cname"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error decoding JSON: %w", err))
+		return
+	}
+	if body.IP != "" {
+		body.IPs = append(body.IPs, body.IP)
+	}
+
+	entry := models.HostIPs(body.IPs...)
+	entry.CNAME = body.CNAME
+	err = database.ValidateHost(entry)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	a.writeResult(w, a.db.SetHost(r.PathValue("domain"), entry))
+}
+
+func (a *Admin) handleRemoveHost(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, a.db.RemoveHost(r.PathValue("domain")))
+}
+
+func (a *Admin) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	a.db.RefreshBlockList()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *Admin) handleFlushCache(w http.ResponseWriter, r *http.Request) {
+	a.db.FlushCache()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) handleFlushRecord(w http.ResponseWriter, r *http.Request) {
+	a.db.FlushRecord(r.PathValue("domain"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) handleListPauses(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.db.Pauses())
+}
+
+// handlePause pauses blocking for every client, or for the client in
+// the path
+func (a *Admin) handlePause(w http.ResponseWriter, r *http.Request) {
+	client, err := pathClient(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body := struct {
+		Duration models.Duration `json:"duration"`
+	}{}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error decoding JSON: %w", err))
+		return
+	}
+	if body.Duration.Duration <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("duration must be positive"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.db.PauseBlocking(client, body.Duration.Duration))
+}
+
+func (a *Admin) handleResume(w http.ResponseWriter, r *http.Request) {
+	client, err := pathClient(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	a.db.ResumeBlocking(client)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathClient returns the client IP in the path, or the zero Addr when
+// the path has none
+func pathClient(r *http.Request) (netip.Addr, error) {
+	client := r.PathValue("client")
+	if client == "" {
+		return netip.Addr{}, nil
+	}
+	addr, err := netip.ParseAddr(client)
+	if err != nil {
+		return addr, fmt.Errorf("invalid client ip: %q", client)
+	}
+
+	return addr, nil
+}
+
+func (a *Admin) writeResult(w http.ResponseWriter, err error) {
+	var configErr *database.ConfigError
+	if errors.As(err, &configErr) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errors.Is(err, database.ErrTOMLConfig) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		logging.Warnf("admin API error: %v", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logging.Debugf("error writing admin response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
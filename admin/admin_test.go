@@ -0,0 +1,205 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dumbdns/database"
+	"dumbdns/lifecycle"
+	"dumbdns/models"
+	"dumbdns/stats"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "change-me"
+
+const testConfig = `{
+  "version": 1,
+  "whiteList": ["example.com"],
+  "blockList": ["ads.example.com"],
+  "hostsFile": {"nas.lan": "192.168.0.10"}
+}`
+
+// testAdmin returns the admin API handler of a database read from a
+// config file in a temporary directory, and the path of that file
+func testAdmin(t *testing.T) (http.Handler, string) {
+	path := filepath.Join(t.TempDir(), "dumbdns.json")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0o644))
+	db := database.Start(0)
+	require.NoError(t, db.LoadConfig(path))
+
+	a := &Admin{db: db, stats: stats.Start(), token: testToken}
+
+	return a.routes(&lifecycle.Readiness{}), path
+}
+
+func request(t *testing.T, handler http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func Test_auth(t *testing.T) {
+	handler, _ := testAdmin(t)
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{name: "No token", expectedStatus: http.StatusUnauthorized},
+		{name: "Wrong token", token: "guess", expectedStatus: http.StatusUnauthorized},
+		{name: "Token", token: testToken, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, handler, http.MethodGet, "/api/whitelist", tt.token, "")
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+
+	// changes are refused too, and never saved
+	w := request(t, handler, http.MethodPost, "/api/whitelist/other.com", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(t, handler, http.MethodGet, "/api/whitelist", testToken, "")
+	assert.JSONEq(t, `["example.com"]`, w.Body.String())
+}
+
+func Test_changes(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		// check is run on the config saved to disk
+		check func(t *testing.T, config *models.Config)
+	}{
+		{
+			name:           "Add whitelist entry",
+			method:         http.MethodPost,
+			path:           "/api/whitelist/Other.com.",
+			expectedStatus: http.StatusNoContent,
+			check: func(t *testing.T, config *models.Config) {
+				assert.Contains(t, config.WhitelistDomains, "other.com")
+				assert.Contains(t, config.WhitelistDomains, "example.com")
+			},
+		},
+		{
+			name:           "Remove whitelist entry",
+			method:         http.MethodDelete,
+			path:           "/api/whitelist/example.com",
+			expectedStatus: http.StatusNoContent,
+			check: func(t *testing.T, config *models.Config) {
+				assert.Empty(t, config.WhitelistDomains)
+			},
+		},
+		{
+			name:           "Add block entry",
+			method:         http.MethodPost,
+			path:           "/api/blocklist/tracker.example.com",
+			expectedStatus: http.StatusNoContent,
+			check: func(t *testing.T, config *models.Config) {
+				assert.Contains(t, config.BlockedDomains, "tracker.example.com")
+			},
+		},
+		{
+			name:           "Remove block entry",
+			method:         http.MethodDelete,
+			path:           "/api/blocklist/ads.example.com",
+			expectedStatus: http.StatusNoContent,
+			check: func(t *testing.T, config *models.Config) {
+				assert.Empty(t, config.BlockedDomains)
+			},
+		},
+		{
+			name:           "Set host",
+			method:         http.MethodPut,
+			path:           "/api/hosts/printer.lan",
+			body:           `{"ips": ["192.168.0.20", "fd00::20"]}`,
+			expectedStatus: http.StatusNoContent,
+			check: func(t *testing.T, config *models.Config) {
+				assert.Equal(t, models.HostEntry{A: []string{"192.168.0.20"}, AAAA: []string{"fd00::20"}}, config.Hosts["printer.lan"])
+			},
+		},
+		{
+			name:           "Set host CNAME",
+			method:         http.MethodPut,
+			path:           "/api/hosts/files.lan",
+			body:           `{"cname": "nas.lan"}`,
+			expectedStatus: http.StatusNoContent,
+			check: func(t *testing.T, config *models.Config) {
+				assert.Equal(t, models.HostEntry{CNAME: "nas.lan"}, config.Hosts["files.lan"])
+			},
+		},
+		{
+			name:           "Remove host",
+			method:         http.MethodDelete,
+			path:           "/api/hosts/nas.lan",
+			expectedStatus: http.StatusNoContent,
+			check: func(t *testing.T, config *models.Config) {
+				assert.Empty(t, config.Hosts)
+			},
+		},
+		{
+			name:           "Invalid whitelist entry",
+			method:         http.MethodPost,
+			path:           "/api/whitelist/a%20b",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid block entry",
+			method:         http.MethodPost,
+			path:           "/api/blocklist/a..b",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid host name",
+			method:         http.MethodPut,
+			path:           "/api/hosts/bad_name!",
+			body:           `{"ip": "192.168.0.20"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid host ip",
+			method:         http.MethodPut,
+			path:           "/api/hosts/printer.lan",
+			body:           `{"ip": "192.168.0"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, path := testAdmin(t)
+			before, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			w := request(t, handler, tt.method, tt.path, testToken, tt.body)
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+
+			// the file on disk must still load
+			config, err := database.CheckConfig(path)
+			require.NoError(t, err)
+			if tt.check == nil {
+				after, err := os.ReadFile(path)
+				require.NoError(t, err)
+				assert.Equal(t, string(before), string(after))
+				return
+			}
+			tt.check(t, config)
+		})
+	}
+}
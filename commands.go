@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"dumbdns/database"
+	"dumbdns/models"
+	"dumbdns/settings"
+)
+
+// checkConfig validates the config file, printing every problem found.
+// It returns the exit code of the check-config subcommand.
+func checkConfig(args []string) int {
+	flags, err := settings.Parse("dumbdns check-config", args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	_, err = database.CheckConfig(flags.ConfigPath)
+	if err != nil {
+		var joined interface{ Unwrap() []error }
+		if errors.As(err, &joined) {
+			for _, e := range joined.Unwrap() {
+				fmt.Fprintln(os.Stderr, e)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+
+	fmt.Println("config ok")
+	return 0
+}
+
+// pauseBlocking pauses blocking on the running server through the admin
+// API, or shows the pauses when no duration is given. It returns the
+// exit code of the pause subcommand.
+func pauseBlocking(args []string) int {
+	fs, configPath, client := adminFlags("dumbdns pause [flags] [duration]")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	if fs.NArg() > 0 {
+		d, err := time.ParseDuration(fs.Arg(0))
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid duration %q\n", fs.Arg(0))
+			return 2
+		}
+		body, _ := json.Marshal(map[string]string{"duration": d.String()})
+		err = adminRequest(*configPath, http.MethodPost, pausePath(*client), bytes.NewReader(body), nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return printPauses(*configPath)
+}
+
+// resumeBlocking ends a pause early. It returns the exit code of the
+// resume subcommand.
+func resumeBlocking(args []string) int {
+	fs, configPath, client := adminFlags("dumbdns resume [flags]")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+
+	err = adminRequest(*configPath, http.MethodDelete, pausePath(*client), nil, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return printPauses(*configPath)
+}
+
+func adminFlags(name string) (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(settings.EnvName("config")), "path to the config file holding the admin API address and token")
+	client := fs.String("client", "", "only pause or resume blocking for this client IP")
+
+	return fs, configPath, client
+}
+
+func pausePath(client string) string {
+	if client == "" {
+		return "/api/pause"
+	}
+
+	return "/api/pause/" + url.PathEscape(client)
+}
+
+func printPauses(configPath string) int {
+	pauses := []models.Pause{}
+	err := adminRequest(configPath, http.MethodGet, "/api/pause", nil, &pauses)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(pauses) == 0 {
+		fmt.Println("blocking is on")
+	}
+	for _, p := range pauses {
+		client := cmp.Or(p.Client, "all clients")
+		fmt.Printf("blocking paused for %s, %s left (until %s)\n", client, p.Remaining, p.Until.Local().Format(time.TimeOnly))
+	}
+
+	return 0
+}
+
+// adminRequest calls the admin API of the server using the address and
+// token in the config file, decoding the response into result
+func adminRequest(configPath string, method string, path string, body io.Reader, result interface{}) error {
+	config, err := database.CheckConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if config.Admin.Listen == "" {
+		return errors.New("the admin API isn't enabled in the config file")
+	}
+
+	host, port, err := net.SplitHostPort(config.Admin.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid admin listen address: %w", err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	req, err := http.NewRequest(method, "http://"+net.JoinHostPort(host, port)+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+config.Admin.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling the admin API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := struct {
+			Error string `json:"error"`
+		}{}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("admin API error: %s %s", resp.Status, apiErr.Error)
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
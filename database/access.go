@@ -0,0 +1,102 @@
+package database
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"dumbdns/logging"
+	"dumbdns/models"
+)
+
+// defaultAllow is used when the config has no allow list
+var defaultAllow = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// clientACL is the parsed access config of a single config version
+type clientACL struct {
+	config *models.Config
+	allow  []netip.Prefix
+	deny   []netip.Prefix
+}
+
+// ClientAllowed reports whether addr may query the server
+func (db *Database) ClientAllowed(addr netip.Addr) bool {
+	acl := db.clientACL()
+	addr = addr.Unmap().WithZone("")
+
+	for _, prefix := range acl.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	for _, prefix := range acl.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientACL returns the ACL of the current config, parsing it the first
+// time the config is seen
+func (db *Database) clientACL() *clientACL {
+	config := db.GetConfig()
+	acl := db.acl.Load()
+	if acl != nil && acl.config == config {
+		return acl
+	}
+
+	acl = &clientACL{
+		config: config,
+		allow:  parsePrefixes(config.Access.Allow),
+		deny:   parsePrefixes(config.Access.Deny),
+	}
+	if len(config.Access.Allow) == 0 {
+		acl.allow = defaultAllow
+	}
+	db.acl.Store(acl)
+
+	return acl
+}
+
+// parsePrefixes parses entries that have already been validated
+func parsePrefixes(entries []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			logging.Warnf("skipping access entry: %v", err)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
+
+// parsePrefix parses a CIDR, or a single IP as a prefix of its length
+func parsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ip %q", entry)
+	}
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
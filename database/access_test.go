@@ -0,0 +1,91 @@
+package database
+
+import (
+	"net/netip"
+	"testing"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_clientAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		access   models.AccessConfig
+		client   string
+		expected bool
+	}{
+		{
+			name:     "Private address allowed by default",
+			client:   "192.168.0.10",
+			expected: true,
+		},
+		{
+			name:     "IPv6 loopback allowed by default",
+			client:   "::1",
+			expected: true,
+		},
+		{
+			name:     "IPv6 unique local allowed by default",
+			client:   "fd00::10",
+			expected: true,
+		},
+		{
+			name:     "Public address refused by default",
+			client:   "8.8.8.8",
+			expected: false,
+		},
+		{
+			name:     "CGNAT refused by default",
+			client:   "100.64.0.2",
+			expected: false,
+		},
+		{
+			name:     "CGNAT allowed when listed",
+			access:   models.AccessConfig{Allow: []string{"100.64.0.0/10"}},
+			client:   "100.64.0.2",
+			expected: true,
+		},
+		{
+			name:     "Allow list replaces the defaults",
+			access:   models.AccessConfig{Allow: []string{"100.64.0.0/10"}},
+			client:   "192.168.0.10",
+			expected: false,
+		},
+		{
+			name:     "IPv4-mapped address matches IPv4 entry",
+			access:   models.AccessConfig{Allow: []string{"100.64.0.0/10"}},
+			client:   "::ffff:100.64.0.2",
+			expected: true,
+		},
+		{
+			name:     "Single IP entry",
+			access:   models.AccessConfig{Allow: []string{"2001:db8::1"}},
+			client:   "2001:db8::1",
+			expected: true,
+		},
+		{
+			name:     "Deny takes precedence over allow",
+			access:   models.AccessConfig{Allow: []string{"192.168.0.0/16"}, Deny: []string{"192.168.5.0/24"}},
+			client:   "192.168.5.20",
+			expected: false,
+		},
+		{
+			name:     "Deny applies on top of the defaults",
+			access:   models.AccessConfig{Deny: []string{"192.168.5.20"}},
+			client:   "192.168.5.20",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := Start(0)
+			db.Config = &models.Config{Access: tt.access}
+
+			actual := db.ClientAllowed(netip.MustParseAddr(tt.client))
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
@@ -2,48 +2,26 @@ package database
 
 import (
 	"bufio"
-	"log"
+	"context"
+	"fmt"
+	"maps"
 	"net/http"
 	"regexp"
+	"slices"
 	"time"
-)
 
-func (db *Database) UpdateBlockList(refreshRate time.Duration) {
-	config, err := readConfigFromDisk()
-	if err != nil {
-		log.Fatalf("error reading config: %v", err)
-		return
-	}
-	db.Config = config
+	"dumbdns/logging"
+	"dumbdns/models"
+)
 
+// UpdateBlockList fetches the block lists every refreshRate, or when a
+// refresh is requested, until ctx is done
+func (db *Database) UpdateBlockList(ctx context.Context, refreshRate time.Duration) {
 	for {
-		log.Println("Getting block list")
-		db.blockMux.Lock()
-		db.blockListDatabase = make(map[string]interface{})
-
-		for _, s := range config.Blocklists {
-			var compRegEx = regexp.MustCompile(s.Regex)
-			resp, err := http.Get(s.Url)
-			if err != nil {
-				log.Println("Error:", err)
-			}
-			scanner := bufio.NewScanner(resp.Body)
-
-			// populate the list
-			for scanner.Scan() {
-				v := getParams(compRegEx, scanner.Text())
-				if v != nil {
-					db.blockListDatabase[*v] = struct{}{}
-				}
-			}
-		}
-		for domain, _ := range config.WhitelistDomains {
-			delete(db.blockListDatabase, domain)
-		}
-		db.blockMux.Unlock()
-		log.Printf("Block list updated with %d records\r\n", len(db.blockListDatabase))
+		db.rebuildBlockList(ctx)
+		db.loadedOnce.Do(func() { close(db.loaded) })
 
-		log.Println("Purging old database records")
+		logging.Debugf("Purging old database records")
 		db.dbMux.Lock()
 		for domain, v := range db.database {
 			if time.Now().After(v.ExpiresAt) {
@@ -52,9 +30,199 @@ func (db *Database) UpdateBlockList(refreshRate time.Duration) {
 		}
 		db.dbMux.Unlock()
 
-		log.Println("Refresh Go routine sleeping")
-		time.Sleep(refreshRate)
+		logging.Debugf("Refresh Go routine sleeping")
+		select {
+		case <-time.After(refreshRate):
+		case <-db.refresh:
+			logging.Infof("Block list refresh requested")
+		case <-ctx.Done():
+			logging.Debugf("Refresh Go routine stopped")
+			return
+		}
+	}
+}
+
+// BlockListLoaded is closed once the block lists have been fetched for
+// the first time
+func (db *Database) BlockListLoaded() <-chan struct{} {
+	return db.loaded
+}
+
+// RefreshBlockList wakes up the refresh loop so the block list is
+// fetched again without waiting for the refresh rate to elapse.
+func (db *Database) RefreshBlockList() {
+	select {
+	case db.refresh <- struct{}{}:
+	default:
+		// a refresh is already pending
+	}
+}
+
+// blockListClient fetches the block lists, a hung server would hold up
+// the refresh loop otherwise
+var blockListClient = &http.Client{Timeout: 2 * time.Minute}
+
+// rebuildBlockList fetches every block list source and applies the
+// custom block entries and whitelist on top of them. A source that
+// can't be fetched keeps the entries of its last successful fetch.
+func (db *Database) rebuildBlockList(ctx context.Context) {
+	logging.Infof("Getting block list")
+	config := db.GetConfig()
+
+	db.blockMux.RLock()
+	previous := db.sourceDatabase
+	db.blockMux.RUnlock()
+
+	failed := false
+	sourceDatabase := make(map[models.Sources]map[string]interface{})
+	sourceCounts := make(map[string]int)
+	for _, s := range allSources(config) {
+		sourceList, err := fetchSource(ctx, s)
+		if err != nil {
+			logging.Errorf("Error fetching block list %s, keeping its last entries: %v", s.Url, err)
+			failed = true
+			sourceList = previous[s]
+			if sourceList == nil {
+				continue
+			}
+		}
+		sourceDatabase[s] = sourceList
+		sourceCounts[s.Url] += len(sourceList)
+	}
+
+	db.blockMux.Lock()
+	db.sourceDatabase = sourceDatabase
+	db.sourceCounts = sourceCounts
+	if !failed {
+		db.lastRefresh = time.Now()
+	}
+	db.blockMux.Unlock()
+
+	db.applyBlockList()
+}
+
+// fetchSource downloads the block list s and returns the domains its
+// regex captures
+func fetchSource(ctx context.Context, s models.Sources) (map[string]interface{}, error) {
+	compRegEx, err := regexp.Compile(s.Regex)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := blockListClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// an error page isn't an empty block list
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	sourceList := make(map[string]interface{})
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		v := getParams(compRegEx, scanner.Text())
+		if v != nil {
+			sourceList[*v] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sourceList, nil
+}
+
+// applyBlockList rebuilds the block list of the default and every group
+// from the last fetched sources and the current config, without
+// fetching the sources again
+func (db *Database) applyBlockList() {
+	config := db.GetConfig()
+
+	db.blockMux.Lock()
+	defer db.blockMux.Unlock()
+
+	db.blockListDatabase = db.buildBlockList(config.Blocklists, config.BlockedDomains, config.WhitelistDomains)
+	logging.Infof("Block list updated with %d records\r\n", len(db.blockListDatabase))
+
+	groupBlockLists := make(map[string]map[string]interface{}, len(config.Groups))
+	for name, group := range config.Groups {
+		if group.Blocklists == nil && group.BlockedDomains == nil && group.WhitelistDomains == nil {
+			// the group blocks the same domains as the default
+			groupBlockLists[name] = db.blockListDatabase
+			continue
+		}
+		p := groupPolicy(config, name)
+		groupBlockLists[name] = db.buildBlockList(p.blocklists, p.blocked, p.whitelist)
+		logging.Infof("Block list of group %s updated with %d records\r\n", name, len(groupBlockLists[name]))
+	}
+	db.groupBlockLists = groupBlockLists
+
+	scheduleBlockLists := make(map[string]map[string]interface{}, len(config.Schedules))
+	for _, s := range config.Schedules {
+		scheduleBlockLists[s.Name] = db.buildBlockList(s.Blocklists, toDomainMap(s.BlockedDomains), nil)
+	}
+	db.scheduleBlockLists = scheduleBlockLists
+}
+
+// buildBlockList merges the fetched sources with the custom block
+// entries, less the whitelist. blockMux must be held.
+func (db *Database) buildBlockList(sources []models.Sources, blocked map[string]interface{}, whitelist map[string]interface{}) map[string]interface{} {
+	blockList := make(map[string]interface{})
+	for _, s := range sources {
+		maps.Copy(blockList, db.sourceDatabase[s])
 	}
+	for domain := range blocked {
+		blockList[domain] = struct{}{}
+	}
+	for domain := range whitelist {
+		delete(blockList, domain)
+	}
+
+	return blockList
+}
+
+// allSources returns the block list sources of the default, every group
+// and every schedule, without duplicates
+func allSources(config *models.Config) []models.Sources {
+	sources := slices.Clone(config.Blocklists)
+	add := func(list []models.Sources) {
+		for _, s := range list {
+			if !slices.Contains(sources, s) {
+				sources = append(sources, s)
+			}
+		}
+	}
+	for _, name := range sortedKeys(config.Groups) {
+		add(config.Groups[name].Blocklists)
+	}
+	for _, s := range config.Schedules {
+		add(s.Blocklists)
+	}
+
+	return sources
+}
+
+// BlockListSources returns the number of entries read from each block
+// list source during the last refresh, keyed by source url
+func (db *Database) BlockListSources() map[string]int {
+	db.blockMux.RLock()
+	defer db.blockMux.RUnlock()
+
+	return maps.Clone(db.sourceCounts)
+}
+
+// LastRefresh returns when every block list source was last fetched
+// successfully
+func (db *Database) LastRefresh() time.Time {
+	db.blockMux.RLock()
+	defer db.blockMux.RUnlock()
+
+	return db.lastRefresh
 }
 
 func getParams(compRegEx *regexp.Regexp, url string) *string {
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_rebuildBlockList(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+		if status.Load() == http.StatusOK {
+			w.Write([]byte("0.0.0.0 ads.example.com\n0.0.0.0 tracker.example.com\n"))
+		} else {
+			w.Write([]byte("<html>0.0.0.0 error.example.com</html>\n"))
+		}
+	}))
+	defer server.Close()
+
+	db := Start(0)
+	db.Config = &models.Config{
+		Blocklists: []models.Sources{{Regex: `0.0.0.0\s+(?P<url>\S+)`, Url: server.URL}},
+	}
+
+	tests := []struct {
+		name            string
+		status          int
+		expectedBlocked []string
+		expectedFresh   bool
+	}{
+		{name: "Error page before the first fetch", status: http.StatusInternalServerError, expectedBlocked: []string{}},
+		{name: "Fetched", status: http.StatusOK, expectedBlocked: []string{"ads.example.com", "tracker.example.com"}, expectedFresh: true},
+		{name: "Error page keeps the last entries", status: http.StatusServiceUnavailable, expectedBlocked: []string{"ads.example.com", "tracker.example.com"}},
+		{name: "Not found keeps the last entries", status: http.StatusNotFound, expectedBlocked: []string{"ads.example.com", "tracker.example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status.Store(int32(tt.status))
+			before := db.LastRefresh()
+			db.rebuildBlockList(context.Background())
+
+			blocked := []string{}
+			for domain := range db.blockListDatabase {
+				blocked = append(blocked, domain)
+			}
+			assert.ElementsMatch(t, tt.expectedBlocked, blocked)
+			assert.Equal(t, len(tt.expectedBlocked), db.BlockListSources()[server.URL])
+			if tt.expectedFresh {
+				assert.True(t, db.LastRefresh().After(before))
+			} else {
+				assert.Equal(t, before, db.LastRefresh())
+			}
+		})
+	}
+	assert.WithinDuration(t, time.Now(), db.LastRefresh(), time.Minute)
+}
+
+func Test_rebuildBlockListStopped(t *testing.T) {
+	// the server never answers, like a hung block list host
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	db := Start(0)
+	db.Config = &models.Config{
+		Blocklists: []models.Sources{{Regex: `0.0.0.0\s+(?P<url>\S+)`, Url: server.URL}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		db.UpdateBlockList(ctx, time.Hour)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("block list refresh wasn't stopped")
+	}
+	assert.True(t, db.LastRefresh().IsZero())
+}
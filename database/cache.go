@@ -0,0 +1,86 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dumbdns/logging"
+	"dumbdns/models"
+)
+
+// SaveCache writes the unexpired cache records to path so they survive
+// a restart
+func (db *Database) SaveCache(path string) error {
+	now := time.Now()
+
+	db.dbMux.RLock()
+	records := make(map[string]*models.Record, len(db.database))
+	for domain, record := range db.database {
+		if now.Before(record.ExpiresAt) {
+			records[domain] = record
+		}
+	}
+	data, err := json.Marshal(records)
+	db.dbMux.RUnlock()
+	if err != nil {
+		return fmt.Errorf("error encoding cache: %w", err)
+	}
+
+	// write to a temporary file first so a crash never leaves a half
+	// written cache behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("error saving cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error saving cache: %w", err)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("error saving cache: %w", err)
+	}
+	logging.Infof("Saved %d cache records to %s\n", len(records), path)
+
+	return nil
+}
+
+// LoadCache reads the records saved by SaveCache, skipping any that
+// expired while the server was stopped. A missing file is not an error.
+func (db *Database) LoadCache(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading cache: %w", err)
+	}
+
+	records := map[string]*models.Record{}
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return fmt.Errorf("error decoding cache: %w", err)
+	}
+
+	now := time.Now()
+	db.dbMux.Lock()
+	defer db.dbMux.Unlock()
+	for domain, record := range records {
+		if record != nil && now.Before(record.ExpiresAt) {
+			db.database[domain] = record
+		}
+	}
+	logging.Infof("Loaded %d cache records from %s\n", len(db.database), path)
+
+	return nil
+}
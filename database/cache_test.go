@@ -0,0 +1,63 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_saveCache(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name     string
+		database map[string]*models.Record
+		expected map[string]*models.Record
+	}{
+		{
+			name: "Unexpired records are restored",
+			database: map[string]*models.Record{
+				"google.com": {ExpiresAt: now.Add(time.Minute), A: []string{"192.168.0.1"}},
+				"bing.com":   {ExpiresAt: now.Add(time.Minute), CNAME: "www.bing.com."},
+			},
+			expected: map[string]*models.Record{
+				"google.com": {ExpiresAt: now.Add(time.Minute), A: []string{"192.168.0.1"}},
+				"bing.com":   {ExpiresAt: now.Add(time.Minute), CNAME: "www.bing.com."},
+			},
+		},
+		{
+			name: "Expired records are dropped",
+			database: map[string]*models.Record{
+				"google.com": {ExpiresAt: now.Add(time.Minute), A: []string{"192.168.0.1"}},
+				"old.com":    {ExpiresAt: now.Add(-time.Minute), A: []string{"192.168.0.2"}},
+			},
+			expected: map[string]*models.Record{
+				"google.com": {ExpiresAt: now.Add(time.Minute), A: []string{"192.168.0.1"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache.json")
+
+			db := Start(5 * time.Minute)
+			db.database = tt.database
+			assert.NoError(t, db.SaveCache(path))
+
+			restored := Start(5 * time.Minute)
+			assert.NoError(t, restored.LoadCache(path))
+			assert.Equal(t, tt.expected, restored.database)
+		})
+	}
+}
+
+func Test_loadCacheMissing(t *testing.T) {
+	db := Start(5 * time.Minute)
+	assert.NoError(t, db.LoadCache(filepath.Join(t.TempDir(), "missing.json")))
+	assert.Empty(t, db.database)
+}
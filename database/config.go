@@ -1,52 +1,328 @@
 package database
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"time"
 
+	"dumbdns/logging"
 	"dumbdns/models"
 )
 
-func readConfigFromDisk() (*models.Config, error) {
-	configFile := "dumbdns.json"
-	file, err := os.Open("./" + configFile)
+// configFileNames are looked for in order when no config path is given
+var configFileNames = []string{"dumbdns.json", "dumbdns.yaml", "dumbdns.yml", "dumbdns.toml"}
+
+// configFile is the on disk layout of the config file. YAML and TOML
+// files use the same keys.
+type configFile struct {
+	Version int `json:"version"`
+	models.ServerConfig
+	BlockLists       []models.Sources            `json:"blockLists,omitempty"`
+	WhitelistDomains []string                    `json:"whitelist"`
+	BlockedDomains   []string                    `json:"blockList,omitempty"`
+	Hosts            map[string]models.HostEntry `json:"hostsFile"`
+	HostsFiles       []string                    `json:"hostsFiles,omitempty"`
+	BlockMode        string                      `json:"blockMode,omitempty"`
+	SafeSearch       bool                        `json:"safeSearch,omitempty"`
+	Groups           map[string]groupFile        `json:"groups,omitempty"`
+	Schedules        []models.ScheduleConfig     `json:"schedules,omitempty"`
+	Timezone         string                      `json:"timezone,omitempty"`
+	ForwardZones     map[string][]string         `json:"forwardZones,omitempty"`
+	Zones            map[string]string           `json:"zones,omitempty"`
+	TSIGKeys         map[string]models.TSIGKey   `json:"tsigKeys,omitempty"`
+	DynamicUpdates   map[string][]string         `json:"dynamicUpdates,omitempty"`
+	DHCP             models.DHCPConfig           `json:"dhcp,omitzero"`
+	Chaos            models.ChaosConfig          `json:"chaos,omitzero"`
+	EDNS             models.EDNSConfig           `json:"edns,omitzero"`
+	ECS              models.ECSConfig            `json:"ecs,omitzero"`
+	DNSSEC           models.DNSSECConfig         `json:"dnssec,omitzero"`
+	Admin            models.AdminConfig          `json:"admin,omitzero"`
+	Metrics          models.MetricsConfig        `json:"metrics,omitzero"`
+	QueryLog         models.QueryLogConfig       `json:"queryLog,omitzero"`
+	Access           models.AccessConfig         `json:"access,omitzero"`
+}
+
+// groupFile is the on disk layout of a client group. Fields that are
+// left out use the top level value, so an empty list is kept.
+type groupFile struct {
+	Clients          []string                    `json:"clients"`
+	BlockLists       []models.Sources            `json:"blockLists,omitzero"`
+	WhitelistDomains []string                    `json:"whitelist,omitzero"`
+	BlockedDomains   []string                    `json:"blockList,omitzero"`
+	Hosts            map[string]models.HostEntry `json:"hostsFile,omitzero"`
+	BlockMode        string                      `json:"blockMode,omitempty"`
+	SafeSearch       *bool                       `json:"safeSearch,omitempty"`
+	ECS              *models.ECSConfig           `json:"ecs,omitempty"`
+}
+
+// configPath returns the path of the config file, preferring the working
+// directory and falling back to the directory of the executable.
+func configPath() string {
+	for _, name := range configFileNames {
+		if _, err := os.Stat("./" + name); err == nil {
+			return "./" + name
+		}
+	}
+
+	exePath, err := os.Executable()
 	if err != nil {
-		exePath, err := os.Executable()
-		if err != nil {
-			log.Fatalf("error getting executable path: %v", err)
+		log.Fatalf("error getting executable path: %v", err)
+	}
+	for _, name := range configFileNames {
+		path := filepath.Join(filepath.Dir(exePath), name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return filepath.Join(filepath.Dir(exePath), configFileNames[0])
+}
+
+// readConfigFromDisk returns the decoded config and the raw file, which
+// is used to give validation errors a line number
+func readConfigFromDisk(path string) (*models.Config, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open config file: %w", err)
+	}
+
+	config, err := decodeConfig(path, data)
+	if err != nil {
+		return nil, data, err
+	}
+	if config.Hosts == nil {
+		config.Hosts = map[string]models.HostEntry{}
+	}
+
+	return &models.Config{
+		Server:           config.ServerConfig,
+		Blocklists:       config.BlockLists,
+		WhitelistDomains: toDomainMap(config.WhitelistDomains),
+		BlockedDomains:   toDomainMap(config.BlockedDomains),
+		Hosts:            config.Hosts,
+		HostsFiles:       config.HostsFiles,
+		BlockMode:        config.BlockMode,
+		SafeSearch:       config.SafeSearch,
+		Groups:           fromGroupFiles(config.Groups),
+		Schedules:        config.Schedules,
+		Timezone:         config.Timezone,
+		ForwardZones:     config.ForwardZones,
+		Zones:            config.Zones,
+		TSIGKeys:         config.TSIGKeys,
+		DynamicUpdates:   config.DynamicUpdates,
+		DHCP:             config.DHCP,
+		Chaos:            config.Chaos,
+		EDNS:             config.EDNS,
+		ECS:              config.ECS,
+		DNSSEC:           config.DNSSEC,
+		Admin:            config.Admin,
+		Metrics:          config.Metrics,
+		QueryLog:         config.QueryLog,
+		Access:           config.Access,
+	}, data, nil
+}
+
+// ReloadConfig reads the config, zone and hosts files again and swaps them in
+// once they are valid. The block list sources are only fetched again if they changed.
+func (db *Database) ReloadConfig() error {
+	db.configMux.RLock()
+	path := db.configPath
+	db.configMux.RUnlock()
+
+	config, local, err := checkConfig(path)
+	if err != nil {
+		return err
+	}
+
+	db.configMux.Lock()
+	previous := db.Config
+	db.Config = config
+	db.configModTime = configModTime(path, config)
+	db.configMux.Unlock()
+	db.localData.Store(local)
+	logging.Infof("Config reloaded from %s\n", path)
+
+	if !reflect.DeepEqual(previous.Server, config.Server) || previous.Admin != config.Admin ||
+		previous.Metrics != config.Metrics || previous.QueryLog != config.QueryLog {
+		logging.Warnf("Changes to server settings, admin, metrics and queryLog need a restart to apply")
+	}
+
+	if !slices.Equal(allSources(previous), allSources(config)) {
+		db.RefreshBlockList()
+	} else {
+		db.applyBlockList()
+	}
+
+	return nil
+}
+
+// WatchConfig reloads the config whenever the file or one of its zone
+// or hosts files changes on disk, until ctx is done
+func (db *Database) WatchConfig(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+
+		db.configMux.RLock()
+		path, config, lastModTime := db.configPath, db.Config, db.configModTime
+		db.configMux.RUnlock()
+
+		if modTime := configModTime(path, config); modTime.IsZero() || modTime.Equal(lastModTime) {
+			continue
 		}
-		wd := filepath.Dir(exePath)
 
-		fullPath := filepath.Join(wd, configFile)
-		file, err = os.Open(fullPath)
+		err := db.ReloadConfig()
 		if err != nil {
-			log.Fatalf("could not open file with full path: %v", err)
+			logging.Errorf("Keeping previous config: %v", err)
+			// don't retry the same broken file on every tick
+			db.configMux.Lock()
+			db.configModTime = configModTime(path, config)
+			db.configMux.Unlock()
+		}
+	}
+}
+
+// configModTime returns when the config file at path last changed, or
+// when any of the zone or hosts files of config did if that was later
+func configModTime(path string, config *models.Config) time.Time {
+	newest := modTime(path)
+	if newest.IsZero() || config == nil {
+		return newest
+	}
+	for _, file := range localFiles(filepath.Dir(path), config) {
+		if t := modTime(file); t.After(newest) {
+			newest = t
 		}
 	}
-	defer file.Close()
 
-	config := struct {
-		BlockLists       []models.Sources  `json:"blockLists"`
-		WhitelistDomains []string          `json:"whiteList"`
-		Hosts            map[string]string `json:"hostsFile"`
-	}{}
-	
-	err = json.NewDecoder(file).Decode(&config)
+	return newest
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+func writeConfigToDisk(path string, config *models.Config) error {
+	// a missing file is written from scratch
+	previous, _ := os.ReadFile(path)
+	data, err := encodeConfig(path, configFile{
+		Version:          configVersion,
+		ServerConfig:     config.Server,
+		BlockLists:       config.Blocklists,
+		WhitelistDomains: toDomainList(config.WhitelistDomains),
+		BlockedDomains:   toDomainList(config.BlockedDomains),
+		Hosts:            config.Hosts,
+		HostsFiles:       config.HostsFiles,
+		BlockMode:        config.BlockMode,
+		SafeSearch:       config.SafeSearch,
+		Groups:           toGroupFiles(config.Groups),
+		Schedules:        config.Schedules,
+		Timezone:         config.Timezone,
+		ForwardZones:     config.ForwardZones,
+		Zones:            config.Zones,
+		TSIGKeys:         config.TSIGKeys,
+		DynamicUpdates:   config.DynamicUpdates,
+		DHCP:             config.DHCP,
+		Chaos:            config.Chaos,
+		EDNS:             config.EDNS,
+		ECS:              config.ECS,
+		DNSSEC:           config.DNSSEC,
+		Admin:            config.Admin,
+		Metrics:          config.Metrics,
+		QueryLog:         config.QueryLog,
+		Access:           config.Access,
+	}, previous)
+	if err != nil {
+		return fmt.Errorf("error encoding config: %w", err)
+	}
+
+	// write to a temporary file first so a failed write never leaves
+	// a truncated config behind
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding JSON: %w", err)
+		return fmt.Errorf("error writing config file: %w", err)
 	}
 
+	return os.Rename(tmp, path)
+}
+
+func toDomainMap(domains []string) map[string]interface{} {
 	domainMap := make(map[string]interface{})
-	for _, domain := range config.WhitelistDomains {
+	for _, domain := range domains {
 		domainMap[domain] = struct{}{}
 	}
 
-	return &models.Config{
-		Blocklists:       config.BlockLists,
-		WhitelistDomains: domainMap,
-		Hosts:            config.Hosts,
-	}, nil
+	return domainMap
+}
+
+func toDomainList(domains map[string]interface{}) []string {
+	list := make([]string, 0, len(domains))
+	for domain := range domains {
+		list = append(list, domain)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+func fromGroupFiles(files map[string]groupFile) map[string]models.GroupConfig {
+	groups := make(map[string]models.GroupConfig, len(files))
+	for name, file := range files {
+		group := models.GroupConfig{
+			Clients:    file.Clients,
+			Blocklists: file.BlockLists,
+			Hosts:      file.Hosts,
+			BlockMode:  file.BlockMode,
+			SafeSearch: file.SafeSearch,
+			ECS:        file.ECS,
+		}
+		// nil lists are inherited, so only convert the ones given
+		if file.WhitelistDomains != nil {
+			group.WhitelistDomains = toDomainMap(file.WhitelistDomains)
+		}
+		if file.BlockedDomains != nil {
+			group.BlockedDomains = toDomainMap(file.BlockedDomains)
+		}
+		groups[name] = group
+	}
+
+	return groups
+}
+
+func toGroupFiles(groups map[string]models.GroupConfig) map[string]groupFile {
+	files := make(map[string]groupFile, len(groups))
+	for name, group := range groups {
+		file := groupFile{
+			Clients:    group.Clients,
+			BlockLists: group.Blocklists,
+			Hosts:      group.Hosts,
+			BlockMode:  group.BlockMode,
+			SafeSearch: group.SafeSearch,
+			ECS:        group.ECS,
+		}
+		if group.WhitelistDomains != nil {
+			file.WhitelistDomains = toDomainList(group.WhitelistDomains)
+		}
+		if group.BlockedDomains != nil {
+			file.BlockedDomains = toDomainList(group.BlockedDomains)
+		}
+		files[name] = file
+	}
+
+	return files
 }
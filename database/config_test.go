@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockListServer serves a hosts style block list and counts how often
+// it was fetched
+func blockListServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	fetches := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte("0.0.0.0 ads.example.com\n0.0.0.0 tracker.example.com\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, fetches
+}
+
+func reloadConfig(url string, whitelist string) string {
+	return fmt.Sprintf(`{
+  "version": 1,
+  "blockLists": [{"regex": "0.0.0.0\\s+(?P<url>\\S+)", "url": %q}],
+  "whitelist": [%q]
+}`, url, whitelist)
+}
+
+func Test_ReloadConfig(t *testing.T) {
+	server, fetches := blockListServer(t)
+
+	tests := []struct {
+		name              string
+		config            string
+		expectedErr       bool
+		expectedRefresh   bool
+		expectedBlocked   []string
+		expectedWhitelist string
+	}{
+		{
+			name:              "Invalid config keeps the previous one",
+			config:            `{"version": 1, "whitelist": [,]}`,
+			expectedErr:       true,
+			expectedBlocked:   []string{"ads.example.com"},
+			expectedWhitelist: "tracker.example.com",
+		},
+		{
+			name:              "Invalid domain keeps the previous one",
+			config:            reloadConfig(server.URL, "not a domain"),
+			expectedErr:       true,
+			expectedBlocked:   []string{"ads.example.com"},
+			expectedWhitelist: "tracker.example.com",
+		},
+		{
+			name:              "Same sources are applied without fetching",
+			config:            reloadConfig(server.URL, "ads.example.com"),
+			expectedBlocked:   []string{"tracker.example.com"},
+			expectedWhitelist: "ads.example.com",
+		},
+		{
+			name:            "Changed sources are fetched again",
+			config:          reloadConfig(server.URL+"/other", "ads.example.com"),
+			expectedRefresh: true,
+			// the last block list is kept until the refresh loop fetches
+			expectedBlocked:   []string{"ads.example.com"},
+			expectedWhitelist: "ads.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dumbdns.json")
+			require.NoError(t, os.WriteFile(path, []byte(reloadConfig(server.URL, "tracker.example.com")), 0o644))
+			db := Start(time.Minute)
+			require.NoError(t, db.LoadConfig(path))
+			db.rebuildBlockList(context.Background())
+			previous := db.GetConfig()
+			fetched := fetches.Load()
+
+			require.NoError(t, os.WriteFile(path, []byte(tt.config), 0o644))
+			err := db.ReloadConfig()
+			if tt.expectedErr {
+				assert.Error(t, err)
+				assert.Same(t, previous, db.GetConfig())
+			} else {
+				assert.NoError(t, err)
+				assert.NotSame(t, previous, db.GetConfig())
+			}
+
+			// a refresh is only requested, the refresh loop fetches
+			assert.Equal(t, tt.expectedRefresh, len(db.refresh) == 1)
+			assert.Equal(t, fetched, fetches.Load())
+
+			blocked := []string{}
+			for domain := range db.blockListDatabase {
+				blocked = append(blocked, domain)
+			}
+			assert.ElementsMatch(t, tt.expectedBlocked, blocked)
+			assert.Contains(t, db.GetConfig().WhitelistDomains, tt.expectedWhitelist)
+		})
+	}
+}
+
+func Test_ReloadConfigLocked(t *testing.T) {
+	server, _ := blockListServer(t)
+	path := filepath.Join(t.TempDir(), "dumbdns.json")
+	require.NoError(t, os.WriteFile(path, []byte(reloadConfig(server.URL, "tracker.example.com")), 0o644))
+	db := Start(time.Minute)
+	require.NoError(t, db.LoadConfig(path))
+	previous := db.GetConfig()
+	require.NoError(t, os.WriteFile(path, []byte(reloadConfig(server.URL, "ads.example.com")), 0o644))
+
+	// the config isn't swapped while it's being read
+	db.configMux.RLock()
+	done := make(chan error)
+	go func() { done <- db.ReloadConfig() }()
+	time.Sleep(50 * time.Millisecond)
+	assert.Same(t, previous, db.Config)
+	db.configMux.RUnlock()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("config wasn't reloaded")
+	}
+	assert.Contains(t, db.GetConfig().WhitelistDomains, "ads.example.com")
+}
+
+func Test_WatchConfig(t *testing.T) {
+	server, _ := blockListServer(t)
+	path := filepath.Join(t.TempDir(), "dumbdns.json")
+	require.NoError(t, os.WriteFile(path, []byte(reloadConfig(server.URL, "tracker.example.com")), 0o644))
+	db := Start(time.Minute)
+	require.NoError(t, db.LoadConfig(path))
+	previous := db.GetConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.WatchConfig(ctx, 5*time.Millisecond)
+
+	modTimeOf := func() time.Time {
+		db.configMux.RLock()
+		defer db.configMux.RUnlock()
+		return db.configModTime
+	}
+
+	// a broken file is noted so it isn't tried again on every tick
+	broken := time.Now().Add(time.Minute).Truncate(time.Second)
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 1, "whitelist": [,]}`), 0o644))
+	require.NoError(t, os.Chtimes(path, broken, broken))
+	require.Eventually(t, func() bool { return modTimeOf().Equal(broken) }, 5*time.Second, 5*time.Millisecond)
+	assert.Same(t, previous, db.GetConfig())
+
+	// the fixed file is picked up
+	fixed := broken.Add(time.Minute)
+	require.NoError(t, os.WriteFile(path, []byte(reloadConfig(server.URL, "ads.example.com")), 0o644))
+	require.NoError(t, os.Chtimes(path, fixed, fixed))
+	require.Eventually(t, func() bool {
+		_, ok := db.GetConfig().WhitelistDomains["ads.example.com"]
+		return ok
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.True(t, modTimeOf().Equal(fixed))
+}
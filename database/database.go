@@ -2,7 +2,10 @@ package database
 
 import (
 	"errors"
+	"maps"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"dumbdns/models"
@@ -20,44 +23,152 @@ type Database struct {
 	dbMux             *sync.RWMutex
 	blockMux          *sync.RWMutex
 	blockListDatabase map[string]interface{}
+	groupBlockLists   map[string]map[string]interface{}
+	// scheduleBlockLists are keyed by schedule name
+	scheduleBlockLists map[string]map[string]interface{}
+	sourceDatabase     map[models.Sources]map[string]interface{}
+	sourceCounts       map[string]int
+	lastRefresh        time.Time
+	refresh            chan struct{}
+	loaded             chan struct{}
+	loadedOnce         *sync.Once
 
-	Config *models.Config
+	configMux     *sync.RWMutex
+	configPath    string
+	configModTime time.Time
+	Config        *models.Config
+
+	acl             atomic.Pointer[clientACL]
+	groups          atomic.Pointer[clientGroups]
+	parsedSchedules atomic.Pointer[schedules]
+
+	// localData is read from the zone and hosts files of the config
+	localData atomic.Pointer[localData]
+	leases    atomic.Pointer[leases]
+
+	pauseMux *sync.Mutex
+	// pauses hold when blocking turns back on, keyed by client IP or
+	// the empty string for every client
+	pauses map[string]time.Time
+
+	// now is replaced in tests to check schedules at a given time
+	now func() time.Time
 }
 
 func Start(ttl time.Duration) *Database {
 	db := &Database{
-		TTL:               ttl,
-		dbMux:             &sync.RWMutex{},
-		blockMux:          &sync.RWMutex{},
-		database:          map[string]*models.Record{},
-		blockListDatabase: map[string]interface{}{},
+		TTL:                ttl,
+		dbMux:              &sync.RWMutex{},
+		blockMux:           &sync.RWMutex{},
+		configMux:          &sync.RWMutex{},
+		database:           map[string]*models.Record{},
+		blockListDatabase:  map[string]interface{}{},
+		groupBlockLists:    map[string]map[string]interface{}{},
+		scheduleBlockLists: map[string]map[string]interface{}{},
+		sourceDatabase:     map[models.Sources]map[string]interface{}{},
+		refresh:            make(chan struct{}, 1),
+		loaded:             make(chan struct{}),
+		loadedOnce:         &sync.Once{},
+		pauseMux:           &sync.Mutex{},
+		pauses:             map[string]time.Time{},
+		now:                time.Now,
 	}
+	db.localData.Store(&localData{})
+	db.leases.Store(&leases{})
 
 	return db
 }
 
-func (db *Database) GetRecord(address string, queryType dns.Type) (*models.Record, error) {
+// LoadConfig reads the config file at path, or dumbdns.json when path
+// is empty. It must be called before the block list is updated or any
+// records are requested.
+func (db *Database) LoadConfig(path string) error {
+	if path == "" {
+		path = configPath()
+	}
+	config, local, err := checkConfig(path)
+	if err != nil {
+		return err
+	}
+
+	db.configMux.Lock()
+	db.configPath = path
+	db.configModTime = configModTime(path, config)
+	db.Config = config
+	db.configMux.Unlock()
+	db.localData.Store(local)
+
+	return nil
+}
+
+// GetConfig returns the current config. The returned config must be
+// treated as read only, changes are made through updateConfig.
+func (db *Database) GetConfig() *models.Config {
+	db.configMux.RLock()
+	defer db.configMux.RUnlock()
+
+	return db.Config
+}
+
+// GetRecord looks up address in the hosts file, block list and cache,
+// using the policy of the group client belongs to. The returned outcome
+// tells the caller which of them answered.
+func (db *Database) GetRecord(client netip.Addr, address string, queryType dns.Type) (*models.Record, models.Outcome, error) {
+	group := db.ClientGroup(client)
+	p := groupPolicy(db.GetConfig(), group)
+
 	// Check custom hosts file for host:ip mapping file
 	// e.g: archive.is blocks CloudFlare DNS, so we add
 	// a manual mapping to get around that.
-	if ip, ok := db.Config.Hosts[address]; ok {
-		return &models.Record{A: []string{ip}}, nil
+	if entry, ok := hostEntry(p.hosts, address); ok {
+		return hostRecord(entry, queryType), models.OutcomeHosts, nil
+	}
+
+	// hostsFiles apply to every group, after its own overrides
+	local := db.local()
+	if entry, ok := local.hosts[address]; ok {
+		return hostRecord(entry, queryType), models.OutcomeHosts, nil
+	}
+	// DHCP clients can't take the name of a configured host
+	dhcp := db.leases.Load()
+	if entry, ok := dhcp.hosts[address]; ok {
+		return hostRecord(entry, queryType), models.OutcomeHosts, nil
 	}
 
-	db.blockMux.RLock()
-	// Check if in block list
-	if _, blocked := db.blockListDatabase[address]; blocked {
+	// reverse lookups of the overrides, then of the files and leases
+	if names := reverseHosts(p.hosts, address); len(names) > 0 {
+		return &models.Record{PTR: names}, models.OutcomeHosts, nil
+	}
+	if names, ok := local.ptr[address]; ok {
+		return &models.Record{PTR: names}, models.OutcomeHosts, nil
+	}
+	if names, ok := dhcp.ptr[address]; ok {
+		return &models.Record{PTR: names}, models.OutcomeHosts, nil
+	}
+
+	// blocking can be paused for a while, see PauseBlocking
+	if !db.blockingPaused(client) {
+		db.blockMux.RLock()
+		blockList := db.blockListDatabase
+		if group != "" {
+			blockList = db.groupBlockLists[group]
+		}
+		// Check if in block list
+		_, blocked := blockList[address]
 		db.blockMux.RUnlock()
-		return &models.Record{
-			A:     []string{"127.0.0.1"},
-			AAAA:  []string{"::1"},
-			NS:    []string{"localhost"},
-			MX:    []string{"localhost"},
-			SRV:   []string{"_http._tcp.local."},
-			CNAME: "localhost",
-		}, nil
+		if blocked {
+			return blockedRecord(p.blockMode), models.OutcomeBlocked, nil
+		}
+
+		// the whitelist also applies to schedules
+		if _, whitelisted := p.whitelist[address]; !whitelisted && db.scheduleBlocked(group, address) {
+			return blockedRecord(p.blockMode), models.OutcomeBlocked, nil
+		}
+	}
+
+	if target, ok := safeSearchTarget(address); ok && p.safeSearch {
+		return &models.Record{CNAME: target}, models.OutcomeSafeSearch, nil
 	}
-	db.blockMux.RUnlock()
 
 	// Now we can safely lock the database for record checking
 	db.dbMux.RLock()
@@ -69,15 +180,15 @@ func (db *Database) GetRecord(address string, queryType dns.Type) (*models.Recor
 			db.dbMux.Lock() // Now acquire the write lock
 			delete(db.database, address)
 			db.dbMux.Unlock() // Unlock the write lock after deleting
-			return nil, ErrNotFound
+			return nil, "", ErrNotFound
 		}
 
-		if hasQueryType(record, queryType) {
-			return record, nil
+		if hasQueryType(record, queryType) && db.validated(record, queryType) {
+			return record, models.OutcomeCached, nil
 		}
 	}
 
-	return nil, ErrNotFound
+	return nil, "", ErrNotFound
 }
 
 func hasQueryType(r *models.Record, queryType dns.Type) bool {
@@ -98,16 +209,28 @@ func hasQueryType(r *models.Record, queryType dns.Type) bool {
 		return len(r.SRV) > 0
 	case models.TypeKX:
 		return len(r.KX) > 0
+	case dns.TypeTXT:
+		return len(r.TXT) > 0
+	case dns.TypePTR:
+		return len(r.PTR) > 0
 	case dns.TypeCNAME:
 		return r.CNAME != ""
+	case dns.TypeSOA:
+		return r.SOA != ""
 	default:
 		return false
 	}
 }
 
 func (db *Database) AddRecord(now time.Time, address string, queryType dns.Type, recordValue []string) (*models.Record, error) {
-	db.dbMux.RLock()
-	defer db.dbMux.RUnlock()
+	return db.addRecord(now, address, queryType, recordValue, "", nil)
+}
+
+// addRecord caches recordValue along with its DNSSEC validation state
+// and signatures, an empty state for answers that weren't validated
+func (db *Database) addRecord(now time.Time, address string, queryType dns.Type, recordValue []string, state string, signatures []string) (*models.Record, error) {
+	db.dbMux.Lock()
+	defer db.dbMux.Unlock()
 	record, ok := db.database[address]
 	if !ok {
 		// We create a new record to be populated
@@ -127,14 +250,49 @@ func (db *Database) AddRecord(now time.Time, address string, queryType dns.Type,
 		record.SRV = recordValue
 	case models.TypeKX:
 		record.KX = recordValue
+	case dns.TypeTXT:
+		record.TXT = recordValue
+	case dns.TypePTR:
+		record.PTR = recordValue
 	case dns.TypeCNAME:
 		if len(recordValue) == 1 {
 			record.CNAME = recordValue[0]
 		}
+	case dns.TypeSOA:
+		if len(recordValue) == 1 {
+			record.SOA = recordValue[0]
+		}
 	default:
 		return nil, errors.New("could not update value for query type")
 	}
 
+	if state != "" || record.DNSSEC[queryType] != "" {
+		// the states are replaced rather than updated, as the record
+		// may be read while it's being answered
+		states := maps.Clone(record.DNSSEC)
+		if states == nil {
+			states = map[dns.Type]string{}
+		}
+		if state == "" {
+			delete(states, queryType)
+		} else {
+			states[queryType] = state
+		}
+		record.DNSSEC = states
+	}
+	if len(signatures) > 0 || len(record.RRSIG[queryType]) > 0 {
+		rrsigs := maps.Clone(record.RRSIG)
+		if rrsigs == nil {
+			rrsigs = map[dns.Type][]string{}
+		}
+		if len(signatures) == 0 {
+			delete(rrsigs, queryType)
+		} else {
+			rrsigs[queryType] = signatures
+		}
+		record.RRSIG = rrsigs
+	}
+
 	if record.ExpiresAt.IsZero() {
 		record.ExpiresAt = now.Add(db.TTL)
 	}
@@ -0,0 +1,23 @@
+package database
+
+import (
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+)
+
+// AddValidatedRecord caches recordValue like AddRecord, along with the
+// DNSSEC validation state of the answer, e.g: models.DNSSECSecure, and
+// the RRSIG records that signed it
+func (db *Database) AddValidatedRecord(now time.Time, address string, queryType dns.Type, recordValue []string, state string, signatures []string) (*models.Record, error) {
+	return db.addRecord(now, address, queryType, recordValue, state, signatures)
+}
+
+// validated reports whether the cached answers of queryType can be
+// served. With DNSSEC validation on, answers cached before it was turned
+// on have no state and are looked up again.
+func (db *Database) validated(record *models.Record, queryType dns.Type) bool {
+	return !db.GetConfig().DNSSEC.Validate || record.DNSSEC[queryType] != ""
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validatedRecord(t *testing.T) {
+	db := Start(time.Minute)
+	db.Config = &models.Config{}
+	client := netip.MustParseAddr("127.0.0.1")
+
+	_, err := db.AddRecord(time.Now(), "example.com", dns.TypeA, []string{"192.0.2.1"})
+	require.NoError(t, err)
+	_, err = db.AddValidatedRecord(time.Now(), "example.com", dns.TypeAAAA, []string{"2001:db8::1"}, models.DNSSECSecure, nil)
+	require.NoError(t, err)
+
+	// answers cached before validation was turned on are looked up again
+	db.Config = &models.Config{DNSSEC: models.DNSSECConfig{Validate: true}}
+	_, _, err = db.GetRecord(client, "example.com", dns.TypeA)
+	assert.ErrorIs(t, err, ErrNotFound)
+	record, outcome, err := db.GetRecord(client, "example.com", dns.TypeAAAA)
+	require.NoError(t, err)
+	assert.Equal(t, models.OutcomeCached, outcome)
+	states := record.DNSSEC
+	assert.Equal(t, models.DNSSECSecure, states[dns.TypeAAAA])
+
+	// an answer that wasn't validated replaces the state
+	_, err = db.AddRecord(time.Now(), "example.com", dns.TypeAAAA, []string{"2001:db8::2"})
+	require.NoError(t, err)
+	_, _, err = db.GetRecord(client, "example.com", dns.TypeAAAA)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Empty(t, record.DNSSEC)
+	assert.Equal(t, models.DNSSECSecure, states[dns.TypeAAAA], "states are copied on write")
+}
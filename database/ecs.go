@@ -0,0 +1,93 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+)
+
+// ClientSubnet returns the subnet sent upstream in the EDNS Client
+// Subnet option for client, following the ECS policy of its group.
+// requested is the subnet the client sent, if any. An invalid prefix
+// means no subnet is sent.
+func (db *Database) ClientSubnet(client netip.Addr, requested netip.Prefix) netip.Prefix {
+	p := groupPolicy(db.GetConfig(), db.ClientGroup(client))
+
+	switch p.ecs.Mode {
+	case models.ECSPassThrough:
+		return requested.Masked()
+	case models.ECSReplace:
+		subnet, err := netip.ParsePrefix(p.ecs.Subnet)
+		if err != nil {
+			return netip.Prefix{}
+		}
+		return subnet.Masked()
+	default:
+		return netip.Prefix{}
+	}
+}
+
+// CacheKey returns the cache key of an answer for address. Answers
+// tailored to a client subnet are cached apart, keyed by the part of
+// subnet they apply to, e.g: "example.com|203.0.113.0/24". scope is the
+// prefix length the answer applies to, 0 when it applies to everyone.
+func CacheKey(address string, subnet netip.Prefix, scope int) string {
+	if !subnet.IsValid() || scope == 0 {
+		return address
+	}
+	// an answer can't be more specific than the subnet that was sent
+	scoped := netip.PrefixFrom(subnet.Addr(), min(scope, subnet.Bits())).Masked()
+
+	return address + "|" + scoped.String()
+}
+
+// GetSubnetRecord returns the cached answer for address tailored to a
+// subnet that contains subnet. Answers that apply to everyone are
+// returned by GetRecord.
+func (db *Database) GetSubnetRecord(address string, subnet netip.Prefix, queryType dns.Type) (*models.Record, bool) {
+	if !subnet.IsValid() {
+		return nil, false
+	}
+
+	now := time.Now()
+	db.dbMux.RLock()
+	defer db.dbMux.RUnlock()
+	for bits := subnet.Bits(); bits > 0; bits-- {
+		record, ok := db.database[CacheKey(address, subnet, bits)]
+		if ok && now.Before(record.ExpiresAt) && hasQueryType(record, queryType) && db.validated(record, queryType) {
+			return record, true
+		}
+	}
+
+	return nil, false
+}
+
+// isSubnetKey reports whether key is the cache key of an answer for
+// address tailored to a subnet
+func isSubnetKey(key string, address string) bool {
+	return strings.HasPrefix(key, address+"|")
+}
+
+// validateECS checks the ECS policy of the top level config or a group
+func validateECS(prefix string, ecs models.ECSConfig, add func(field string, value string, err error)) {
+	switch ecs.Mode {
+	case "", models.ECSStrip, models.ECSPassThrough:
+		if ecs.Subnet != "" {
+			add(prefix+"ecs.subnet", "", errors.New("a subnet is only used in replace mode"))
+		}
+	case models.ECSReplace:
+		if ecs.Subnet == "" {
+			add(prefix+"ecs.subnet", "", errors.New("replace mode needs a subnet"))
+		} else if _, err := netip.ParsePrefix(ecs.Subnet); err != nil {
+			add(prefix+"ecs.subnet", "", fmt.Errorf("invalid CIDR %q", ecs.Subnet))
+		}
+	default:
+		add(prefix+"ecs.mode", "", fmt.Errorf("unknown ECS mode %q, expected strip, passthrough or replace", ecs.Mode))
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_clientSubnet(t *testing.T) {
+	db := Start(0)
+	db.Config = &models.Config{
+		ECS: models.ECSConfig{Mode: models.ECSPassThrough},
+		Groups: map[string]models.GroupConfig{
+			"office": {
+				Clients: []string{"10.0.0.0/8"},
+				ECS:     &models.ECSConfig{Mode: models.ECSReplace, Subnet: "198.51.100.7/24"},
+			},
+			"private": {
+				Clients: []string{"192.168.1.0/24"},
+				ECS:     &models.ECSConfig{Mode: models.ECSStrip},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		client    string
+		requested string
+		expected  string
+	}{
+		{name: "Pass through", client: "172.16.0.5", requested: "203.0.113.77/24", expected: "203.0.113.0/24"},
+		{name: "Pass through without a subnet", client: "172.16.0.5"},
+		{name: "Replace", client: "10.0.0.5", requested: "203.0.113.77/24", expected: "198.51.100.0/24"},
+		{name: "Replace without a subnet", client: "10.0.0.5", expected: "198.51.100.0/24"},
+		{name: "Strip", client: "192.168.1.5", requested: "203.0.113.77/24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requested netip.Prefix
+			if tt.requested != "" {
+				requested = netip.MustParsePrefix(tt.requested)
+			}
+
+			actual := db.ClientSubnet(netip.MustParseAddr(tt.client), requested)
+			if tt.expected == "" {
+				assert.False(t, actual.IsValid())
+				return
+			}
+			assert.Equal(t, tt.expected, actual.String())
+		})
+	}
+}
+
+func Test_getSubnetRecord(t *testing.T) {
+	db := Start(time.Minute)
+	db.Config = &models.Config{}
+	subnet := netip.MustParsePrefix("203.0.113.0/24")
+
+	// the upstream says the answer applies to the whole /16
+	_, err := db.AddRecord(time.Now(), CacheKey("cdn.example.com", subnet, 16), dns.TypeA, []string{"192.0.2.1"})
+	require.NoError(t, err)
+	assert.Contains(t, db.database, "cdn.example.com|203.0.0.0/16")
+
+	tests := []struct {
+		name     string
+		subnet   string
+		expected bool
+	}{
+		{name: "Same subnet", subnet: "203.0.113.0/24", expected: true},
+		{name: "Subnet in the scope", subnet: "203.0.7.0/24", expected: true},
+		{name: "Subnet out of the scope", subnet: "198.51.100.0/24"},
+		{name: "Wider subnet than the scope", subnet: "203.0.0.0/8"},
+		{name: "No subnet"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subnet netip.Prefix
+			if tt.subnet != "" {
+				subnet = netip.MustParsePrefix(tt.subnet)
+			}
+
+			record, ok := db.GetSubnetRecord("cdn.example.com", subnet, dns.TypeA)
+			assert.Equal(t, tt.expected, ok)
+			if ok {
+				assert.Equal(t, []string{"192.0.2.1"}, record.A)
+			}
+		})
+	}
+
+	// an answer that isn't tailored is cached like any other
+	assert.Equal(t, "cdn.example.com", CacheKey("cdn.example.com", subnet, 0))
+	assert.Equal(t, "cdn.example.com", CacheKey("cdn.example.com", netip.Prefix{}, 24))
+	db.FlushRecord("cdn.example.com")
+	_, ok := db.GetSubnetRecord("cdn.example.com", subnet, dns.TypeA)
+	assert.False(t, ok)
+}
@@ -0,0 +1,347 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configVersion is the version of the config layout written by this
+// build. Older layouts are migrated when they are read.
+const configVersion = 1
+
+// v0Renames are the top level keys renamed in version 1. The README
+// documented "whitelist" while the code read "whiteList", so both are
+// accepted from version 0 files.
+var v0Renames = map[string]string{
+	"whiteList": "whitelist",
+}
+
+// configFormat returns "json", "yaml" or "toml" from the file extension
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	default:
+		return "json"
+	}
+}
+
+// decodeConfig decodes a config file of any format and version into
+// the current layout
+func decodeConfig(path string, data []byte) (configFile, error) {
+	config := configFile{}
+
+	raw := map[string]interface{}{}
+	var err error
+	switch configFormat(path) {
+	case "yaml":
+		err = yaml.Unmarshal(data, &raw)
+	case "toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		err = json.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return config, syntaxError(path, data, err)
+	}
+
+	migrated, err := migrateConfig(raw)
+	if err != nil {
+		return config, &ConfigError{Path: path, Field: "version", Err: err}
+	}
+
+	// current JSON files are decoded as is so type errors keep their
+	// offset, everything else goes through JSON to share the decoding
+	if configFormat(path) == "json" && !migrated {
+		err = json.Unmarshal(data, &config)
+		if err != nil {
+			return config, decodeError(path, data, err)
+		}
+		return config, nil
+	}
+
+	converted, err := json.Marshal(raw)
+	if err != nil {
+		return config, &ConfigError{Path: path, Err: fmt.Errorf("error converting config: %w", err)}
+	}
+	err = json.Unmarshal(converted, &config)
+	if err != nil {
+		return config, decodeError(path, nil, err)
+	}
+
+	return config, nil
+}
+
+// migrateConfig upgrades raw to the current version in place, reporting
+// whether anything changed
+func migrateConfig(raw map[string]interface{}) (bool, error) {
+	version := 0
+	if v, ok := raw["version"]; ok {
+		switch n := v.(type) {
+		case float64:
+			version = int(n)
+		case int:
+			version = n
+		case int64:
+			version = int(n)
+		default:
+			return false, fmt.Errorf("version must be a number, got %v", v)
+		}
+	}
+
+	switch {
+	case version == configVersion:
+		return false, nil
+	case version > configVersion:
+		return false, fmt.Errorf("unsupported version %d, the newest supported is %d", version, configVersion)
+	}
+
+	// version 0 to 1
+	for from, to := range v0Renames {
+		value, ok := raw[from]
+		if !ok {
+			continue
+		}
+		delete(raw, from)
+		if existing, ok := raw[to].([]interface{}); ok {
+			if list, ok := value.([]interface{}); ok {
+				value = append(existing, list...)
+			}
+		}
+		raw[to] = value
+	}
+	raw["version"] = configVersion
+
+	return true, nil
+}
+
+// encodeConfig encodes config in the format of path. previous is the
+// file being replaced, YAML files keep its comments.
+func encodeConfig(path string, config configFile, previous []byte) ([]byte, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	format := configFormat(path)
+	if format == "json" {
+		return append(data, '\n'), nil
+	}
+
+	// YAML and TOML are written from the JSON layout so every format
+	// uses the same keys
+	raw := map[string]interface{}{}
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "yaml" {
+		return encodeYAML(raw, previous)
+	}
+	buf := &bytes.Buffer{}
+	err = toml.NewEncoder(buf).Encode(raw)
+
+	return buf.Bytes(), err
+}
+
+// encodeYAML encodes raw, merged into the document previous when it can
+// be parsed so its comments are kept
+func encodeYAML(raw map[string]interface{}, previous []byte) ([]byte, error) {
+	updated := &yaml.Node{}
+	err := updated.Encode(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &yaml.Node{}
+	if yaml.Unmarshal(previous, doc) != nil || len(doc.Content) == 0 {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{updated}}
+	} else {
+		renameKeys(doc.Content[0])
+		doc.Content[0] = mergeYAML(doc.Content[0], updated)
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	err = encoder.Close()
+
+	return buf.Bytes(), err
+}
+
+// renameKeys applies v0Renames to the top level keys of a previous
+// document, so comments follow an entry to its new key
+func renameKeys(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	keys := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys[node.Content[i].Value] = true
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if to, ok := v0Renames[key.Value]; ok && !keys[to] {
+			key.Value = to
+		}
+	}
+}
+
+// mergeYAML updates previous to the values of updated, keeping the
+// comments, styles and order of the entries that are in both. Mapping
+// entries are matched by key and lists of scalars by value, e.g: a
+// whitelist entry keeps its comment wherever it's sorted to. Anything
+// else in a list is matched by position.
+func mergeYAML(previous *yaml.Node, updated *yaml.Node) *yaml.Node {
+	if previous.Kind != updated.Kind {
+		updated.HeadComment = previous.HeadComment
+		updated.LineComment = previous.LineComment
+		updated.FootComment = previous.FootComment
+		return updated
+	}
+
+	switch updated.Kind {
+	case yaml.MappingNode:
+		values := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(updated.Content); i += 2 {
+			values[updated.Content[i].Value] = updated.Content[i+1]
+		}
+		content := make([]*yaml.Node, 0, len(updated.Content))
+		kept := map[string]bool{}
+		for i := 0; i+1 < len(previous.Content); i += 2 {
+			key := previous.Content[i]
+			value, ok := values[key.Value]
+			if !ok {
+				continue
+			}
+			kept[key.Value] = true
+			content = append(content, key, mergeYAML(previous.Content[i+1], value))
+		}
+		for i := 0; i+1 < len(updated.Content); i += 2 {
+			if !kept[updated.Content[i].Value] {
+				content = append(content, updated.Content[i], updated.Content[i+1])
+			}
+		}
+		previous.Content = content
+	case yaml.SequenceNode:
+		used := make([]bool, len(previous.Content))
+		content := make([]*yaml.Node, 0, len(updated.Content))
+		for i, element := range updated.Content {
+			match := -1
+			for j, old := range previous.Content {
+				if used[j] || old.Kind != element.Kind {
+					continue
+				}
+				if element.Kind == yaml.ScalarNode && old.Value == element.Value || element.Kind != yaml.ScalarNode && i == j {
+					match = j
+					break
+				}
+			}
+			if match == -1 {
+				content = append(content, element)
+				continue
+			}
+			used[match] = true
+			content = append(content, mergeYAML(previous.Content[match], element))
+		}
+		previous.Content = content
+	case yaml.ScalarNode:
+		if previous.Value != updated.Value || previous.ShortTag() != updated.ShortTag() {
+			previous.Value = updated.Value
+			previous.Tag = updated.Tag
+			previous.Style = updated.Style
+		}
+	default:
+		return updated
+	}
+
+	return previous
+}
+
+// syntaxError adds the line to a parse error where the format gives one
+func syntaxError(path string, data []byte, err error) error {
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		return &ConfigError{Path: path, Line: parseErr.Position.Line, Err: err}
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return decodeError(path, data, err)
+	}
+
+	// yaml errors already name the line
+	return &ConfigError{Path: path, Err: err}
+}
+
+// configLines maps field paths to lines for the formats that keep track
+// of them. Keys renamed by a migration are mapped to their new name.
+func configLines(path string, data []byte) map[string]int {
+	var lines map[string]int
+	switch configFormat(path) {
+	case "yaml":
+		lines = yamlLines(data)
+	case "toml":
+		return map[string]int{}
+	default:
+		lines = jsonLines(data)
+	}
+
+	for field, line := range lines {
+		for from, to := range v0Renames {
+			if rest, ok := strings.CutPrefix(field, from); ok && (rest == "" || strings.IndexByte("[.=", rest[0]) >= 0) {
+				lines[to+rest] = line
+			}
+		}
+	}
+
+	return lines
+}
+
+// yamlLines is jsonLines for YAML documents
+func yamlLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	root := yaml.Node{}
+	if yaml.Unmarshal(data, &root) != nil || len(root.Content) == 0 {
+		return lines
+	}
+
+	var walk func(path string, node *yaml.Node)
+	walk = func(path string, node *yaml.Node) {
+		if path != "" {
+			lines[path] = node.Line
+		}
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				walk(childPath(path, key.Value), value)
+				// point at the key rather than the value of nested blocks
+				lines[childPath(path, key.Value)] = key.Line
+			}
+		case yaml.SequenceNode:
+			for i, element := range node.Content {
+				walk(fmt.Sprintf("%s[%d]", path, i), element)
+				if element.Kind == yaml.ScalarNode {
+					lines[path+"="+element.Value] = element.Line
+				}
+			}
+		}
+	}
+	walk("", root.Content[0])
+
+	return lines
+}
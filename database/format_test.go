@@ -0,0 +1,95 @@
+package database
+
+import (
+	"testing"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_decodeConfig(t *testing.T) {
+	expected := configFile{
+		Version:          1,
+		BlockLists:       []models.Sources{{Regex: `0.0.0.0\s+(?P<url>\S+)`, Url: "https://example.com/hosts"}},
+		WhitelistDomains: []string{"i.scdn.co"},
+		Hosts:            map[string]models.HostEntry{"archive.is": models.HostIPs("23.137.248.133")},
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		data        string
+		expected    configFile
+		expectedErr string
+	}{
+		{
+			name: "version 0 JSON with whiteList",
+			path: "dumbdns.json",
+			data: `{
+  "blockLists": [{"regex": "0.0.0.0\\s+(?P<url>\\S+)", "url": "https://example.com/hosts"}],
+  "whiteList": ["i.scdn.co"],
+  "hostsFile": {"archive.is": "23.137.248.133"}
+}`,
+			expected: expected,
+		},
+		{
+			name: "version 0 JSON with whitelist as shown in the README",
+			path: "dumbdns.json",
+			data: `{
+  "blockLists": [{"regex": "0.0.0.0\\s+(?P<url>\\S+)", "url": "https://example.com/hosts"}],
+  "whitelist": ["i.scdn.co"],
+  "hostsFile": {"archive.is": "23.137.248.133"}
+}`,
+			expected: expected,
+		},
+		{
+			name: "version 1 YAML",
+			path: "dumbdns.yaml",
+			data: `version: 1
+blockLists:
+  - regex: '0.0.0.0\s+(?P<url>\S+)'
+    url: https://example.com/hosts
+whitelist:
+  - i.scdn.co # spotify album art
+hostsFile:
+  archive.is: 23.137.248.133
+`,
+			expected: expected,
+		},
+		{
+			name: "version 1 TOML",
+			path: "dumbdns.toml",
+			data: `version = 1
+whitelist = [
+  "i.scdn.co", # spotify album art
+]
+
+[[blockLists]]
+regex = '0.0.0.0\s+(?P<url>\S+)'
+url = "https://example.com/hosts"
+
+[hostsFile]
+"archive.is" = "23.137.248.133"
+`,
+			expected: expected,
+		},
+		{
+			name:        "newer version",
+			path:        "dumbdns.json",
+			data:        `{"version": 2}`,
+			expectedErr: "dumbdns.json: version: unsupported version 2, the newest supported is 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := decodeConfig(tt.path, []byte(tt.data))
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
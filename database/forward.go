@@ -0,0 +1,24 @@
+package database
+
+import "strings"
+
+// ForwardZone returns the upstreams of the longest forward zone address
+// is in, or nil when it isn't in any
+func (db *Database) ForwardZone(address string) []string {
+	zones := db.GetConfig().ForwardZones
+	if len(zones) == 0 {
+		return nil
+	}
+
+	var upstreams []string
+	longest := -1
+	for zone, zoneUpstreams := range zones {
+		zone = CleanDomain(zone)
+		if (address == zone || strings.HasSuffix(address, "."+zone)) && len(zone) > longest {
+			upstreams = zoneUpstreams
+			longest = len(zone)
+		}
+	}
+
+	return upstreams
+}
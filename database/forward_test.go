@@ -0,0 +1,36 @@
+package database
+
+import (
+	"testing"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_forwardZone(t *testing.T) {
+	db := Start(0)
+	db.Config = &models.Config{ForwardZones: map[string][]string{
+		"corp.internal":     {"udp://10.0.0.2"},
+		"lab.corp.internal": {"tcp://10.0.1.2"},
+		"10.in-addr.arpa.":  {"udp://10.0.0.2"},
+	}}
+
+	tests := []struct {
+		address  string
+		expected []string
+	}{
+		{address: "corp.internal", expected: []string{"udp://10.0.0.2"}},
+		{address: "wiki.corp.internal", expected: []string{"udp://10.0.0.2"}},
+		{address: "host.lab.corp.internal", expected: []string{"tcp://10.0.1.2"}},
+		{address: "5.0.0.10.in-addr.arpa", expected: []string{"udp://10.0.0.2"}},
+		{address: "notcorp.internal", expected: nil},
+		{address: "example.com", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			assert.Equal(t, tt.expected, db.ForwardZone(tt.address))
+		})
+	}
+}
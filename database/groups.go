@@ -0,0 +1,161 @@
+package database
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+
+	"dumbdns/models"
+
+	"github.com/miekg/dns"
+)
+
+// policy is the filtering policy of a group, with everything the group
+// leaves out filled in from the top level config
+type policy struct {
+	blocklists []models.Sources
+	blocked    map[string]interface{}
+	whitelist  map[string]interface{}
+	hosts      map[string]models.HostEntry
+	blockMode  string
+	safeSearch bool
+	ecs        models.ECSConfig
+}
+
+// groupPolicy returns the policy of the named group, or of the default
+// group when name is empty
+func groupPolicy(config *models.Config, name string) policy {
+	p := policy{
+		blocklists: config.Blocklists,
+		blocked:    config.BlockedDomains,
+		whitelist:  config.WhitelistDomains,
+		hosts:      config.Hosts,
+		blockMode:  cmp.Or(config.BlockMode, models.BlockModeLocalhost),
+		safeSearch: config.SafeSearch,
+		ecs:        config.ECS,
+	}
+
+	group, ok := config.Groups[name]
+	if !ok {
+		return p
+	}
+	if group.Blocklists != nil {
+		p.blocklists = group.Blocklists
+	}
+	if group.BlockedDomains != nil {
+		p.blocked = group.BlockedDomains
+	}
+	if group.WhitelistDomains != nil {
+		p.whitelist = group.WhitelistDomains
+	}
+	if group.Hosts != nil {
+		p.hosts = group.Hosts
+	}
+	if group.BlockMode != "" {
+		p.blockMode = group.BlockMode
+	}
+	if group.SafeSearch != nil {
+		p.safeSearch = *group.SafeSearch
+	}
+	if group.ECS != nil {
+		p.ecs = *group.ECS
+	}
+
+	return p
+}
+
+// blockedRecord is the answer given for a blocked domain
+func blockedRecord(blockMode string) *models.Record {
+	switch blockMode {
+	case models.BlockModeNull:
+		return &models.Record{
+			A:    []string{"0.0.0.0"},
+			AAAA: []string{"::"},
+		}
+	case models.BlockModeNXDomain:
+		return &models.Record{Rcode: dns.RcodeNameError}
+	case models.BlockModeRefused:
+		return &models.Record{Rcode: dns.RcodeRefused}
+	default:
+		return &models.Record{
+			A:     []string{"127.0.0.1"},
+			AAAA:  []string{"::1"},
+			NS:    []string{"localhost"},
+			MX:    []string{"localhost"},
+			SRV:   []string{"_http._tcp.local."},
+			CNAME: "localhost",
+		}
+	}
+}
+
+// groupPrefix matches the clients of a group
+type groupPrefix struct {
+	prefix netip.Prefix
+	group  string
+}
+
+// clientGroups is the parsed group membership of a single config version
+type clientGroups struct {
+	config   *models.Config
+	prefixes []groupPrefix
+}
+
+// ClientGroup returns the name of the group addr belongs to, or an empty
+// string for the default group. When a client matches more than one
+// group the most specific match wins.
+func (db *Database) ClientGroup(addr netip.Addr) string {
+	groups := db.clientGroups()
+	addr = addr.Unmap().WithZone("")
+
+	for _, gp := range groups.prefixes {
+		if gp.prefix.Contains(addr) {
+			return gp.group
+		}
+	}
+
+	return ""
+}
+
+// clientGroups returns the group membership of the current config,
+// parsing it the first time the config is seen
+func (db *Database) clientGroups() *clientGroups {
+	config := db.GetConfig()
+	groups := db.groups.Load()
+	if groups != nil && groups.config == config {
+		return groups
+	}
+
+	groups = &clientGroups{config: config}
+	for _, name := range sortedKeys(config.Groups) {
+		for _, client := range config.Groups[name].Clients {
+			for _, prefix := range clientPrefixes(config, client) {
+				groups.prefixes = append(groups.prefixes, groupPrefix{prefix: prefix, group: name})
+			}
+		}
+	}
+	// the most specific prefix is checked first, the sort is stable so
+	// ties go to the first group by name
+	slices.SortStableFunc(groups.prefixes, func(a, b groupPrefix) int {
+		return b.prefix.Bits() - a.prefix.Bits()
+	})
+	db.groups.Store(groups)
+
+	return groups
+}
+
+// clientPrefixes returns the prefixes of a group client entry. Names are
+// looked up in the hosts file, where they can have several addresses.
+func clientPrefixes(config *models.Config, client string) []netip.Prefix {
+	if prefix, err := parsePrefix(client); err == nil {
+		return []netip.Prefix{prefix}
+	}
+
+	var prefixes []netip.Prefix
+	for _, ip := range config.Hosts[CleanDomain(client)].IPs() {
+		if prefix, err := parsePrefix(ip); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	miekg "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getRecordGroups(t *testing.T) {
+	ads := models.Sources{Regex: `(?P<url>\S+)`, Url: "https://example.com/ads"}
+	social := models.Sources{Regex: `(?P<url>\S+)`, Url: "https://example.com/social"}
+
+	config := &models.Config{
+		Blocklists:       []models.Sources{ads},
+		WhitelistDomains: map[string]interface{}{},
+		BlockedDomains:   map[string]interface{}{},
+		Hosts:            map[string]models.HostEntry{"nas.lan": models.HostIPs("192.168.0.10"), "ipad.lan": models.HostIPs("192.168.0.50")},
+		Groups: map[string]models.GroupConfig{
+			"kids": {
+				Clients:    []string{"192.168.1.0/24", "ipad.lan"},
+				Blocklists: []models.Sources{ads, social},
+				BlockMode:  models.BlockModeNXDomain,
+			},
+			"servers": {
+				Clients:          []string{"192.168.0.0/16"},
+				Blocklists:       []models.Sources{},
+				WhitelistDomains: map[string]interface{}{"ads.com": struct{}{}},
+				Hosts:            map[string]models.HostEntry{"nas.lan": models.HostIPs("10.0.0.10")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		client          string
+		address         string
+		expectedGroup   string
+		expectedRecord  *models.Record
+		expectedOutcome models.Outcome
+		expectedErr     error
+	}{
+		{
+			name:            "Default group blocks ads",
+			client:          "10.0.0.5",
+			address:         "ads.com",
+			expectedRecord:  blockedRecord(models.BlockModeLocalhost),
+			expectedOutcome: models.OutcomeBlocked,
+		},
+		{
+			name:        "Default group allows social",
+			client:      "10.0.0.5",
+			address:     "social.com",
+			expectedErr: ErrNotFound,
+		},
+		{
+			name:            "Default hosts",
+			client:          "10.0.0.5",
+			address:         "nas.lan",
+			expectedRecord:  &models.Record{A: []string{"192.168.0.10"}},
+			expectedOutcome: models.OutcomeHosts,
+		},
+		{
+			name:            "Kids block social with their block mode",
+			client:          "192.168.1.20",
+			address:         "social.com",
+			expectedGroup:   "kids",
+			expectedRecord:  &models.Record{Rcode: miekg.RcodeNameError},
+			expectedOutcome: models.OutcomeBlocked,
+		},
+		{
+			name:            "Kids matched by name",
+			client:          "192.168.0.50",
+			address:         "social.com",
+			expectedGroup:   "kids",
+			expectedRecord:  &models.Record{Rcode: miekg.RcodeNameError},
+			expectedOutcome: models.OutcomeBlocked,
+		},
+		{
+			name:            "Kids inherit the default hosts",
+			client:          "192.168.1.20",
+			address:         "nas.lan",
+			expectedGroup:   "kids",
+			expectedRecord:  &models.Record{A: []string{"192.168.0.10"}},
+			expectedOutcome: models.OutcomeHosts,
+		},
+		{
+			name:          "Servers have no block lists",
+			client:        "192.168.5.5",
+			address:       "ads.com",
+			expectedGroup: "servers",
+			expectedErr:   ErrNotFound,
+		},
+		{
+			name:            "Servers have their own hosts",
+			client:          "192.168.5.5",
+			address:         "nas.lan",
+			expectedGroup:   "servers",
+			expectedRecord:  &models.Record{A: []string{"10.0.0.10"}},
+			expectedOutcome: models.OutcomeHosts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := Start(0)
+			db.Config = config
+			db.sourceDatabase = map[models.Sources]map[string]interface{}{
+				ads:    {"ads.com": struct{}{}},
+				social: {"social.com": struct{}{}},
+			}
+			db.applyBlockList()
+
+			client := netip.MustParseAddr(tt.client)
+			assert.Equal(t, tt.expectedGroup, db.ClientGroup(client))
+
+			record, outcome, err := db.GetRecord(client, tt.address, dns.TypeA)
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Equal(t, tt.expectedRecord, record)
+			assert.Equal(t, tt.expectedOutcome, outcome)
+		})
+	}
+}
+
+func Test_groupsRoundTrip(t *testing.T) {
+	config := &models.Config{
+		WhitelistDomains: map[string]interface{}{},
+		BlockedDomains:   map[string]interface{}{},
+		Hosts:            map[string]models.HostEntry{},
+		Groups: map[string]models.GroupConfig{
+			"kids": {
+				Clients:   []string{"192.168.1.0/24"},
+				BlockMode: models.BlockModeNull,
+			},
+			"servers": {
+				Clients:          []string{"192.168.2.0/24"},
+				Blocklists:       []models.Sources{},
+				WhitelistDomains: map[string]interface{}{},
+			},
+		},
+	}
+
+	for _, name := range []string{"dumbdns.json", "dumbdns.yaml", "dumbdns.toml"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, writeConfigToDisk(path, config))
+
+			actual, _, err := readConfigFromDisk(path)
+			require.NoError(t, err)
+			// empty lists replace the default, missing ones inherit it
+			assert.Equal(t, config.Groups, actual.Groups)
+		})
+	}
+}
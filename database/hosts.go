@@ -0,0 +1,90 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+)
+
+// hostEntry returns the hosts override of address. An exact match wins
+// over a wildcard, and the closest wildcard wins, e.g: "*.api.dev.lan"
+// over "*.dev.lan".
+func hostEntry(hosts map[string]models.HostEntry, address string) (models.HostEntry, bool) {
+	if entry, ok := hosts[address]; ok {
+		return entry, true
+	}
+
+	// a wildcard only matches names below it, not the name itself
+	for parent := address; ; {
+		_, rest, ok := strings.Cut(parent, ".")
+		if !ok {
+			return models.HostEntry{}, false
+		}
+		if entry, ok := hosts["*."+rest]; ok {
+			return entry, true
+		}
+		parent = rest
+	}
+}
+
+// hostRecord returns the answer of a hosts override for queryType. A
+// name with a CNAME has nothing else, and a name without values of
+// queryType gets an empty answer.
+func hostRecord(entry models.HostEntry, queryType dns.Type) *models.Record {
+	switch {
+	case entry.CNAME != "":
+		return &models.Record{CNAME: entry.CNAME}
+	case queryType == dns.TypeA:
+		return &models.Record{A: entry.A}
+	case queryType == dns.TypeAAAA:
+		return &models.Record{AAAA: entry.AAAA}
+	default:
+		return &models.Record{}
+	}
+}
+
+// ValidateHost checks the values of a hosts override
+func ValidateHost(entry models.HostEntry) error {
+	return errors.Join(hostErrors(entry)...)
+}
+
+// hostErrors returns every problem with the values of a hosts override
+func hostErrors(entry models.HostEntry) []error {
+	var errs []error
+	if entry.CNAME != "" {
+		if !isDomainName(entry.CNAME) {
+			errs = append(errs, fmt.Errorf("invalid cname %q", entry.CNAME))
+		}
+		if len(entry.IPs()) > 0 {
+			errs = append(errs, errors.New("a cname can't be combined with ips"))
+		}
+	} else if len(entry.IPs()) == 0 {
+		errs = append(errs, errors.New("needs an ip or a cname"))
+	}
+
+	for _, ip := range entry.A {
+		if addr, err := netip.ParseAddr(ip); err != nil || !addr.Unmap().Is4() {
+			errs = append(errs, fmt.Errorf("invalid ip %q", ip))
+		}
+	}
+	for _, ip := range entry.AAAA {
+		if addr, err := netip.ParseAddr(ip); err != nil || !addr.Is6() || addr.Is4In6() {
+			errs = append(errs, fmt.Errorf("invalid IPv6 address %q", ip))
+		}
+	}
+
+	return errs
+}
+
+// isHostName is isDomainName that also allows a leading wildcard label,
+// e.g: "*.dev.lan"
+func isHostName(domain string) bool {
+	rest, _ := strings.CutPrefix(domain, "*.")
+
+	return !strings.Contains(rest, "*") && isDomainName(rest)
+}
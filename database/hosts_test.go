@@ -0,0 +1,134 @@
+package database
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getRecordHosts(t *testing.T) {
+	config := &models.Config{
+		WhitelistDomains: map[string]interface{}{},
+		BlockedDomains:   map[string]interface{}{},
+		Hosts: map[string]models.HostEntry{
+			"nas.lan":       models.HostIPs("192.168.0.10", "192.168.0.11", "fd00::10"),
+			"printer.lan":   models.HostIPs("192.168.0.20"),
+			"*.dev.lan":     models.HostIPs("192.168.0.30"),
+			"*.api.dev.lan": models.HostIPs("fd00::40"),
+			"files.lan":     {CNAME: "nas.lan"},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		address         string
+		queryType       dns.Type
+		expectedRecord  *models.Record
+		expectedOutcome models.Outcome
+	}{
+		{
+			name:            "several A values",
+			address:         "nas.lan",
+			queryType:       dns.TypeA,
+			expectedRecord:  &models.Record{A: []string{"192.168.0.10", "192.168.0.11"}},
+			expectedOutcome: models.OutcomeHosts,
+		},
+		{
+			name:            "AAAA",
+			address:         "nas.lan",
+			queryType:       dns.TypeAAAA,
+			expectedRecord:  &models.Record{AAAA: []string{"fd00::10"}},
+			expectedOutcome: models.OutcomeHosts,
+		},
+		{
+			name:            "AAAA without IPv6 values is empty",
+			address:         "printer.lan",
+			queryType:       dns.TypeAAAA,
+			expectedRecord:  &models.Record{},
+			expectedOutcome: models.OutcomeHosts,
+		},
+		{
+			name:            "other types are empty",
+			address:         "printer.lan",
+			queryType:       dns.TypeMX,
+			expectedRecord:  &models.Record{},
+			expectedOutcome: models.OutcomeHosts,
+		},
+		{
+			name:            "wildcard",
+			address:         "web.dev.lan",
+			queryType:       dns.TypeA,
+			expectedRecord:  &models.Record{A: []string{"192.168.0.30"}},
+			expectedOutcome: models.OutcomeHosts,
+		},
+		{
+			name:            "closest wildcard",
+			address:         "v1.api.dev.lan",
+			queryType:       dns.TypeAAAA,
+			expectedRecord:  &models.Record{AAAA: []string{"fd00::40"}},
+			expectedOutcome: models.OutcomeHosts,
+		},
+		{
+			name:      "wildcard doesn't match its own name",
+			address:   "dev.lan",
+			queryType: dns.TypeA,
+		},
+		{
+			name:            "CNAME for every type",
+			address:         "files.lan",
+			queryType:       dns.TypeAAAA,
+			expectedRecord:  &models.Record{CNAME: "nas.lan"},
+			expectedOutcome: models.OutcomeHosts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := Start(0)
+			db.Config = config
+
+			record, outcome, _ := db.GetRecord(netip.MustParseAddr("127.0.0.1"), tt.address, tt.queryType)
+			assert.Equal(t, tt.expectedRecord, record)
+			assert.Equal(t, tt.expectedOutcome, outcome)
+		})
+	}
+}
+
+func Test_hostsFileForms(t *testing.T) {
+	data := `{
+  "version": 1,
+  "hostsFile": {
+    "archive.is": "23.137.248.133",
+    "nas.lan": ["192.168.0.10", "fd00::10"],
+    "files.lan": {"cname": "nas.lan"},
+    "router.lan": {"a": ["192.168.0.1"], "aaaa": ["fd00::1"]}
+  }
+}`
+	expected := map[string]models.HostEntry{
+		"archive.is": {A: []string{"23.137.248.133"}},
+		"nas.lan":    {A: []string{"192.168.0.10"}, AAAA: []string{"fd00::10"}},
+		"files.lan":  {CNAME: "nas.lan"},
+		"router.lan": {A: []string{"192.168.0.1"}, AAAA: []string{"fd00::1"}},
+	}
+
+	path := filepath.Join(t.TempDir(), "dumbdns.json")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	config, _, err := readConfigFromDisk(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, config.Hosts)
+
+	// entries are written back in their shortest form
+	require.NoError(t, writeConfigToDisk(path, config))
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(written), `"archive.is": "23.137.248.133"`)
+	assert.Contains(t, string(written), `"nas.lan": [`)
+	assert.Contains(t, string(written), `"cname": "nas.lan"`)
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"dumbdns/models"
+
+	"github.com/miekg/dns"
+)
+
+// readHostsFile adds the entries of the /etc/hosts style file at path
+// to hosts, and the reverse name of every address to ptr
+func readHostsFile(path string, hosts map[string]models.HostEntry, ptr map[string][]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening hosts file: %w", err)
+	}
+	defer f.Close()
+
+	return parseHostsFile(path, f, hosts, ptr)
+}
+
+// parseHostsFile reads lines of an address followed by its names, e.g:
+// "192.168.0.10 nas.lan nas". The first name is the one given for
+// reverse lookups, like the system resolver does.
+func parseHostsFile(file string, r io.Reader, hosts map[string]models.HostEntry, ptr map[string][]string) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 1 {
+			return fmt.Errorf("%s:%d: %q has no names", file, line, fields[0])
+		}
+
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid ip %q", file, line, fields[0])
+		}
+		addr = addr.Unmap().WithZone("")
+		ip := addr.String()
+
+		for _, name := range fields[1:] {
+			name = CleanDomain(name)
+			if !isDomainName(name) {
+				return fmt.Errorf("%s:%d: invalid domain name %q", file, line, name)
+			}
+			entry := hosts[name]
+			switch {
+			case addr.Is4() && !slices.Contains(entry.A, ip):
+				entry.A = append(entry.A, ip)
+			case addr.Is6() && !slices.Contains(entry.AAAA, ip):
+				entry.AAAA = append(entry.AAAA, ip)
+			}
+			hosts[name] = entry
+		}
+
+		reverse, err := dns.ReverseAddr(ip)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		reverse = CleanDomain(reverse)
+		if canonical := CleanDomain(fields[1]); !slices.Contains(ptr[reverse], canonical) {
+			ptr[reverse] = append(ptr[reverse], canonical)
+		}
+	}
+
+	return scanner.Err()
+}
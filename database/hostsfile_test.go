@@ -0,0 +1,111 @@
+package database
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseHostsFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		expectedHost map[string]models.HostEntry
+		expectedPTR  map[string][]string
+		expectedErr  string
+	}{
+		{
+			name: "addresses, aliases and comments",
+			data: `# lab machines
+192.168.0.10  nas.lab nas   # storage
+fd00::10      nas.lab
+192.168.0.11  nas.lab
+192.168.0.20  Printer.Lab
+`,
+			expectedHost: map[string]models.HostEntry{
+				"nas.lab":     {A: []string{"192.168.0.10", "192.168.0.11"}, AAAA: []string{"fd00::10"}},
+				"nas":         {A: []string{"192.168.0.10"}},
+				"printer.lab": {A: []string{"192.168.0.20"}},
+			},
+			expectedPTR: map[string][]string{
+				"10.0.168.192.in-addr.arpa": {"nas.lab"},
+				"0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa": {"nas.lab"},
+				"11.0.168.192.in-addr.arpa": {"nas.lab"},
+				"20.0.168.192.in-addr.arpa": {"printer.lab"},
+			},
+		},
+		{
+			name:        "invalid ip",
+			data:        "192.168.0.10 nas.lab\n192.168.0.300 printer.lab\n",
+			expectedErr: `hosts:2: invalid ip "192.168.0.300"`,
+		},
+		{
+			name:        "missing names",
+			data:        "192.168.0.10\n",
+			expectedErr: `hosts:1: "192.168.0.10" has no names`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, ptr := map[string]models.HostEntry{}, map[string][]string{}
+			err := parseHostsFile("hosts", strings.NewReader(tt.data), hosts, ptr)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedHost, hosts)
+			assert.Equal(t, tt.expectedPTR, ptr)
+		})
+	}
+}
+
+func Test_hostsFilesReload(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "dumbdns.json")
+	hostsPath := filepath.Join(dir, "lab.hosts")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{
+  "version": 1,
+  "hostsFile": {"nas.lab": "10.0.0.10"},
+  "hostsFiles": ["lab.hosts"]
+}`), 0o644))
+	require.NoError(t, os.WriteFile(hostsPath, []byte("192.168.0.10 nas.lab\n192.168.0.20 printer.lab\n"), 0o644))
+
+	db := Start(0)
+	require.NoError(t, db.LoadConfig(configPath))
+	client := netip.MustParseAddr("127.0.0.1")
+
+	// the inline hostsFile wins over hostsFiles
+	record, outcome, err := db.GetRecord(client, "nas.lab", dns.TypeA)
+	require.NoError(t, err)
+	assert.Equal(t, models.OutcomeHosts, outcome)
+	assert.Equal(t, []string{"10.0.0.10"}, record.A)
+
+	record, _, err = db.GetRecord(client, "20.0.168.192.in-addr.arpa", dns.TypePTR)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"printer.lab"}, record.PTR)
+
+	// a change to the hosts file is a change to the config
+	before := db.configModTime
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(hostsPath, []byte("192.168.0.21 printer.lab\n"), 0o644))
+	require.NoError(t, os.Chtimes(hostsPath, later, later))
+	assert.True(t, configModTime(configPath, db.GetConfig()).After(before))
+
+	require.NoError(t, db.ReloadConfig())
+	record, _, err = db.GetRecord(client, "printer.lab", dns.TypeA)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"192.168.0.21"}, record.A)
+	_, _, err = db.GetRecord(client, "20.0.168.192.in-addr.arpa", dns.TypePTR)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,291 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"dumbdns/logging"
+	"dumbdns/models"
+
+	"github.com/miekg/dns"
+)
+
+// hostLabel is a valid host name label, DHCP clients send anything
+var hostLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// leases are the DHCP clients read from the lease files
+type leases struct {
+	hosts map[string]models.HostEntry
+	ptr   map[string][]string
+	// names are the published names of client addresses
+	names map[netip.Addr]string
+	// expires is when the first lease ends, the files are read again
+	// then. It is zero when no lease ends.
+	expires time.Time
+}
+
+// lease is a single address given to a DHCP client
+type lease struct {
+	addr    netip.Addr
+	name    string
+	expires time.Time
+}
+
+// WatchLeases reads the DHCP lease files whenever one of them or the
+// config changes, or a lease ends, until ctx is done
+func (db *Database) WatchLeases(ctx context.Context, interval time.Duration) {
+	var config *models.Config
+	var lastModTime time.Time
+	for {
+		db.configMux.RLock()
+		current, dir := db.Config, filepath.Dir(db.configPath)
+		db.configMux.RUnlock()
+
+		newest := time.Time{}
+		for _, file := range current.DHCP.LeaseFiles {
+			if t := modTime(localPath(dir, file)); t.After(newest) {
+				newest = t
+			}
+		}
+		expires := db.leases.Load().expires
+		if current != config || !newest.Equal(lastModTime) || (!expires.IsZero() && db.now().After(expires)) {
+			db.leases.Store(readLeases(dir, current.DHCP, db.now()))
+			config, lastModTime = current, newest
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ClientName returns the name of a client from its DHCP lease or the
+// hosts files, or an empty string when it has none
+func (db *Database) ClientName(addr netip.Addr) string {
+	if name, ok := db.leases.Load().names[addr]; ok {
+		return name
+	}
+	reverse, err := dns.ReverseAddr(addr.String())
+	if err != nil {
+		return ""
+	}
+	if names := db.local().ptr[CleanDomain(reverse)]; len(names) > 0 {
+		return names[0]
+	}
+
+	return ""
+}
+
+// readLeases reads the lease files of config, publishing the clients
+// that are still bound at now under config.Domain. Files that can't be
+// read are logged and skipped, as the DHCP server may not have written
+// them yet.
+func readLeases(dir string, config models.DHCPConfig, now time.Time) *leases {
+	l := &leases{
+		hosts: map[string]models.HostEntry{},
+		ptr:   map[string][]string{},
+		names: map[netip.Addr]string{},
+	}
+	domain := CleanDomain(config.Domain)
+
+	for _, file := range config.LeaseFiles {
+		path := localPath(dir, file)
+		found, err := readLeaseFile(path)
+		if err != nil {
+			logging.Warnf("Skipping DHCP lease file %s: %v", path, err)
+			continue
+		}
+
+		for _, lease := range found {
+			if !lease.expires.IsZero() && !lease.expires.After(now) {
+				continue
+			}
+			if !lease.expires.IsZero() && (l.expires.IsZero() || lease.expires.Before(l.expires)) {
+				l.expires = lease.expires
+			}
+
+			name := lease.name + "." + domain
+			ip := lease.addr.String()
+			entry := l.hosts[name]
+			if lease.addr.Is4() {
+				entry.A = append(entry.A, ip)
+			} else {
+				entry.AAAA = append(entry.AAAA, ip)
+			}
+			l.hosts[name] = entry
+			l.names[lease.addr] = name
+			if reverse, err := dns.ReverseAddr(ip); err == nil {
+				l.ptr[CleanDomain(reverse)] = []string{name}
+			}
+		}
+	}
+
+	return l
+}
+
+// readLeaseFile reads a dnsmasq or ISC dhcpd lease file, telling them
+// apart by the "lease" blocks of the ISC format
+func readLeaseFile(path string) ([]lease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	text := string(data)
+	if strings.HasPrefix(text, "lease ") || strings.Contains(text, "\nlease ") {
+		return parseISCLeases(strings.NewReader(text))
+	}
+
+	return parseDnsmasqLeases(strings.NewReader(text))
+}
+
+// parseDnsmasqLeases reads lines of "expiry mac/iaid ip name client-id",
+// e.g: "1760875200 aa:bb:cc:dd:ee:ff 192.168.0.50 laptop *". An expiry of
+// 0 never ends, and a name of "*" is a client without one.
+func parseDnsmasqLeases(r io.Reader) ([]lease, error) {
+	var found []lease
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		// the duid line starts the DHCPv6 leases
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d: expected expiry, mac, ip and name", line)
+		}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", line, fields[0])
+		}
+		addr, err := netip.ParseAddr(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid ip %q", line, fields[2])
+		}
+
+		l := lease{addr: addr.Unmap(), name: leaseName(fields[3])}
+		if expiry != 0 {
+			l.expires = time.Unix(expiry, 0)
+		}
+		if l.name != "" {
+			found = append(found, l)
+		}
+	}
+
+	return found, scanner.Err()
+}
+
+// parseISCLeases reads the "lease ip { ... }" blocks of an ISC dhcpd
+// lease file. The file is appended to, so the last block of an address
+// is the current one.
+func parseISCLeases(r io.Reader) ([]lease, error) {
+	current := map[netip.Addr]int{}
+	var found []lease
+	var l *lease
+	active := false
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if l == nil {
+			ip, ok := strings.CutPrefix(text, "lease ")
+			if !ok {
+				continue
+			}
+			addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimSpace(ip), " {"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid lease %q", line, text)
+			}
+			l, active = &lease{addr: addr.Unmap()}, false
+			continue
+		}
+
+		if text == "}" {
+			if i, ok := current[l.addr]; ok {
+				found[i] = lease{}
+			}
+			if active && l.name != "" {
+				current[l.addr] = len(found)
+				found = append(found, *l)
+			} else {
+				delete(current, l.addr)
+			}
+			l = nil
+			continue
+		}
+
+		statement := strings.TrimSuffix(text, ";")
+		switch {
+		case statement == "binding state active":
+			active = true
+		case strings.HasPrefix(statement, "client-hostname "):
+			l.name = leaseName(strings.Trim(strings.TrimPrefix(statement, "client-hostname "), `"`))
+		case strings.HasPrefix(statement, "ends "):
+			expires, err := iscTime(strings.TrimPrefix(statement, "ends "))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			l.expires = expires
+		}
+	}
+
+	// leases replaced by a later block are left empty
+	valid := found[:0]
+	for _, l := range found {
+		if l.addr.IsValid() {
+			valid = append(valid, l)
+		}
+	}
+
+	return valid, scanner.Err()
+}
+
+// iscTime parses the time of an ISC lease, e.g: "4 2026/10/19 12:00:00"
+// in UTC, "epoch 1760875200; # Sun Oct 19 12:00:00 2026" or "never"
+func iscTime(value string) (time.Time, error) {
+	fields := strings.Fields(value)
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, nil
+	case len(fields) >= 2 && fields[0] == "epoch":
+		epoch, err := strconv.ParseInt(strings.TrimSuffix(fields[1], ";"), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid lease time %q", value)
+		}
+		return time.Unix(epoch, 0), nil
+	case len(fields) == 3:
+		t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid lease time %q", value)
+		}
+		return t, nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid lease time %q", value)
+	}
+}
+
+// leaseName returns the host name label a client asked for, or an empty
+// string when it isn't usable in a domain name
+func leaseName(name string) string {
+	name, _, _ = strings.Cut(strings.ToLower(name), ".")
+	if !hostLabel.MatchString(name) {
+		return ""
+	}
+
+	return name
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseDnsmasqLeases(t *testing.T) {
+	data := `1760875200 aa:bb:cc:dd:ee:ff 192.168.0.50 Laptop 01:aa:bb:cc:dd:ee:ff
+0 11:22:33:44:55:66 192.168.0.51 printer *
+1760875200 66:55:44:33:22:11 192.168.0.52 * *
+1760875200 77:55:44:33:22:11 192.168.0.53 bad_name *
+duid 00:01:00:01:2c:4f:1a:2b:aa:bb:cc:dd:ee:ff
+1760875200 1234567 fd00::50 laptop 00:01:00:01:2c:4f:1a:2b:aa:bb:cc:dd:ee:ff
+`
+	expected := []lease{
+		{addr: netip.MustParseAddr("192.168.0.50"), name: "laptop", expires: time.Unix(1760875200, 0)},
+		{addr: netip.MustParseAddr("192.168.0.51"), name: "printer"},
+		{addr: netip.MustParseAddr("fd00::50"), name: "laptop", expires: time.Unix(1760875200, 0)},
+	}
+
+	actual, err := parseDnsmasqLeases(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func Test_parseISCLeases(t *testing.T) {
+	data := `# The format of this file is documented in the dhcpd.leases(5) manual page.
+lease 192.168.0.50 {
+  starts 0 2025/10/19 10:00:00;
+  ends 0 2025/10/19 22:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:dd:ee:ff;
+  client-hostname "laptop";
+}
+lease 192.168.0.51 {
+  starts 0 2025/10/19 10:00:00;
+  ends never;
+  binding state active;
+  client-hostname "printer.example.com";
+}
+lease 192.168.0.50 {
+  starts 0 2025/10/19 11:00:00;
+  ends epoch 1760918400; # Mon Oct 20 00:00:00 2025
+  binding state active;
+  client-hostname "laptop";
+}
+lease 192.168.0.52 {
+  ends 0 2025/10/19 22:00:00;
+  binding state free;
+  client-hostname "phone";
+}
+`
+	expected := []lease{
+		{addr: netip.MustParseAddr("192.168.0.51"), name: "printer"},
+		{addr: netip.MustParseAddr("192.168.0.50"), name: "laptop", expires: time.Unix(1760918400, 0)},
+	}
+
+	actual, err := parseISCLeases(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func Test_readLeases(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dnsmasq.leases"), []byte(
+		"1760875200 aa:bb:cc:dd:ee:ff 192.168.0.50 laptop *\n"+
+			"1760871600 11:22:33:44:55:66 192.168.0.51 phone *\n"+
+			"1760875200 1234567 fd00::50 laptop *\n"), 0o644))
+
+	now := time.Unix(1760872000, 0)
+	config := models.DHCPConfig{LeaseFiles: []string{"dnsmasq.leases", "missing.leases"}, Domain: "home.lan."}
+	l := readLeases(dir, config, now)
+
+	// the phone's lease has ended
+	assert.Equal(t, map[string]models.HostEntry{
+		"laptop.home.lan": {A: []string{"192.168.0.50"}, AAAA: []string{"fd00::50"}},
+	}, l.hosts)
+	assert.Equal(t, []string{"laptop.home.lan"}, l.ptr["50.0.168.192.in-addr.arpa"])
+	assert.Equal(t, time.Unix(1760875200, 0), l.expires)
+
+	db := Start(0)
+	db.Config = &models.Config{}
+	db.leases.Store(l)
+	record, outcome, err := db.GetRecord(netip.MustParseAddr("127.0.0.1"), "laptop.home.lan", dns.TypeAAAA)
+	require.NoError(t, err)
+	assert.Equal(t, models.OutcomeHosts, outcome)
+	assert.Equal(t, []string{"fd00::50"}, record.AAAA)
+	assert.Equal(t, "laptop.home.lan", db.ClientName(netip.MustParseAddr("192.168.0.50")))
+	assert.Equal(t, "", db.ClientName(netip.MustParseAddr("192.168.0.51")))
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"dumbdns/models"
+	"dumbdns/zone"
+)
+
+// localData is read from the files the config refers to, and swapped
+// in with the config
+type localData struct {
+	// zones are keyed by origin, e.g: "home.lan."
+	zones map[string]*zone.Zone
+	// hosts are read from hostsFiles
+	hosts map[string]models.HostEntry
+	// ptr maps reverse names to the names of their address in the
+	// hosts files and zones, e.g: "10.0.168.192.in-addr.arpa": ["nas.lan"]
+	ptr map[string][]string
+	// hostsPTR is the part of ptr read from the hosts files, the rest
+	// is added again when a zone changes
+	hostsPTR map[string][]string
+}
+
+func (db *Database) local() *localData {
+	return db.localData.Load()
+}
+
+// loadLocal reads the zone and hosts files of config. Relative paths are
+// relative to dir, the directory of the config file.
+func loadLocal(dir string, config *models.Config) (*localData, []fieldError) {
+	local := &localData{hosts: map[string]models.HostEntry{}, ptr: map[string][]string{}}
+
+	var errs []fieldError
+	local.zones, errs = loadZones(dir, config.Zones)
+	for i, file := range config.HostsFiles {
+		err := readHostsFile(localPath(dir, file), local.hosts, local.ptr)
+		if err != nil {
+			errs = append(errs, fieldError{field: fmt.Sprintf("hostsFiles[%d]", i), err: err})
+		}
+	}
+	local.hostsPTR = local.ptr
+	local.ptr = local.zonePTR()
+
+	return local, errs
+}
+
+// zonePTR returns the PTR names of the hosts files together with the
+// ones of the zone records, so zones without a reverse zone of their
+// own still get PTR answers
+func (local *localData) zonePTR() map[string][]string {
+	ptr := make(map[string][]string, len(local.hostsPTR))
+	for reverse, names := range local.hostsPTR {
+		ptr[reverse] = slices.Clone(names)
+	}
+	for _, origin := range sortedKeys(local.zones) {
+		addZonePTR(local.zones[origin], ptr)
+	}
+
+	return ptr
+}
+
+// localFiles returns the paths of the zone and hosts files of config
+func localFiles(dir string, config *models.Config) []string {
+	files := make([]string, 0, len(config.Zones)+len(config.HostsFiles))
+	for _, file := range config.Zones {
+		files = append(files, localPath(dir, file))
+	}
+	for _, file := range config.HostsFiles {
+		files = append(files, localPath(dir, file))
+	}
+
+	return files
+}
+
+// localPath returns the path of a file the config refers to, relative
+// paths are relative to dir
+func localPath(dir string, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(dir, file)
+}
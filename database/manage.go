@@ -0,0 +1,169 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"dumbdns/models"
+)
+
+// ErrTOMLConfig is returned for changes to a TOML config file, which
+// can't be rewritten without dropping the comments in it
+var ErrTOMLConfig = errors.New("TOML config files can't be changed without losing their comments, use JSON or YAML to make changes at runtime")
+
+// updateConfig applies change to a copy of the current config, persists
+// it to disk and then swaps it in. Readers holding the previous config
+// are never affected by the change. A change that makes the config
+// invalid is returned as *ConfigError and never written, as the server
+// wouldn't start with it.
+func (db *Database) updateConfig(change func(config *models.Config)) error {
+	db.configMux.Lock()
+	defer db.configMux.Unlock()
+
+	if configFormat(db.configPath) == "toml" {
+		return ErrTOMLConfig
+	}
+
+	config := db.Config.Clone()
+	change(config)
+
+	if fieldErrs := validateConfig(config); len(fieldErrs) > 0 {
+		errs := make([]error, 0, len(fieldErrs))
+		for _, fe := range fieldErrs {
+			errs = append(errs, &ConfigError{Path: db.configPath, Field: fe.field, Err: fe.err})
+		}
+		return errors.Join(errs...)
+	}
+
+	err := writeConfigToDisk(db.configPath, config)
+	if err != nil {
+		return fmt.Errorf("error saving config: %w", err)
+	}
+	db.Config = config
+	// our own write shouldn't trigger a reload
+	db.configModTime = configModTime(db.configPath, config)
+
+	return nil
+}
+
+// AddWhitelist allows domain to bypass the block list
+func (db *Database) AddWhitelist(domain string) error {
+	domain = CleanDomain(domain)
+	err := db.updateConfig(func(config *models.Config) {
+		config.WhitelistDomains[domain] = struct{}{}
+	})
+	if err != nil {
+		return err
+	}
+	db.applyBlockList()
+
+	return nil
+}
+
+// RemoveWhitelist removes domain from the whitelist, blocking it again
+// if it appears in a source or the custom block entries
+func (db *Database) RemoveWhitelist(domain string) error {
+	domain = CleanDomain(domain)
+	err := db.updateConfig(func(config *models.Config) {
+		delete(config.WhitelistDomains, domain)
+	})
+	if err != nil {
+		return err
+	}
+	db.applyBlockList()
+
+	return nil
+}
+
+// AddBlock adds a custom block entry for domain
+func (db *Database) AddBlock(domain string) error {
+	domain = CleanDomain(domain)
+	err := db.updateConfig(func(config *models.Config) {
+		config.BlockedDomains[domain] = struct{}{}
+	})
+	if err != nil {
+		return err
+	}
+	db.applyBlockList()
+
+	return nil
+}
+
+// RemoveBlock removes a custom block entry, the domain stays blocked if
+// it also appears in one of the sources
+func (db *Database) RemoveBlock(domain string) error {
+	domain = CleanDomain(domain)
+	err := db.updateConfig(func(config *models.Config) {
+		delete(config.BlockedDomains, domain)
+	})
+	if err != nil {
+		return err
+	}
+	db.applyBlockList()
+
+	return nil
+}
+
+// SetHost adds or replaces the hosts override of domain
+func (db *Database) SetHost(domain string, entry models.HostEntry) error {
+	domain = CleanDomain(domain)
+	return db.updateConfig(func(config *models.Config) {
+		config.Hosts[domain] = entry
+	})
+}
+
+// RemoveHost removes the hosts override for domain
+func (db *Database) RemoveHost(domain string) error {
+	domain = CleanDomain(domain)
+	return db.updateConfig(func(config *models.Config) {
+		delete(config.Hosts, domain)
+	})
+}
+
+// FlushCache removes every cached record
+func (db *Database) FlushCache() {
+	db.dbMux.Lock()
+	db.database = map[string]*models.Record{}
+	db.dbMux.Unlock()
+}
+
+// FlushRecord removes the cached records of a single domain
+func (db *Database) FlushRecord(domain string) {
+	domain = CleanDomain(domain)
+	db.dbMux.Lock()
+	for key := range db.database {
+		if key == domain || isSubnetKey(key, domain) {
+			delete(db.database, key)
+		}
+	}
+	db.dbMux.Unlock()
+}
+
+func (db *Database) Stats() models.Stats {
+	config := db.GetConfig()
+
+	db.dbMux.RLock()
+	cacheSize := len(db.database)
+	db.dbMux.RUnlock()
+
+	db.blockMux.RLock()
+	blockListSize := len(db.blockListDatabase)
+	db.blockMux.RUnlock()
+
+	return models.Stats{
+		CacheSize:       cacheSize,
+		BlockListSize:   blockListSize,
+		WhitelistSize:   len(config.WhitelistDomains),
+		BlockedDomains:  len(config.BlockedDomains),
+		HostsSize:       len(config.Hosts),
+		ActiveSchedules: db.ActiveSchedules(),
+		Pauses:          db.Pauses(),
+	}
+}
+
+// CleanDomain lower cases domain and removes the trailing "." of a
+// fully qualified name so it matches the keys used by the database
+func CleanDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_updateConfigRoundTrip(t *testing.T) {
+	config := &models.Config{
+		WhitelistDomains: map[string]interface{}{"example.com": struct{}{}},
+		BlockedDomains:   map[string]interface{}{},
+		Hosts:            map[string]models.HostEntry{"nas.lan": {A: []string{"192.168.0.10"}}},
+	}
+
+	for _, name := range []string{"dumbdns.json", "dumbdns.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, writeConfigToDisk(path, config))
+			db := Start(0)
+			require.NoError(t, db.LoadConfig(path))
+
+			require.NoError(t, db.AddWhitelist("Other.com."))
+			require.NoError(t, db.RemoveWhitelist("example.com"))
+			require.NoError(t, db.AddBlock("ads.example.com"))
+			require.NoError(t, db.SetHost("printer.lan", models.HostEntry{A: []string{"192.168.0.20"}, AAAA: []string{"fd00::20"}}))
+			require.NoError(t, db.SetHost("files.lan", models.HostEntry{CNAME: "nas.lan"}))
+			require.NoError(t, db.RemoveHost("nas.lan"))
+
+			actual, _, err := readConfigFromDisk(path)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"other.com": struct{}{}}, actual.WhitelistDomains)
+			assert.Equal(t, map[string]interface{}{"ads.example.com": struct{}{}}, actual.BlockedDomains)
+			assert.Equal(t, map[string]models.HostEntry{
+				"printer.lan": {A: []string{"192.168.0.20"}, AAAA: []string{"fd00::20"}},
+				"files.lan":   {CNAME: "nas.lan"},
+			}, actual.Hosts)
+			assert.Equal(t, actual.WhitelistDomains, db.GetConfig().WhitelistDomains)
+
+			// an invalid change is neither saved nor applied
+			before, err := os.ReadFile(path)
+			require.NoError(t, err)
+			err = db.AddWhitelist("not a domain")
+			var configErr *ConfigError
+			assert.True(t, errors.As(err, &configErr))
+			after, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, string(before), string(after))
+			assert.NotContains(t, db.GetConfig().WhitelistDomains, "not a domain")
+		})
+	}
+}
+
+func Test_updateConfigComments(t *testing.T) {
+	data := `# home resolver
+version: 1
+whiteList:
+  - spclient.wg.spotify.com # spotify stops playing without it
+  - cdn.jsdelivr.net        # used by half the web for scripts
+hostsFile:
+  # reached over the VPN
+  archive.is: 23.137.248.133 # archive.is blocks CloudFlare DNS
+  nas.lan: [192.168.0.10, "fd00::10"]
+`
+	expected := `# home resolver
+version: 1
+whitelist:
+  - a.example.com
+  - spclient.wg.spotify.com # spotify stops playing without it
+hostsFile:
+  # reached over the VPN
+  archive.is: 23.137.248.133 # archive.is blocks CloudFlare DNS
+  nas.lan: [192.168.0.10, "fd00::10"]
+  printer.lan: 192.168.0.20
+`
+
+	path := filepath.Join(t.TempDir(), "dumbdns.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	db := Start(0)
+	require.NoError(t, db.LoadConfig(path))
+
+	require.NoError(t, db.AddWhitelist("a.example.com"))
+	require.NoError(t, db.RemoveWhitelist("cdn.jsdelivr.net"))
+	require.NoError(t, db.SetHost("printer.lan", models.HostIPs("192.168.0.20")))
+
+	actual, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
+
+func Test_updateConfigTOML(t *testing.T) {
+	data := `version = 1
+whitelist = [
+  "i.scdn.co", # spotify album art
+]
+`
+	path := filepath.Join(t.TempDir(), "dumbdns.toml")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	db := Start(0)
+	require.NoError(t, db.LoadConfig(path))
+
+	// the file would lose its comments, so it isn't changed
+	assert.ErrorIs(t, db.AddWhitelist("example.com"), ErrTOMLConfig)
+	actual, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(actual))
+	assert.NotContains(t, db.GetConfig().WhitelistDomains, "example.com")
+}
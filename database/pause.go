@@ -0,0 +1,101 @@
+package database
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"dumbdns/logging"
+	"dumbdns/models"
+)
+
+// PauseBlocking turns blocking off until d has passed, for every client
+// when client is the zero Addr or for that client only
+func (db *Database) PauseBlocking(client netip.Addr, d time.Duration) models.Pause {
+	key := pauseKey(client)
+	until := db.now().Add(d)
+
+	db.pauseMux.Lock()
+	db.pauses[key] = until
+	db.pauseMux.Unlock()
+
+	if key == "" {
+		logging.Infof("Blocking paused for %s\n", d)
+	} else {
+		logging.Infof("Blocking paused for %s for %s\n", key, d)
+	}
+
+	return models.Pause{Client: key, Until: until, Remaining: models.Duration{Duration: d}}
+}
+
+// ResumeBlocking ends a pause started by PauseBlocking before it runs out
+func (db *Database) ResumeBlocking(client netip.Addr) {
+	key := pauseKey(client)
+
+	db.pauseMux.Lock()
+	delete(db.pauses, key)
+	db.pauseMux.Unlock()
+
+	if key == "" {
+		logging.Infof("Blocking resumed\n")
+	} else {
+		logging.Infof("Blocking resumed for %s\n", key)
+	}
+}
+
+// Pauses returns the pauses that haven't run out yet, the global pause
+// first
+func (db *Database) Pauses() []models.Pause {
+	now := db.now()
+
+	db.pauseMux.Lock()
+	defer db.pauseMux.Unlock()
+
+	pauses := []models.Pause{}
+	for key, until := range db.pauses {
+		if !now.Before(until) {
+			// blocking turns back on by itself, this only tidies up
+			delete(db.pauses, key)
+			continue
+		}
+		pauses = append(pauses, models.Pause{
+			Client:    key,
+			Until:     until,
+			Remaining: models.Duration{Duration: until.Sub(now).Round(time.Second)},
+		})
+	}
+	slices.SortFunc(pauses, func(a, b models.Pause) int {
+		return strings.Compare(a.Client, b.Client)
+	})
+
+	return pauses
+}
+
+// blockingPaused reports whether blocking is paused for client
+func (db *Database) blockingPaused(client netip.Addr) bool {
+	now := db.now()
+
+	db.pauseMux.Lock()
+	defer db.pauseMux.Unlock()
+	if len(db.pauses) == 0 {
+		return false
+	}
+
+	for _, key := range []string{"", pauseKey(client)} {
+		if until, ok := db.pauses[key]; ok && now.Before(until) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pauseKey is the empty string for a global pause
+func pauseKey(client netip.Addr) string {
+	if !client.IsValid() {
+		return ""
+	}
+
+	return client.Unmap().WithZone("").String()
+}
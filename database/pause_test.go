@@ -0,0 +1,88 @@
+package database
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pauseBlocking(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	kid := netip.MustParseAddr("192.168.1.20")
+	other := netip.MustParseAddr("192.168.1.30")
+
+	tests := []struct {
+		name            string
+		pause           netip.Addr
+		elapsed         time.Duration
+		client          netip.Addr
+		expectedOutcome models.Outcome
+		expectedPauses  []models.Pause
+	}{
+		{
+			name:            "Paused for every client",
+			elapsed:         time.Minute,
+			client:          kid,
+			expectedOutcome: "",
+			expectedPauses:  []models.Pause{{Until: start.Add(5 * time.Minute), Remaining: models.Duration{Duration: 4 * time.Minute}}},
+		},
+		{
+			name:            "Paused for a single client",
+			pause:           kid,
+			elapsed:         time.Minute,
+			client:          kid,
+			expectedOutcome: "",
+			expectedPauses:  []models.Pause{{Client: "192.168.1.20", Until: start.Add(5 * time.Minute), Remaining: models.Duration{Duration: 4 * time.Minute}}},
+		},
+		{
+			name:            "Other clients are still blocked",
+			pause:           kid,
+			elapsed:         time.Minute,
+			client:          other,
+			expectedOutcome: models.OutcomeBlocked,
+			expectedPauses:  []models.Pause{{Client: "192.168.1.20", Until: start.Add(5 * time.Minute), Remaining: models.Duration{Duration: 4 * time.Minute}}},
+		},
+		{
+			name:            "Blocking resumes when the pause runs out",
+			elapsed:         5 * time.Minute,
+			client:          kid,
+			expectedOutcome: models.OutcomeBlocked,
+			expectedPauses:  []models.Pause{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			db := Start(0)
+			db.Config = &models.Config{BlockedDomains: map[string]interface{}{"ads.com": struct{}{}}}
+			db.now = func() time.Time { return now }
+			db.applyBlockList()
+
+			db.PauseBlocking(tt.pause, 5*time.Minute)
+			now = now.Add(tt.elapsed)
+
+			_, outcome, _ := db.GetRecord(tt.client, "ads.com", dns.TypeA)
+			assert.Equal(t, tt.expectedOutcome, outcome)
+			assert.Equal(t, tt.expectedPauses, db.Pauses())
+		})
+	}
+}
+
+func Test_resumeBlocking(t *testing.T) {
+	db := Start(0)
+	db.Config = &models.Config{BlockedDomains: map[string]interface{}{"ads.com": struct{}{}}}
+	db.applyBlockList()
+
+	db.PauseBlocking(netip.Addr{}, time.Hour)
+	db.ResumeBlocking(netip.Addr{})
+
+	_, outcome, _ := db.GetRecord(netip.MustParseAddr("192.168.1.20"), "ads.com", dns.TypeA)
+	assert.Equal(t, models.OutcomeBlocked, outcome)
+	assert.Empty(t, db.Pauses())
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"dumbdns/models"
+	"dumbdns/zone"
+
+	"github.com/miekg/dns"
+)
+
+// privateReverseZones are the reverse zones of private and special
+// ranges that are answered locally instead of leaking upstream, see RFC
+// 6303 and RFC 6761
+var privateReverseZones = privateZones()
+
+func privateZones() []string {
+	zones := []string{
+		"10.in-addr.arpa",              // 10.0.0.0/8
+		"168.192.in-addr.arpa",         // 192.168.0.0/16
+		"254.169.in-addr.arpa",         // 169.254.0.0/16
+		"127.in-addr.arpa",             // 127.0.0.0/8
+		"0.in-addr.arpa",               // 0.0.0.0/8
+		"2.0.192.in-addr.arpa",         // 192.0.2.0/24
+		"100.51.198.in-addr.arpa",      // 198.51.100.0/24
+		"113.0.203.in-addr.arpa",       // 203.0.113.0/24
+		"255.255.255.255.in-addr.arpa", // 255.255.255.255/32
+		"c.f.ip6.arpa",                 // fc00::/8
+		"d.f.ip6.arpa",                 // fd00::/8
+		"8.e.f.ip6.arpa",               // fe80::/10
+		"9.e.f.ip6.arpa",
+		"a.e.f.ip6.arpa",
+		"b.e.f.ip6.arpa",
+		"8.b.d.0.1.0.0.2.ip6.arpa",                   // 2001:db8::/32
+		strings.Repeat("0.", 32) + "ip6.arpa",        // ::
+		"1." + strings.Repeat("0.", 31) + "ip6.arpa", // ::1
+	}
+	// 172.16.0.0/12
+	for i := 16; i <= 31; i++ {
+		zones = append(zones, fmt.Sprintf("%d.172.in-addr.arpa", i))
+	}
+	// 100.64.0.0/10, RFC 7793
+	for i := 64; i <= 127; i++ {
+		zones = append(zones, fmt.Sprintf("%d.100.in-addr.arpa", i))
+	}
+
+	return zones
+}
+
+// PrivateReverse returns the reverse zone of a private or special range
+// that address is in, if any
+func PrivateReverse(address string) (string, bool) {
+	for _, zone := range privateReverseZones {
+		if address == zone || strings.HasSuffix(address, "."+zone) {
+			return zone, true
+		}
+	}
+
+	return "", false
+}
+
+// reverseHosts returns the names in hosts whose address has the reverse
+// name address, e.g: "10.0.168.192.in-addr.arpa". Wildcards are left out
+// as they have no single name to give.
+func reverseHosts(hosts map[string]models.HostEntry, address string) []string {
+	if !strings.HasSuffix(address, ".arpa") {
+		return nil
+	}
+
+	var names []string
+	for _, name := range sortedKeys(hosts) {
+		if strings.HasPrefix(name, "*.") {
+			continue
+		}
+		for _, ip := range hosts[name].IPs() {
+			if reverse, err := dns.ReverseAddr(ip); err == nil && CleanDomain(reverse) == address {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+
+	return names
+}
+
+// addZonePTR adds the reverse name of every address in z to ptr
+func addZonePTR(z *zone.Zone, ptr map[string][]string) {
+	for _, rr := range z.Records() {
+		var addr netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr, _ = netip.AddrFromSlice(rr.A.To4())
+		case *dns.AAAA:
+			addr, _ = netip.AddrFromSlice(rr.AAAA)
+		default:
+			continue
+		}
+		name := CleanDomain(rr.Header().Name)
+		if strings.HasPrefix(name, "*.") {
+			continue
+		}
+
+		reverse, err := dns.ReverseAddr(addr.String())
+		if err != nil {
+			continue
+		}
+		reverse = CleanDomain(reverse)
+		if !slices.Contains(ptr[reverse], name) {
+			ptr[reverse] = append(ptr[reverse], name)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PrivateReverse(t *testing.T) {
+	tests := []struct {
+		address      string
+		expected     bool
+		expectedZone string
+	}{
+		{address: "10.0.168.192.in-addr.arpa", expected: true, expectedZone: "168.192.in-addr.arpa"},
+		{address: "5.0.0.10.in-addr.arpa", expected: true, expectedZone: "10.in-addr.arpa"},
+		{address: "1.0.16.172.in-addr.arpa", expected: true, expectedZone: "16.172.in-addr.arpa"},
+		{address: "1.0.31.172.in-addr.arpa", expected: true, expectedZone: "31.172.in-addr.arpa"},
+		{address: "1.0.32.172.in-addr.arpa", expected: false},
+		{address: "1.0.64.100.in-addr.arpa", expected: true, expectedZone: "64.100.in-addr.arpa"},
+		{address: "1.0.0.127.in-addr.arpa", expected: true, expectedZone: "127.in-addr.arpa"},
+		{address: "0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa", expected: true, expectedZone: "d.f.ip6.arpa"},
+		{address: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa", expected: true, expectedZone: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa"},
+		{address: "8.8.8.8.in-addr.arpa", expected: false},
+		{address: "110.168.192.in-addr.arpa.example.com", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			zone, ok := PrivateReverse(tt.address)
+			assert.Equal(t, tt.expected, ok)
+			assert.Equal(t, tt.expectedZone, zone)
+		})
+	}
+}
+
+func Test_getRecordReverse(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "dumbdns.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{
+  "version": 1,
+  "hostsFile": {
+    "nas.lan": ["192.168.0.10", "fd00::10"],
+    "files.lan": "192.168.0.10",
+    "*.dev.lan": "192.168.0.30"
+  },
+  "groups": {
+    "kids": {"clients": ["192.168.1.0/24"], "hostsFile": {"games.lan": "192.168.0.10"}}
+  },
+  "zones": {"home.lan": "home.lan.zone"}
+}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "home.lan.zone"), []byte(`$ORIGIN home.lan.
+$TTL 3600
+@   IN SOA ns admin 1 7200 900 1209600 300
+tv  IN A 192.168.0.40
+*.x IN A 192.168.0.41
+`), 0o644))
+
+	db := Start(0)
+	require.NoError(t, db.LoadConfig(configPath))
+
+	tests := []struct {
+		name     string
+		client   string
+		address  string
+		expected []string
+	}{
+		{
+			name:     "every name of an override",
+			client:   "127.0.0.1",
+			address:  "10.0.168.192.in-addr.arpa",
+			expected: []string{"files.lan", "nas.lan"},
+		},
+		{
+			name:     "IPv6 override",
+			client:   "127.0.0.1",
+			address:  "0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa",
+			expected: []string{"nas.lan"},
+		},
+		{
+			name:     "group overrides",
+			client:   "192.168.1.5",
+			address:  "10.0.168.192.in-addr.arpa",
+			expected: []string{"games.lan"},
+		},
+		{
+			name:     "local zone",
+			client:   "127.0.0.1",
+			address:  "40.0.168.192.in-addr.arpa",
+			expected: []string{"tv.home.lan"},
+		},
+		{
+			name:    "wildcards have no reverse",
+			client:  "127.0.0.1",
+			address: "30.0.168.192.in-addr.arpa",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, _, err := db.GetRecord(netip.MustParseAddr(tt.client), tt.address, dns.TypePTR)
+			if tt.expected == nil {
+				assert.ErrorIs(t, err, ErrNotFound)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, &models.Record{PTR: tt.expected}, record)
+		})
+	}
+}
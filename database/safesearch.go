@@ -0,0 +1,36 @@
+package database
+
+import "regexp"
+
+// safeSearchTargets maps search engine names to the names that force
+// safe search on them
+var safeSearchTargets = map[string]string{
+	"youtube.com":              "restrict.youtube.com",
+	"www.youtube.com":          "restrict.youtube.com",
+	"m.youtube.com":            "restrict.youtube.com",
+	"youtubei.googleapis.com":  "restrict.youtube.com",
+	"youtube.googleapis.com":   "restrict.youtube.com",
+	"www.youtube-nocookie.com": "restrict.youtube.com",
+	"bing.com":                 "strict.bing.com",
+	"www.bing.com":             "strict.bing.com",
+	"duckduckgo.com":           "safe.duckduckgo.com",
+	"www.duckduckgo.com":       "safe.duckduckgo.com",
+	"start.duckduckgo.com":     "safe.duckduckgo.com",
+}
+
+// googleSearch matches Google search on any country domain, e.g:
+// www.google.co.uk
+var googleSearch = regexp.MustCompile(`^(www\.)?google\.([a-z]{2,3}|com?\.[a-z]{2})$`)
+
+// safeSearchTarget returns the name address is sent to when safe search
+// is on
+func safeSearchTarget(address string) (string, bool) {
+	if target, ok := safeSearchTargets[address]; ok {
+		return target, true
+	}
+	if googleSearch.MatchString(address) {
+		return "forcesafesearch.google.com", true
+	}
+
+	return "", false
+}
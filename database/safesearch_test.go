@@ -0,0 +1,88 @@
+package database
+
+import (
+	"net/netip"
+	"testing"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_safeSearchTarget(t *testing.T) {
+	tests := []struct {
+		address        string
+		expectedTarget string
+		expectedOk     bool
+	}{
+		{address: "www.google.com", expectedTarget: "forcesafesearch.google.com", expectedOk: true},
+		{address: "google.com", expectedTarget: "forcesafesearch.google.com", expectedOk: true},
+		{address: "www.google.co.uk", expectedTarget: "forcesafesearch.google.com", expectedOk: true},
+		{address: "www.google.com.au", expectedTarget: "forcesafesearch.google.com", expectedOk: true},
+		{address: "mail.google.com", expectedOk: false},
+		{address: "forcesafesearch.google.com", expectedOk: false},
+		{address: "www.youtube.com", expectedTarget: "restrict.youtube.com", expectedOk: true},
+		{address: "youtubei.googleapis.com", expectedTarget: "restrict.youtube.com", expectedOk: true},
+		{address: "www.bing.com", expectedTarget: "strict.bing.com", expectedOk: true},
+		{address: "duckduckgo.com", expectedTarget: "safe.duckduckgo.com", expectedOk: true},
+		{address: "example.com", expectedOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			target, ok := safeSearchTarget(tt.address)
+			assert.Equal(t, tt.expectedOk, ok)
+			assert.Equal(t, tt.expectedTarget, target)
+		})
+	}
+}
+
+func Test_getRecordSafeSearch(t *testing.T) {
+	on, off := true, false
+	config := &models.Config{
+		WhitelistDomains: map[string]interface{}{},
+		BlockedDomains:   map[string]interface{}{},
+		Hosts:            map[string]models.HostEntry{},
+		SafeSearch:       true,
+		Groups: map[string]models.GroupConfig{
+			"adults": {Clients: []string{"192.168.2.0/24"}, SafeSearch: &off},
+			"kids":   {Clients: []string{"192.168.1.0/24"}, SafeSearch: &on},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		client          string
+		expectedRecord  *models.Record
+		expectedOutcome models.Outcome
+	}{
+		{
+			name:            "Default group",
+			client:          "10.0.0.5",
+			expectedRecord:  &models.Record{CNAME: "forcesafesearch.google.com"},
+			expectedOutcome: models.OutcomeSafeSearch,
+		},
+		{
+			name:            "Group with safe search",
+			client:          "192.168.1.5",
+			expectedRecord:  &models.Record{CNAME: "forcesafesearch.google.com"},
+			expectedOutcome: models.OutcomeSafeSearch,
+		},
+		{
+			name:   "Group without safe search",
+			client: "192.168.2.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := Start(0)
+			db.Config = config
+
+			record, outcome, _ := db.GetRecord(netip.MustParseAddr(tt.client), "www.google.com", dns.TypeA)
+			assert.Equal(t, tt.expectedRecord, record)
+			assert.Equal(t, tt.expectedOutcome, outcome)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"dumbdns/logging"
+	"dumbdns/models"
+)
+
+// defaultGroup names the clients in no group in schedules
+const defaultGroup = "default"
+
+// weekdays are the names accepted for schedule days
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// schedule is a parsed ScheduleConfig
+type schedule struct {
+	name   string
+	groups []string
+	days   [7]bool
+	// start and end are minutes since midnight
+	start int
+	end   int
+}
+
+// schedules are the parsed schedules of a single config version
+type schedules struct {
+	config    *models.Config
+	location  *time.Location
+	schedules []schedule
+}
+
+// active reports whether the window of s includes t
+func (s schedule) active(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	switch {
+	case s.start == s.end:
+		// the whole day
+		return s.days[today]
+	case s.start < s.end:
+		return s.days[today] && minute >= s.start && minute < s.end
+	case minute >= s.start:
+		// the window ends tomorrow
+		return s.days[today]
+	default:
+		// the window started yesterday
+		return s.days[yesterday] && minute < s.end
+	}
+}
+
+// appliesTo reports whether s applies to the clients of group, which is
+// empty for the default group
+func (s schedule) appliesTo(group string) bool {
+	if len(s.groups) == 0 {
+		return true
+	}
+
+	return slices.Contains(s.groups, cmp.Or(group, defaultGroup))
+}
+
+// scheduleBlocked reports whether an active schedule of group blocks
+// address
+func (db *Database) scheduleBlocked(group string, address string) bool {
+	parsed := db.schedules()
+	if len(parsed.schedules) == 0 {
+		return false
+	}
+	now := db.now().In(parsed.location)
+
+	db.blockMux.RLock()
+	defer db.blockMux.RUnlock()
+	for _, s := range parsed.schedules {
+		if !s.appliesTo(group) || !s.active(now) {
+			continue
+		}
+		if _, blocked := db.scheduleBlockLists[s.name][address]; blocked {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ActiveSchedules returns the names of the schedules active now
+func (db *Database) ActiveSchedules() []string {
+	parsed := db.schedules()
+	now := db.now().In(parsed.location)
+
+	active := []string{}
+	for _, s := range parsed.schedules {
+		if s.active(now) {
+			active = append(active, s.name)
+		}
+	}
+
+	return active
+}
+
+// schedules returns the schedules of the current config, parsing them
+// the first time the config is seen
+func (db *Database) schedules() *schedules {
+	config := db.GetConfig()
+	parsed := db.parsedSchedules.Load()
+	if parsed != nil && parsed.config == config {
+		return parsed
+	}
+
+	parsed = &schedules{config: config, location: time.Local}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			logging.Warnf("using the local timezone for schedules: %v", err)
+		} else {
+			parsed.location = location
+		}
+	}
+	for _, sc := range config.Schedules {
+		s, err := parseSchedule(sc)
+		if err != nil {
+			logging.Warnf("skipping schedule %s: %v", sc.Name, err)
+			continue
+		}
+		parsed.schedules = append(parsed.schedules, s)
+	}
+	db.parsedSchedules.Store(parsed)
+
+	return parsed
+}
+
+func parseSchedule(sc models.ScheduleConfig) (schedule, error) {
+	s := schedule{name: sc.Name, groups: sc.Groups}
+
+	var err error
+	s.start, err = parseClock(sc.Start)
+	if err != nil {
+		return s, fmt.Errorf("invalid start: %w", err)
+	}
+	s.end, err = parseClock(sc.End)
+	if err != nil {
+		return s, fmt.Errorf("invalid end: %w", err)
+	}
+
+	if len(sc.Days) == 0 {
+		s.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, day := range sc.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return s, fmt.Errorf("unknown day %q", day)
+		}
+		s.days[weekday] = true
+	}
+
+	return s, nil
+}
+
+// parseClock returns the minutes since midnight of a "15:04" time
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time like 21:30", clock)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
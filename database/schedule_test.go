@@ -0,0 +1,189 @@
+package database
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/likexian/doh-go/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_scheduleActive(t *testing.T) {
+	// 2026-10-19 is a Monday
+	at := func(day int, clock string) time.Time {
+		minutes, err := parseClock(clock)
+		require.NoError(t, err)
+		return time.Date(2026, 10, 19+day, 0, minutes, 0, 0, time.UTC)
+	}
+	schoolNights := models.ScheduleConfig{Days: []string{"sun", "mon", "tue", "wed", "thu"}, Start: "20:00", End: "07:00"}
+
+	tests := []struct {
+		name     string
+		schedule models.ScheduleConfig
+		time     time.Time
+		expected bool
+	}{
+		{
+			name:     "Before a school night",
+			schedule: schoolNights,
+			time:     at(0, "19:59"),
+			expected: false,
+		},
+		{
+			name:     "Monday night",
+			schedule: schoolNights,
+			time:     at(0, "20:00"),
+			expected: true,
+		},
+		{
+			name:     "Tuesday morning after a school night",
+			schedule: schoolNights,
+			time:     at(1, "06:59"),
+			expected: true,
+		},
+		{
+			name:     "End of the window",
+			schedule: schoolNights,
+			time:     at(1, "07:00"),
+			expected: false,
+		},
+		{
+			name:     "Friday night isn't a school night",
+			schedule: schoolNights,
+			time:     at(4, "21:00"),
+			expected: false,
+		},
+		{
+			name:     "Friday morning after Thursday night",
+			schedule: schoolNights,
+			time:     at(4, "06:00"),
+			expected: true,
+		},
+		{
+			name:     "Saturday morning",
+			schedule: schoolNights,
+			time:     at(5, "06:00"),
+			expected: false,
+		},
+		{
+			name:     "Window within a day",
+			schedule: models.ScheduleConfig{Days: []string{"Monday"}, Start: "09:00", End: "15:30"},
+			time:     at(0, "12:00"),
+			expected: true,
+		},
+		{
+			name:     "Every day when no days are given",
+			schedule: models.ScheduleConfig{Start: "09:00", End: "15:30"},
+			time:     at(5, "12:00"),
+			expected: true,
+		},
+		{
+			name:     "Same start and end is the whole day",
+			schedule: models.ScheduleConfig{Days: []string{"sat", "sun"}, Start: "00:00", End: "00:00"},
+			time:     at(6, "23:59"),
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.schedule)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, s.active(tt.time))
+		})
+	}
+}
+
+func Test_getRecordSchedules(t *testing.T) {
+	gaming := models.Sources{Regex: `(?P<url>\S+)`, Url: "https://example.com/gaming"}
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+
+	config := &models.Config{
+		WhitelistDomains: map[string]interface{}{"minecraft.net": struct{}{}},
+		BlockedDomains:   map[string]interface{}{},
+		Hosts:            map[string]models.HostEntry{},
+		Timezone:         "Europe/London",
+		Groups: map[string]models.GroupConfig{
+			"kids": {Clients: []string{"192.168.1.0/24"}},
+		},
+		Schedules: []models.ScheduleConfig{
+			{
+				Name:           "school nights",
+				Groups:         []string{"kids"},
+				Days:           []string{"sun", "mon", "tue", "wed", "thu"},
+				Start:          "20:00",
+				End:            "07:00",
+				Blocklists:     []models.Sources{gaming},
+				BlockedDomains: []string{"tiktok.com"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		client          string
+		address         string
+		time            time.Time
+		expectedOutcome models.Outcome
+	}{
+		{
+			name:            "Blocked domain on a school night",
+			client:          "192.168.1.20",
+			address:         "tiktok.com",
+			time:            time.Date(2026, 10, 19, 21, 0, 0, 0, london),
+			expectedOutcome: models.OutcomeBlocked,
+		},
+		{
+			name:            "Block list domain on a school night",
+			client:          "192.168.1.20",
+			address:         "roblox.com",
+			time:            time.Date(2026, 10, 19, 21, 0, 0, 0, london),
+			expectedOutcome: models.OutcomeBlocked,
+		},
+		{
+			name:    "Whitelist applies to schedules",
+			client:  "192.168.1.20",
+			address: "minecraft.net",
+			time:    time.Date(2026, 10, 19, 21, 0, 0, 0, london),
+		},
+		{
+			name:    "Allowed during the day",
+			client:  "192.168.1.20",
+			address: "tiktok.com",
+			time:    time.Date(2026, 10, 19, 16, 0, 0, 0, london),
+		},
+		{
+			name:    "Schedule uses the configured timezone",
+			client:  "192.168.1.20",
+			address: "tiktok.com",
+			// 19:30 in London
+			time: time.Date(2026, 10, 19, 18, 30, 0, 0, time.UTC),
+		},
+		{
+			name:    "Other groups aren't scheduled",
+			client:  "10.0.0.5",
+			address: "tiktok.com",
+			time:    time.Date(2026, 10, 19, 21, 0, 0, 0, london),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := Start(0)
+			db.Config = config
+			db.now = func() time.Time { return tt.time }
+			db.sourceDatabase = map[models.Sources]map[string]interface{}{
+				gaming: {"roblox.com": struct{}{}, "minecraft.net": struct{}{}},
+			}
+			db.applyBlockList()
+
+			_, outcome, _ := db.GetRecord(netip.MustParseAddr(tt.client), tt.address, dns.TypeA)
+			assert.Equal(t, tt.expectedOutcome, outcome)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"dumbdns/models"
+
+	"github.com/miekg/dns"
+)
+
+// tsigAlgorithms are the HMAC algorithms TSIG keys can use
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// TSIGKey returns the key named name, with its algorithm as the fully
+// qualified name used in TSIG records, e.g: "hmac-sha256."
+func (db *Database) TSIGKey(name string) (models.TSIGKey, bool) {
+	for keyName, key := range db.GetConfig().TSIGKeys {
+		if strings.EqualFold(dns.Fqdn(keyName), dns.Fqdn(name)) {
+			key.Algorithm = tsigAlgorithm(key.Algorithm)
+			return key, true
+		}
+	}
+
+	return models.TSIGKey{}, false
+}
+
+// tsigAlgorithm returns the TSIG name of algorithm, hmac-sha256 when
+// it isn't set
+func tsigAlgorithm(algorithm string) string {
+	if algorithm == "" {
+		return dns.HmacSHA256
+	}
+
+	return tsigAlgorithms[strings.ToLower(strings.TrimSuffix(algorithm, "."))]
+}
+
+// UpdateZone applies an RFC 2136 dynamic update signed with keyName to
+// the local zone name, which must be the zone's origin. The zone file
+// is written back so the change survives a restart.
+func (db *Database) UpdateZone(name string, keyName string, prereqs []dns.RR, updates []dns.RR) (int, error) {
+	origin := strings.ToLower(dns.Fqdn(name))
+	local := db.local()
+	z, ok := local.zones[origin]
+	if !ok {
+		return dns.RcodeNotAuth, nil
+	}
+	if !db.updateAllowed(origin, keyName) {
+		return dns.RcodeRefused, nil
+	}
+
+	rcode, err := z.Update(prereqs, updates)
+	if err != nil || rcode != dns.RcodeSuccess {
+		return rcode, err
+	}
+
+	// the names of addresses that were added or removed change too
+	updated := *local
+	updated.ptr = local.zonePTR()
+	db.localData.Store(&updated)
+
+	// our own write shouldn't trigger a reload
+	db.configMux.Lock()
+	db.configModTime = configModTime(db.configPath, db.Config)
+	db.configMux.Unlock()
+
+	return dns.RcodeSuccess, nil
+}
+
+// updateAllowed reports whether keyName may update the zone origin
+func (db *Database) updateAllowed(origin string, keyName string) bool {
+	for zone, keys := range db.GetConfig().DynamicUpdates {
+		if zoneName(zone)+"." != origin {
+			continue
+		}
+		for _, key := range keys {
+			if strings.EqualFold(dns.Fqdn(key), dns.Fqdn(keyName)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// validateUpdates checks the TSIG keys and the zones they may update
+func validateUpdates(config *models.Config, add func(field string, value string, err error)) {
+	for _, name := range sortedKeys(config.TSIGKeys) {
+		key := config.TSIGKeys[name]
+		field := childPath("tsigKeys", name)
+		if !isDomainName(name) {
+			add(field, "", fmt.Errorf("invalid key name %q", name))
+		}
+		if tsigAlgorithm(key.Algorithm) == "" {
+			add(field+".algorithm", "", fmt.Errorf("unknown algorithm %q, expected one of hmac-sha1, hmac-sha224, hmac-sha256, hmac-sha384 or hmac-sha512", key.Algorithm))
+		}
+		if key.Secret == "" {
+			add(field+".secret", "", errors.New("a key needs a secret"))
+		} else if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil {
+			add(field+".secret", "", errors.New("the secret must be base64 encoded"))
+		}
+	}
+
+	zones := map[string]bool{}
+	for name := range config.Zones {
+		zones[zoneName(name)] = true
+	}
+	for _, name := range sortedKeys(config.DynamicUpdates) {
+		field := childPath("dynamicUpdates", name)
+		if !zones[zoneName(name)] {
+			add(field, "", fmt.Errorf("zone %q isn't in zones", name))
+		}
+		for i, key := range config.DynamicUpdates[name] {
+			if _, ok := config.TSIGKeys[key]; !ok {
+				add(fmt.Sprintf("%s[%d]", field, i), "", fmt.Errorf("unknown TSIG key %q", key))
+			}
+		}
+	}
+}
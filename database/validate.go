@@ -0,0 +1,408 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"dumbdns/dohClient"
+	"dumbdns/forwarder"
+	"dumbdns/logging"
+	"dumbdns/models"
+
+	"github.com/miekg/dns"
+)
+
+// ConfigError is a problem with a single field of the config file
+type ConfigError struct {
+	Path  string
+	Line  int
+	Field string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	location := e.Path
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d", e.Path, e.Line)
+	}
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %v", location, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s: %v", location, e.Field, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError is a ConfigError before its line is known. List entries
+// that are held in a map once decoded are found by value instead of by
+// index.
+type fieldError struct {
+	field string
+	value string
+	err   error
+}
+
+// CheckConfig reads and validates the config file at path, or
+// dumbdns.json when path is empty. Every problem found is returned as a
+// *ConfigError.
+func CheckConfig(path string) (*models.Config, error) {
+	config, _, err := checkConfig(path)
+
+	return config, err
+}
+
+// checkConfig is CheckConfig that also returns the zone and hosts files
+// read for the config
+func checkConfig(path string) (*models.Config, *localData, error) {
+	if path == "" {
+		path = configPath()
+	}
+
+	config, data, err := readConfigFromDisk(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fieldErrs := validateConfig(config)
+	local, localErrs := loadLocal(filepath.Dir(path), config)
+	fieldErrs = append(fieldErrs, localErrs...)
+	if len(fieldErrs) == 0 {
+		return config, local, nil
+	}
+
+	lines := configLines(path, data)
+	errs := make([]error, 0, len(fieldErrs))
+	for _, fe := range fieldErrs {
+		line, ok := lines[fe.field+"="+fe.value]
+		// a missing field is reported on the line of its parent
+		for field := fe.field; !ok && field != ""; field = parentPath(field) {
+			line, ok = lines[field]
+		}
+		errs = append(errs, &ConfigError{Path: path, Line: line, Field: fe.field, Err: fe.err})
+	}
+
+	return nil, nil, errors.Join(errs...)
+}
+
+// validateConfig checks the parts of the config that would otherwise
+// fail at query or refresh time
+func validateConfig(config *models.Config) []fieldError {
+	var errs []fieldError
+	add := func(field string, value string, err error) {
+		errs = append(errs, fieldError{field: field, value: value, err: err})
+	}
+
+	if config.Server.LogLevel != "" {
+		if _, err := logging.ParseLevel(config.Server.LogLevel); err != nil {
+			add("logLevel", "", err)
+		}
+	}
+	for i, name := range config.Server.Upstream {
+		if _, err := dohClient.ParseProviders([]string{name}); err != nil {
+			add(fmt.Sprintf("upstream[%d]", i), "", err)
+		}
+	}
+
+	validatePolicy("", config.Blocklists, config.WhitelistDomains, config.BlockedDomains, config.Hosts, config.BlockMode, add)
+	validateECS("", config.ECS, add)
+
+	for _, name := range sortedKeys(config.Groups) {
+		group := config.Groups[name]
+		prefix := childPath("groups", name)
+		if len(group.Clients) == 0 {
+			add(prefix+".clients", "", errors.New("a group needs at least one client"))
+		}
+		for i, client := range group.Clients {
+			if _, err := parsePrefix(client); err != nil && !isDomainName(client) {
+				add(fmt.Sprintf("%s.clients[%d]", prefix, i), "", fmt.Errorf("%q is not an ip, CIDR or name", client))
+			}
+		}
+		validatePolicy(prefix+".", group.Blocklists, group.WhitelistDomains, group.BlockedDomains, group.Hosts, group.BlockMode, add)
+		if group.ECS != nil {
+			validateECS(prefix+".", *group.ECS, add)
+		}
+	}
+
+	if config.Timezone != "" {
+		if _, err := time.LoadLocation(config.Timezone); err != nil {
+			add("timezone", "", fmt.Errorf("unknown timezone %q", config.Timezone))
+		}
+	}
+	names := map[string]bool{}
+	for i, sc := range config.Schedules {
+		prefix := fmt.Sprintf("schedules[%d]", i)
+		switch {
+		case sc.Name == "":
+			add(prefix+".name", "", errors.New("a schedule needs a name"))
+		case names[sc.Name]:
+			add(prefix+".name", "", fmt.Errorf("duplicate schedule name %q", sc.Name))
+		}
+		names[sc.Name] = true
+
+		for j, group := range sc.Groups {
+			if _, ok := config.Groups[group]; !ok && group != defaultGroup {
+				add(fmt.Sprintf("%s.groups[%d]", prefix, j), "", fmt.Errorf("unknown group %q", group))
+			}
+		}
+		for j, day := range sc.Days {
+			if _, ok := weekdays[strings.ToLower(day)]; !ok {
+				add(fmt.Sprintf("%s.days[%d]", prefix, j), "", fmt.Errorf("unknown day %q", day))
+			}
+		}
+		if _, err := parseClock(sc.Start); err != nil {
+			add(prefix+".start", "", err)
+		}
+		if _, err := parseClock(sc.End); err != nil {
+			add(prefix+".end", "", err)
+		}
+		validatePolicy(prefix+".", sc.Blocklists, nil, toDomainMap(sc.BlockedDomains), nil, "", add)
+	}
+
+	for _, zone := range sortedKeys(config.ForwardZones) {
+		field := childPath("forwardZones", zone)
+		if !isDomainName(zone) {
+			add(field, "", fmt.Errorf("invalid zone %q", zone))
+		}
+		if len(config.ForwardZones[zone]) == 0 {
+			add(field, "", errors.New("a forward zone needs at least one upstream"))
+		}
+		for i, upstream := range config.ForwardZones[zone] {
+			if _, err := forwarder.ParseUpstream(upstream); err != nil {
+				add(fmt.Sprintf("%s[%d]", field, i), "", err)
+			}
+		}
+	}
+
+	validateZones(config, add)
+	validateUpdates(config, add)
+
+	if len(config.DHCP.LeaseFiles) > 0 && config.DHCP.Domain == "" {
+		add("dhcp.domain", "", errors.New("a domain is needed to publish DHCP clients under"))
+	} else if config.DHCP.Domain != "" && !isDomainName(config.DHCP.Domain) {
+		add("dhcp.domain", "", fmt.Errorf("invalid domain name %q", config.DHCP.Domain))
+	}
+
+	if size := config.EDNS.UDPSize; size != 0 && (size < dns.MinMsgSize || size > dns.MaxMsgSize) {
+		add("edns.udpSize", "", fmt.Errorf("%d is not between %d and %d", size, dns.MinMsgSize, dns.MaxMsgSize))
+	}
+
+	if config.DNSSEC.TrustAnchorFile != "" && !config.DNSSEC.Validate {
+		add("dnssec.trustAnchorFile", "", errors.New("trust anchors are only used when validating"))
+	}
+
+	for i, entry := range config.Access.Allow {
+		if _, err := parsePrefix(entry); err != nil {
+			add(fmt.Sprintf("access.allow[%d]", i), "", err)
+		}
+	}
+	for i, entry := range config.Access.Deny {
+		if _, err := parsePrefix(entry); err != nil {
+			add(fmt.Sprintf("access.deny[%d]", i), "", err)
+		}
+	}
+
+	return errs
+}
+
+// validatePolicy checks the filtering settings shared by the top level
+// config and the groups, prefix is prepended to the field names
+func validatePolicy(prefix string, blocklists []models.Sources, whitelist map[string]interface{}, blocked map[string]interface{},
+	hosts map[string]models.HostEntry, blockMode string, add func(field string, value string, err error)) {
+	for i, s := range blocklists {
+		compRegEx, err := regexp.Compile(s.Regex)
+		if err != nil {
+			add(fmt.Sprintf("%sblockLists[%d].regex", prefix, i), "", err)
+		} else if !slices.Contains(compRegEx.SubexpNames(), "url") {
+			add(fmt.Sprintf("%sblockLists[%d].regex", prefix, i), "", errors.New("missing (?P<url>...) capture group"))
+		}
+
+		u, err := url.Parse(s.Url)
+		if err != nil {
+			add(fmt.Sprintf("%sblockLists[%d].url", prefix, i), "", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(fmt.Sprintf("%sblockLists[%d].url", prefix, i), "", fmt.Errorf("%q is not an http(s) url", s.Url))
+		}
+	}
+
+	for _, domain := range sortedKeys(whitelist) {
+		if !isDomainName(domain) {
+			add(prefix+"whitelist", domain, fmt.Errorf("invalid domain name %q", domain))
+		}
+	}
+	for _, domain := range sortedKeys(blocked) {
+		if !isDomainName(domain) {
+			add(prefix+"blockList", domain, fmt.Errorf("invalid domain name %q", domain))
+		}
+	}
+
+	for _, domain := range sortedKeys(hosts) {
+		field := childPath(prefix+"hostsFile", domain)
+		if !isHostName(domain) {
+			add(field, "", fmt.Errorf("invalid domain name %q", domain))
+		}
+		for _, err := range hostErrors(hosts[domain]) {
+			add(field, "", err)
+		}
+	}
+
+	switch blockMode {
+	case "", models.BlockModeLocalhost, models.BlockModeNull, models.BlockModeNXDomain, models.BlockModeRefused:
+	default:
+		add(prefix+"blockMode", "", fmt.Errorf("unknown block mode %q, expected localhost, null, nxdomain or refused", blockMode))
+	}
+}
+
+func isDomainName(domain string) bool {
+	if domain == "" {
+		return false
+	}
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return false
+	}
+	// dns.IsDomainName allows any character in a label, names are
+	// letters, digits and hyphens, with underscores for service labels
+	for _, c := range domain {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// decodeError adds the line to JSON syntax and type errors. data is nil
+// when the error offset doesn't point into the file on disk.
+func decodeError(path string, data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &ConfigError{Path: path, Line: lineAt(data, syntaxErr.Offset), Err: err}
+	case errors.As(err, &typeErr) && data == nil:
+		return &ConfigError{Path: path, Field: typeErr.Field, Err: err}
+	case errors.As(err, &typeErr):
+		return &ConfigError{Path: path, Line: lineAt(data, typeErr.Offset), Field: typeErr.Field, Err: err}
+	default:
+		return &ConfigError{Path: path, Err: fmt.Errorf("error decoding JSON: %w", err)}
+	}
+}
+
+// jsonLines maps the path of every value in a JSON document to the line
+// it starts on, e.g: "blockLists[0].regex". Strings in arrays are also
+// mapped by value, e.g: "whiteList=example.com".
+func jsonLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		start := lineAt(data, skipSeparators(data, dec.InputOffset()))
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			lines[path] = start
+		}
+
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				err = walk(childPath(path, key.(string)))
+				if err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				elementPath := fmt.Sprintf("%s[%d]", path, i)
+				err := walk(elementPath)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		}
+
+		if s, ok := tok.(string); ok && strings.HasSuffix(path, "]") {
+			lines[path[:strings.LastIndex(path, "[")]+"="+s] = start
+		}
+		return nil
+	}
+
+	// a broken document keeps the lines found before the error
+	_ = walk("")
+
+	return lines
+}
+
+// parentPath returns the path of the object holding path, e.g:
+// "dhcp" for "dhcp.domain"
+func parentPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i < 0 {
+		return ""
+	}
+
+	return path[:i]
+}
+
+// childPath returns the path of key in the object at path
+func childPath(path string, key string) string {
+	if !identifier.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// skipSeparators moves offset past the whitespace, commas and colons
+// the decoder hasn't consumed yet
+func skipSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+
+	return offset
+}
+
+func lineAt(data []byte, offset int64) int {
+	offset = min(offset, int64(len(data)))
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
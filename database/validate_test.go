@@ -0,0 +1,293 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CheckConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		// files are written next to the config file
+		files    map[string]string
+		expected []string
+	}{
+		{
+			name: "valid config",
+			config: `{
+  "blockLists": [{"regex": "0.0.0.0\\s+(?P<url>\\S+)", "url": "https://example.com/hosts"}],
+  "whiteList": ["example.com"],
+  "hostsFile": {"nas.lan": "192.168.0.10"},
+  "zones": {"home.lan": "home.lan.zone"}
+}`,
+			files: map[string]string{"home.lan.zone": "$ORIGIN home.lan.\n@ 3600 IN SOA ns admin 1 7200 900 1209600 300\nnas 3600 IN A 192.168.0.10\n"},
+		},
+		{
+			name: "regex without url group",
+			config: `{
+  "blockLists": [
+    {"regex": "0.0.0.0\\s+(\\S+)", "url": "https://example.com/hosts"}
+  ]
+}`,
+			expected: []string{`dumbdns.json:3: blockLists[0].regex: missing (?P<url>...) capture group`},
+		},
+		{
+			name: "invalid whitelist entry and hosts ip",
+			config: `{
+  "whiteList": [
+    "example.com",
+    "not a domain"
+  ],
+  "hostsFile": {
+    "nas.lan": "192.168.0.300"
+  }
+}`,
+			expected: []string{
+				`dumbdns.json:4: whitelist: invalid domain name "not a domain"`,
+				`dumbdns.json:7: hostsFile["nas.lan"]: invalid ip "192.168.0.300"`,
+			},
+		},
+		{
+			name: "punctuation in names",
+			config: `{
+  "version": 1,
+  "blockList": ["ads.example.com", "bad_name!"],
+  "hostsFile": {"_sip._tcp.lan": "192.168.0.5"}
+}`,
+			expected: []string{`dumbdns.json:3: blockList: invalid domain name "bad_name!"`},
+		},
+		{
+			name: "invalid hosts entries",
+			config: `{
+  "version": 1,
+  "hostsFile": {
+    "*.dev.lan": ["192.168.0.30"],
+    "files.lan": {"cname": "nas.lan", "a": ["192.168.0.10"]},
+    "nas.lan": {"aaaa": ["192.168.0.10"]},
+    "dev.*.lan": []
+  }
+}`,
+			expected: []string{
+				`dumbdns.json:7: hostsFile["dev.*.lan"]: invalid domain name "dev.*.lan"`,
+				`dumbdns.json:7: hostsFile["dev.*.lan"]: needs an ip or a cname`,
+				`dumbdns.json:5: hostsFile["files.lan"]: a cname can't be combined with ips`,
+				`dumbdns.json:6: hostsFile["nas.lan"]: invalid IPv6 address "192.168.0.10"`,
+			},
+		},
+		{
+			name: "invalid access entries",
+			config: `{
+  "version": 1,
+  "access": {
+    "allow": ["100.64.0.0/10", "192.168.0.0/33"],
+    "deny": ["not an ip"]
+  }
+}`,
+			expected: []string{
+				`dumbdns.json:4: access.allow[1]: invalid CIDR "192.168.0.0/33"`,
+				`dumbdns.json:5: access.deny[0]: invalid ip "not an ip"`,
+			},
+		},
+		{
+			name: "invalid group",
+			config: `{
+  "version": 1,
+  "groups": {
+    "kids": {
+      "clients": ["192.168.1.0/24", "not a name"],
+      "blockMode": "sinkhole",
+      "hostsFile": {"nas.lan": "nas"}
+    },
+    "empty": {"clients": []}
+  }
+}`,
+			expected: []string{
+				`dumbdns.json:9: groups.empty.clients: a group needs at least one client`,
+				`dumbdns.json:5: groups.kids.clients[1]: "not a name" is not an ip, CIDR or name`,
+				`dumbdns.json:7: groups.kids.hostsFile["nas.lan"]: invalid ip "nas"`,
+				`dumbdns.json:6: groups.kids.blockMode: unknown block mode "sinkhole", expected localhost, null, nxdomain or refused`,
+			},
+		},
+		{
+			name: "invalid schedule",
+			config: `{
+  "version": 1,
+  "timezone": "Europe/Nowhere",
+  "schedules": [
+    {
+      "name": "school nights",
+      "groups": ["kids"],
+      "days": ["mon", "someday"],
+      "start": "8pm",
+      "end": "07:00",
+      "blockList": ["tiktok.com"]
+    }
+  ]
+}`,
+			expected: []string{
+				`dumbdns.json:3: timezone: unknown timezone "Europe/Nowhere"`,
+				`dumbdns.json:7: schedules[0].groups[0]: unknown group "kids"`,
+				`dumbdns.json:8: schedules[0].days[1]: unknown day "someday"`,
+				`dumbdns.json:9: schedules[0].start: "8pm" is not a time like 21:30`,
+			},
+		},
+		{
+			name: "invalid forward zones",
+			config: `{
+  "version": 1,
+  "forwardZones": {
+    "corp.internal": ["udp://10.0.0.2", "10.0.0.3"],
+    "bad zone": []
+  }
+}`,
+			expected: []string{
+				`dumbdns.json:5: forwardZones["bad zone"]: invalid zone "bad zone"`,
+				`dumbdns.json:5: forwardZones["bad zone"]: a forward zone needs at least one upstream`,
+				`dumbdns.json:4: forwardZones["corp.internal"][1]: invalid upstream "10.0.0.3": missing host`,
+			},
+		},
+		{
+			name: "invalid zones",
+			config: `{
+  "version": 1,
+  "forwardZones": {"corp.internal": ["udp://10.0.0.2"]},
+  "zones": {
+    "bad zone": "bad.zone",
+    "corp.internal.": "corp.zone",
+    "home.lan": "/nonexistent/home.lan.zone"
+  }
+}`,
+			files: map[string]string{"corp.zone": "@ 3600 IN SOA ns admin 1 7200 900 1209600 300\n"},
+			expected: []string{
+				`dumbdns.json:5: zones["bad zone"]: invalid zone "bad zone"`,
+				`dumbdns.json:6: zones["corp.internal."]: zone "corp.internal." is also in forwardZones`,
+				`dumbdns.json:7: zones["home.lan"]: error opening zone file: open /nonexistent/home.lan.zone: no such file or directory`,
+			},
+		},
+		{
+			name: "invalid dynamic updates",
+			config: `{
+  "version": 1,
+  "zones": {"home.lan": "home.lan.zone"},
+  "tsigKeys": {
+    "containers": {"algorithm": "hmac-md5", "secret": "not base64!"},
+    "vms": {"secret": "c2VjcmV0"}
+  },
+  "dynamicUpdates": {
+    "home.lan.": ["containers", "laptops"],
+    "corp.internal": ["vms"]
+  }
+}`,
+			files: map[string]string{"home.lan.zone": "$ORIGIN home.lan.\n@ 3600 IN SOA ns admin 1 7200 900 1209600 300\n"},
+			expected: []string{
+				`dumbdns.json:5: tsigKeys.containers.algorithm: unknown algorithm "hmac-md5", expected one of hmac-sha1, hmac-sha224, hmac-sha256, hmac-sha384 or hmac-sha512`,
+				`dumbdns.json:5: tsigKeys.containers.secret: the secret must be base64 encoded`,
+				`dumbdns.json:10: dynamicUpdates["corp.internal"]: zone "corp.internal" isn't in zones`,
+				`dumbdns.json:9: dynamicUpdates["home.lan."][1]: unknown TSIG key "laptops"`,
+			},
+		},
+		{
+			name: "missing hosts file",
+			config: `{
+  "version": 1,
+  "hostsFiles": ["lab.hosts", "/nonexistent/office.hosts"]
+}`,
+			files: map[string]string{"lab.hosts": "192.168.0.10 nas.lab\n"},
+			expected: []string{
+				`dumbdns.json:3: hostsFiles[1]: error opening hosts file: open /nonexistent/office.hosts: no such file or directory`,
+			},
+		},
+		{
+			name: "dhcp without a domain",
+			config: `{
+  "version": 1,
+  "dhcp": {
+    "leaseFiles": ["/var/lib/misc/dnsmasq.leases"]
+  }
+}`,
+			expected: []string{`dumbdns.json:3: dhcp.domain: a domain is needed to publish DHCP clients under`},
+		},
+		{
+			name: "invalid EDNS buffer size",
+			config: `{
+  "version": 1,
+  "edns": {"udpSize": 100}
+}`,
+			expected: []string{`dumbdns.json:3: edns.udpSize: 100 is not between 512 and 65535`},
+		},
+		{
+			name: "trust anchors without validation",
+			config: `{
+  "version": 1,
+  "dnssec": {"trustAnchorFile": "/var/lib/dumbdns/anchors.json"}
+}`,
+			expected: []string{`dumbdns.json:3: dnssec.trustAnchorFile: trust anchors are only used when validating`},
+		},
+		{
+			name: "invalid ECS policies",
+			config: `{
+  "version": 1,
+  "ecs": {"mode": "replace"},
+  "groups": {
+    "office": {"clients": ["10.0.0.0/8"], "ecs": {"mode": "passthrough", "subnet": "198.51.100.0/24"}},
+    "lab": {"clients": ["10.1.0.0/16"], "ecs": {"mode": "anonymize"}}
+  }
+}`,
+			expected: []string{
+				`dumbdns.json:3: ecs.subnet: replace mode needs a subnet`,
+				`dumbdns.json:6: groups.lab.ecs.mode: unknown ECS mode "anonymize", expected strip, passthrough or replace`,
+				`dumbdns.json:5: groups.office.ecs.subnet: a subnet is only used in replace mode`,
+			},
+		},
+		{
+			name: "syntax error",
+			config: `{
+  "whiteList": [,]
+}`,
+			expected: []string{`dumbdns.json:2: invalid character ',' looking for beginning of value`},
+		},
+		{
+			name: "type error",
+			config: `{
+  "version": 1,
+  "whitelist": 3
+}`,
+			expected: []string{`dumbdns.json:3: whitelist: json: cannot unmarshal number into Go struct field configFile.whitelist of type []string`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dumbdns.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.config), 0o644))
+			for name, data := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(path), name), []byte(data), 0o644))
+			}
+
+			_, err := CheckConfig(path)
+			if len(tt.expected) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			actual := []string{}
+			var joined interface{ Unwrap() []error }
+			if errors.As(err, &joined) {
+				for _, e := range joined.Unwrap() {
+					actual = append(actual, e.Error())
+				}
+			} else {
+				actual = append(actual, err.Error())
+			}
+			for i := range actual {
+				actual[i] = actual[i][len(filepath.Dir(path))+1:]
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"dumbdns/models"
+	"dumbdns/zone"
+
+	"github.com/miekg/dns"
+)
+
+// LocalZone returns the longest local zone name is in, or nil when it
+// isn't in any
+func (db *Database) LocalZone(name string) *zone.Zone {
+	var longest *zone.Zone
+	for _, z := range db.local().zones {
+		if z.Contains(name) && (longest == nil || len(z.Origin) > len(longest.Origin)) {
+			longest = z
+		}
+	}
+
+	return longest
+}
+
+// loadZones parses the zone files in files, keyed by their origin.
+// Relative paths are relative to dir, the directory of the config file.
+func loadZones(dir string, files map[string]string) (map[string]*zone.Zone, []fieldError) {
+	zones := make(map[string]*zone.Zone, len(files))
+	var errs []fieldError
+	for _, name := range sortedKeys(files) {
+		if !isDomainName(name) {
+			continue
+		}
+		z, err := zone.Load(name, localPath(dir, files[name]))
+		if err != nil {
+			errs = append(errs, fieldError{field: childPath("zones", name), err: err})
+			continue
+		}
+		zones[z.Origin] = z
+	}
+
+	return zones, errs
+}
+
+// zoneName returns the lower case zone name without the trailing dot,
+// as used for forwardZones
+func zoneName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(dns.Fqdn(name), "."))
+}
+
+// validateZones checks the zone names, the files are checked when they
+// are loaded
+func validateZones(config *models.Config, add func(field string, value string, err error)) {
+	forwarded := map[string]bool{}
+	for name := range config.ForwardZones {
+		forwarded[zoneName(name)] = true
+	}
+
+	for _, name := range sortedKeys(config.Zones) {
+		field := childPath("zones", name)
+		if !isDomainName(name) {
+			add(field, "", fmt.Errorf("invalid zone %q", name))
+			continue
+		}
+		if forwarded[zoneName(name)] {
+			add(field, "", fmt.Errorf("zone %q is also in forwardZones", name))
+		}
+	}
+}
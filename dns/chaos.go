@@ -0,0 +1,45 @@
+package dnsClient
+
+import (
+	"strings"
+
+	"dumbdns/database"
+	"dumbdns/models"
+
+	"github.com/miekg/dns"
+)
+
+// answerChaos answers the CHAOS class question of m that identifies the
+// server, e.g: version.bind, from the chaos config
+func (d *DnsServer) answerChaos(m *dns.Msg) []models.Query {
+	q := m.Question[0]
+	query := models.Query{
+		Name:    database.CleanDomain(q.Name),
+		Type:    dns.Type(q.Qtype).String(),
+		Outcome: models.OutcomeZone,
+	}
+
+	config := d.db.GetConfig().Chaos
+	var text string
+	switch strings.ToLower(q.Name) {
+	case "version.bind.", "version.server.":
+		text = config.Version
+	case "id.server.", "hostname.bind.":
+		text = config.ID
+	}
+	if text == "" {
+		m.Rcode = dns.RcodeRefused
+		query.Outcome = models.OutcomeRefused
+		return []models.Query{query}
+	}
+
+	m.Authoritative = true
+	if q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeANY {
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+			Txt: []string{text},
+		})
+	}
+
+	return []models.Query{query}
+}
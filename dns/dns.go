@@ -5,13 +5,18 @@ import (
 	"dumbdns/models"
 	"errors"
 	"fmt"
-	"log"
 	"net"
+	"net/netip"
 	"strings"
+	"sync"
 	"time"
 
 	"dumbdns/database"
+	"dumbdns/dnssec"
 	"dumbdns/dohClient"
+	"dumbdns/forwarder"
+	"dumbdns/logging"
+	"dumbdns/zone"
 
 	dohDns "github.com/likexian/doh-go/dns"
 	"github.com/miekg/dns"
@@ -19,187 +24,559 @@ import (
 
 type DnsServer struct {
 	DnsServer *dns.Server
+	tcpServer *dns.Server
 	dohClient *dohClient.DohClient
 	db        *database.Database
+	validator *dnssec.Validator
+	recorders []QueryRecorder
+	inflight  sync.WaitGroup
 
 	refreshFreq time.Duration
 }
 
-func Start(port string, dohClient *dohClient.DohClient, db *database.Database) (*DnsServer, error) {
+// QueryRecorder is told about every question the server answers
+type QueryRecorder interface {
+	Record(q models.Query)
+}
+
+// Start binds port over UDP and TCP and serves DNS in the background.
+// Queries are answered once Start returns.
+func Start(port string, dohClient *dohClient.DohClient, db *database.Database, recorders ...QueryRecorder) (*DnsServer, error) {
 	d := &DnsServer{
 		dohClient: dohClient,
 		db:        db,
+		recorders: recorders,
 	}
 
-	dns.HandleFunc(".", d.handleDnsRequest)
-	d.DnsServer = &dns.Server{Addr: port, Net: "udp"}
+	// the trust anchors are only read at start, and kept up to date
+	// with the root key set from then on
+	anchors, err := dnssec.LoadAnchors(db.GetConfig().DNSSEC.TrustAnchorFile)
+	if err != nil {
+		return nil, fmt.Errorf("error starting service: %w", err)
+	}
+	d.validator = dnssec.New(func(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+		resp, _, err := dohClient.Exchange(ctx, m)
+		return resp, err
+	}, anchors)
 
-	log.Printf("Starting DumbDNS (with AdBlock) at %s\n", d.DnsServer.Addr)
-	err := d.DnsServer.ListenAndServe()
+	// the sockets are bound here so a port in use is reported to the
+	// caller rather than from the serving goroutine
+	conn, err := net.ListenPacket("udp", port)
 	if err != nil {
 		return nil, fmt.Errorf("error starting service: %w", err)
 	}
+	// TCP uses the port UDP got, so ":0" binds the same port twice
+	listener, err := net.Listen("tcp", conn.LocalAddr().String())
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error starting service: %w", err)
+	}
+	d.DnsServer = d.newServer(&dns.Server{PacketConn: conn, Net: "udp"})
+	d.tcpServer = d.newServer(&dns.Server{Listener: listener, Net: "tcp"})
+
+	logging.Infof("Starting DumbDNS (with AdBlock) at %s\n", conn.LocalAddr())
+	for _, server := range []*dns.Server{d.DnsServer, d.tcpServer} {
+		err := serve(server)
+		if err != nil {
+			d.DnsServer.Shutdown()
+			listener.Close()
+			return nil, fmt.Errorf("error starting service: %w", err)
+		}
+	}
 
 	return d, nil
 }
 
-func (d *DnsServer) handleDnsRequest(w dns.ResponseWriter, r *dns.Msg) {
-	cleanIP := strings.Split(w.RemoteAddr().String(), ":")
-	ip := net.ParseIP(cleanIP[0])
-	if !(ip.IsPrivate() || ip.IsLoopback()) {
-		w.Close()
-		return
+// newServer sets up server to answer with handleDnsRequest
+func (d *DnsServer) newServer(server *dns.Server) *dns.Server {
+	server.Handler = dns.HandlerFunc(d.handleDnsRequest)
+	server.TsigProvider = tsigProvider{db: d.db}
+	server.MsgAcceptFunc = acceptMsg
+
+	return server
+}
+
+// serve starts server in the background and waits until it's serving
+func serve(server *dns.Server) error {
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ActivateAndServe()
+	}()
+
+	select {
+	case <-started:
+		return nil
+	case err := <-served:
+		return err
+	}
+}
+
+// acceptMsg ignores responses and lets every request through to
+// handleDnsRequest, which replies to unsupported opcodes and malformed
+// queries itself. dns.DefaultMsgAcceptFunc would reject updates.
+func acceptMsg(dh dns.Header) dns.MsgAcceptAction {
+	if isResponse := dh.Bits&(1<<15) != 0; isResponse {
+		return dns.MsgIgnore
+	}
+
+	return dns.MsgAccept
+}
+
+// Shutdown stops accepting queries and waits for the ones being
+// answered to finish, or for ctx to be done
+func (d *DnsServer) Shutdown(ctx context.Context) error {
+	for _, server := range []*dns.Server{d.DnsServer, d.tcpServer} {
+		err := server.ShutdownContext(ctx)
+		if err != nil {
+			return fmt.Errorf("error stopping service: %w", err)
+		}
 	}
 
+	drained := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error draining queries: %w", ctx.Err())
+	}
+}
+
+func (d *DnsServer) handleDnsRequest(w dns.ResponseWriter, r *dns.Msg) {
+	d.inflight.Add(1)
+	defer d.inflight.Done()
+
+	client := clientAddr(w.RemoteAddr())
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	start := time.Now()
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Compress = false
-	switch r.Opcode {
-	case dns.OpcodeQuery:
-		d.ParseQuery(ctx, m)
+	var queries []models.Query
+	opt, ednsRcode := requestEdns(r)
+	requested := requestSubnet(opt)
+	subnet := d.db.ClientSubnet(client, requested)
+	switch {
+	case !d.db.ClientAllowed(client):
+		logging.Debugf("refusing query from %s", client)
+		m.SetRcode(r, dns.RcodeRefused)
+		for _, q := range m.Question {
+			queries = append(queries, models.Query{
+				Name:    database.CleanDomain(q.Name),
+				Type:    dns.Type(q.Qtype).String(),
+				Outcome: models.OutcomeRefused,
+			})
+		}
+	case ednsRcode != dns.RcodeSuccess:
+		m.SetRcode(r, ednsRcode)
+	case r.Opcode == dns.OpcodeUpdate:
+		d.handleUpdate(w, r, m)
+	case r.Opcode != dns.OpcodeQuery:
+		// NOTIFY, IQUERY and STATUS aren't served
+		m.SetRcode(r, dns.RcodeNotImplemented)
+	case len(r.Question) != 1:
+		// a query holds exactly one question, RFC 9619
+		m.SetRcode(r, dns.RcodeFormatError)
+	case r.Question[0].Qclass == dns.ClassCHAOS:
+		queries = d.answerChaos(m)
+	case r.Question[0].Qclass != dns.ClassINET:
+		m.SetRcode(r, dns.RcodeRefused)
+		queries = append(queries, models.Query{
+			Name:    database.CleanDomain(r.Question[0].Name),
+			Type:    dns.Type(r.Question[0].Qtype).String(),
+			Outcome: models.OutcomeRefused,
+		})
+	default:
+		queries = d.ParseQuery(ctx, client, subnet, m)
+		// only clients that understand the AD bit get it, RFC 6840 5.7
+		m.AuthenticatedData = m.AuthenticatedData && (r.AuthenticatedData || opt != nil && opt.Do())
 	}
+	d.replyEdns(w, opt, requested, subnet, m)
 
 	err := w.WriteMsg(m)
 	if err != nil {
-		fmt.Errorf("error writing response message: %w", err)
+		logging.Debugf("error writing response message: %v", err)
+	}
+
+	// recorders run after the reply is sent so they never delay it
+	latency := time.Since(start)
+	for i, q := range queries {
+		q.Time = start
+		q.Client = client.String()
+		q.ClientName = d.db.ClientName(client)
+		q.Rcode = dns.RcodeToString[m.Rcode]
+		q.Answers = answerSummary(m.Question[i].Name, m.Answer)
+		q.Latency = latency
+		for _, recorder := range d.recorders {
+			recorder.Record(q)
+		}
+	}
+}
+
+// clientAddr returns the IP of a client, without any IPv6 zone or
+// IPv4-mapped prefix
+func clientAddr(addr net.Addr) netip.Addr {
+	var addrPort netip.AddrPort
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		addrPort = a.AddrPort()
+	case *net.TCPAddr:
+		addrPort = a.AddrPort()
+	default:
+		// an unknown address never matches the access lists
+		addrPort, _ = netip.ParseAddrPort(addr.String())
+	}
+
+	return addrPort.Addr().Unmap().WithZone("")
+}
+
+// answerSummary returns the type and data of the answers for name,
+// e.g: "A 192.168.0.1"
+func answerSummary(name string, answers []dns.RR) []string {
+	summary := []string{}
+	for _, rr := range answers {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		data := strings.TrimPrefix(rr.String(), rr.Header().String())
+		summary = append(summary, dns.Type(rr.Header().Rrtype).String()+" "+data)
 	}
+
+	return summary
 }
 
-func (d *DnsServer) ParseQuery(ctx context.Context, m *dns.Msg) {
+// ParseQuery answers every question in m for client, returning how each
+// of them was answered. subnet is sent upstream as the client subnet.
+func (d *DnsServer) ParseQuery(ctx context.Context, client netip.Addr, subnet netip.Prefix, m *dns.Msg) []models.Query {
+	queries := make([]models.Query, 0, len(m.Question))
 	for _, q := range m.Question {
+		query := models.Query{
+			Name:    database.CleanDomain(q.Name),
+			Type:    dns.Type(q.Qtype).String(),
+			Outcome: models.OutcomeError,
+		}
+
+		if z := d.db.LocalZone(q.Name); z != nil {
+			d.answerZone(ctx, client, subnet, z, q, m, &query)
+			queries = append(queries, query)
+			continue
+		}
+
 		queryType, err := models.QueryToDoHType(q.Qtype)
 		if err != nil {
-			log.Printf("error getting query type: %v", err)
-			m.SetRcode(m, dns.RcodeServerFailure)
+			logging.Debugf("error getting query type: %v", err)
+			m.Rcode = dns.RcodeServerFailure
+			queries = append(queries, query)
 			continue
 		}
 
-		records, err := d.getRecords(ctx, q.Name, queryType)
+		records, err := d.getRecords(ctx, client, subnet, q.Name, queryType, &query)
 		if err != nil {
-			log.Printf("error fetching records for %s: %v", q.Name, err)
-			m.SetRcode(m, dns.RcodeServerFailure)
+			logging.Warnf("error fetching records for %s: %v", q.Name, err)
+			m.Rcode = dns.RcodeServerFailure
+			queries = append(queries, query)
 			continue
 		}
 
-		switch q.Qtype {
-		case dns.TypeA:
-			for _, v := range records.A {
-				rr, err := dns.NewRR(fmt.Sprintf("%s A %s", q.Name, v))
-				if err != nil {
-					log.Printf("error generating A record: %v", err)
-					continue
-				}
-				m.Answer = append(m.Answer, rr)
-			}
-		case dns.TypeAAAA:
-			for _, v := range records.AAAA {
-				rr, err := dns.NewRR(fmt.Sprintf("%s AAAA %s", q.Name, v))
-				if err != nil {
-					log.Printf("error generating AAAA record: %v", err)
-					continue
-				}
-				m.Answer = append(m.Answer, rr)
+		switch {
+		case records.Rcode != dns.RcodeSuccess:
+			m.Rcode = records.Rcode
+			// the NXDOMAIN of a private reverse zone comes with the SOA of
+			// the zone, so it can be cached like any other, RFC 2308
+			if zone, ok := database.PrivateReverse(query.Name); ok && query.Outcome == models.OutcomeZone {
+				m.Ns = append(m.Ns, privateReverseSOA(zone))
 			}
-		case dns.TypeMX:
-			for _, v := range records.MX {
-				rr, err := dns.NewRR(fmt.Sprintf("%s MX %s", q.Name, v))
-				if err != nil {
-					log.Printf("error generating MX record: %v", err)
-					continue
-				}
-				m.Answer = append(m.Answer, rr)
-			}
-		case dns.TypeCNAME:
-			if records.CNAME == "" {
+		case records.CNAME != "" && q.Qtype != dns.TypeCNAME &&
+			(query.Outcome == models.OutcomeSafeSearch || query.Outcome == models.OutcomeHosts):
+			m.Answer = append(m.Answer, d.chaseCNAME(ctx, client, subnet, q, queryType, records.CNAME, &query)...)
+		default:
+			m.Answer = append(m.Answer, answerRecords(q.Name, q.Qtype, records)...)
+		}
+		m.AuthenticatedData = records.DNSSEC[queryType] == models.DNSSECSecure
+		queries = append(queries, query)
+	}
+
+	return queries
+}
+
+// answerZone answers q authoritatively from the local zone z. A CNAME
+// to a name outside of z is followed like any other name.
+func (d *DnsServer) answerZone(ctx context.Context, client netip.Addr, subnet netip.Prefix, z *zone.Zone, q dns.Question, m *dns.Msg, query *models.Query) {
+	answer, authority, rcode := z.Lookup(q.Name, q.Qtype)
+	m.Authoritative = true
+	m.Rcode = rcode
+	m.Answer = append(m.Answer, answer...)
+	m.Ns = append(m.Ns, authority...)
+	query.Outcome = models.OutcomeZone
+
+	if len(answer) == 0 || q.Qtype == dns.TypeCNAME {
+		return
+	}
+	cname, ok := answer[len(answer)-1].(*dns.CNAME)
+	if !ok || z.Contains(cname.Target) {
+		return
+	}
+	queryType, err := models.QueryToDoHType(q.Qtype)
+	if err != nil {
+		return
+	}
+
+	targetQuery := models.Query{}
+	records, err := d.getRecords(ctx, client, subnet, cname.Target, queryType, &targetQuery)
+	query.Upstream = targetQuery.Upstream
+	query.UpstreamLatency = targetQuery.UpstreamLatency
+	if err != nil {
+		logging.Warnf("error fetching records for %s: %v", cname.Target, err)
+		return
+	}
+	m.Answer = append(m.Answer, answerRecords(cname.Target, q.Qtype, records)...)
+}
+
+// answerRecords returns the resource records of records for a
+// question of qtype about name
+func answerRecords(name string, qtype uint16, records *models.Record) []dns.RR {
+	answers := []dns.RR{}
+	switch qtype {
+	case dns.TypeA:
+		for _, v := range records.A {
+			rr, err := dns.NewRR(fmt.Sprintf("%s A %s", name, v))
+			if err != nil {
+				logging.Warnf("error generating A record: %v", err)
 				continue
 			}
-			rr, err := dns.NewRR(fmt.Sprintf("%s IN CNAME %s", q.Name, records.CNAME))
+			answers = append(answers, rr)
+		}
+	case dns.TypeAAAA:
+		for _, v := range records.AAAA {
+			rr, err := dns.NewRR(fmt.Sprintf("%s AAAA %s", name, v))
 			if err != nil {
-				log.Printf("error generating CNAME record: %v", err)
+				logging.Warnf("error generating AAAA record: %v", err)
 				continue
 			}
-			m.Answer = append(m.Answer, rr)
-		case dns.TypeNS:
-			for _, v := range records.NS {
-				rr, err := dns.NewRR(fmt.Sprintf("%s NS %s", q.Name, v))
-				if err != nil {
-					log.Printf("error generating NS record: %v", err)
-					continue
-				}
-				m.Answer = append(m.Answer, rr)
+			answers = append(answers, rr)
+		}
+	case dns.TypeMX:
+		for _, v := range records.MX {
+			rr, err := dns.NewRR(fmt.Sprintf("%s MX %s", name, v))
+			if err != nil {
+				logging.Warnf("error generating MX record: %v", err)
+				continue
 			}
-		case dns.TypeTXT:
-			for _, v := range records.TXT {
-				rr, err := dns.NewRR(fmt.Sprintf("%s TXT %s", q.Name, v))
-				if err != nil {
-					log.Printf("error generating TXT record: %v", err)
-					continue
-				}
-				m.Answer = append(m.Answer, rr)
+			answers = append(answers, rr)
+		}
+	case dns.TypeCNAME:
+		if records.CNAME == "" {
+			break
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s IN CNAME %s", name, records.CNAME))
+		if err != nil {
+			logging.Warnf("error generating CNAME record: %v", err)
+			break
+		}
+		answers = append(answers, rr)
+	case dns.TypeNS:
+		for _, v := range records.NS {
+			rr, err := dns.NewRR(fmt.Sprintf("%s NS %s", name, v))
+			if err != nil {
+				logging.Warnf("error generating NS record: %v", err)
+				continue
 			}
-		case dns.TypeSOA:
-			if records.SOA == "" {
+			answers = append(answers, rr)
+		}
+	case dns.TypeTXT:
+		for _, v := range records.TXT {
+			rr, err := dns.NewRR(fmt.Sprintf("%s TXT %s", name, v))
+			if err != nil {
+				logging.Warnf("error generating TXT record: %v", err)
 				continue
 			}
-			rr, err := dns.NewRR(fmt.Sprintf("%s SOA %s", q.Name, records.SOA))
+			answers = append(answers, rr)
+		}
+	case dns.TypeSOA:
+		if records.SOA == "" {
+			break
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s SOA %s", name, records.SOA))
+		if err != nil {
+			logging.Warnf("error generating SOA record: %v", err)
+			break
+		}
+		answers = append(answers, rr)
+	case dns.TypePTR:
+		for _, v := range records.PTR {
+			rr, err := dns.NewRR(fmt.Sprintf("%s PTR %s", name, v))
 			if err != nil {
-				log.Printf("error generating SOA record: %v", err)
+				logging.Warnf("error generating PTR record: %v", err)
 				continue
 			}
-			m.Answer = append(m.Answer, rr)
-		case dns.TypePTR:
-			for _, v := range records.PTR {
-				rr, err := dns.NewRR(fmt.Sprintf("%s PTR %s", q.Name, v))
-				if err != nil {
-					log.Printf("error generating PTR record: %v", err)
-					continue
-				}
-				m.Answer = append(m.Answer, rr)
+			answers = append(answers, rr)
+		}
+	case dns.TypeSRV:
+		for _, v := range records.SRV {
+			rr, err := dns.NewRR(fmt.Sprintf("%s SRV %s", name, v))
+			if err != nil {
+				logging.Warnf("error generating SRV record for %s with data %q: %v", name, v, err)
+				continue
 			}
-		case dns.TypeSRV:
-			for _, v := range records.SRV {
-				rr, err := dns.NewRR(fmt.Sprintf("%s SRV %s", q.Name, v))
-				if err != nil {
-					log.Printf("error generating SRV record for %s with data %q: %v", q.Name, v, err)
-					continue
-				}
-				m.Answer = append(m.Answer, rr)
+			answers = append(answers, rr)
+		}
+	case dns.TypeKX:
+		for _, v := range records.KX {
+			rr, err := dns.NewRR(fmt.Sprintf("%s KX %s", name, v))
+			if err != nil {
+				logging.Warnf("error generating KX record: %v", err)
+				continue
 			}
-		case dns.TypeKX:
-			for _, v := range records.KX {
-				rr, err := dns.NewRR(fmt.Sprintf("%s KX %s", q.Name, v))
-				if err != nil {
-					log.Printf("error generating KX record: %v", err)
-					continue
-				}
-				m.Answer = append(m.Answer, rr)
+			answers = append(answers, rr)
+		}
+	}
+
+	// the signatures of validated answers, dropped again for clients
+	// that didn't set the DO bit
+	if queryType, err := models.QueryToDoHType(qtype); err == nil && len(answers) > 0 {
+		for _, v := range records.RRSIG[queryType] {
+			rr, err := dns.NewRR(fmt.Sprintf("%s RRSIG %s", name, v))
+			if err != nil {
+				logging.Warnf("error generating RRSIG record: %v", err)
+				continue
 			}
+			answers = append(answers, rr)
 		}
 	}
+
+	return answers
 }
 
-func (d *DnsServer) getRecords(ctx context.Context, address string, queryType dohDns.Type) (*models.Record, error) {
-	// remove the "." from the end of the passed in address (google.com.)
-	address = address[:len(address)-1]
+// maxCNAMEChain limits how many hosts overrides a CNAME is followed
+// through
+const maxCNAMEChain = 8
 
-	record, err := d.db.GetRecord(address, queryType)
-	if errors.Is(err, database.ErrNotFound) {
-		resp := d.dohClient.QueryAuthority(ctx, address, queryType)
-		if len(resp) == 0 {
-			return record, fmt.Errorf("no response found")
-		}
+// chaseCNAME answers q with a CNAME to target followed by the records of
+// target, which are looked up like any other name. Hosts overrides can
+// point at another hosts override, which is followed too.
+func (d *DnsServer) chaseCNAME(ctx context.Context, client netip.Addr, subnet netip.Prefix, q dns.Question, queryType dohDns.Type, target string, query *models.Query) []dns.RR {
+	answers := []dns.RR{}
+	name := q.Name
+	for range maxCNAMEChain {
+		target = dns.Fqdn(target)
+		answers = append(answers, answerRecords(name, dns.TypeCNAME, &models.Record{CNAME: target})...)
 
-		now := time.Now().UTC()
-		record, err := d.db.AddRecord(now, address, queryType, resp)
+		targetQuery := models.Query{}
+		records, err := d.getRecords(ctx, client, subnet, target, queryType, &targetQuery)
+		query.Upstream = targetQuery.Upstream
+		query.UpstreamLatency = targetQuery.UpstreamLatency
 		if err != nil {
-			return record, fmt.Errorf("error adding record: %w", err)
+			logging.Warnf("error fetching records for %s: %v", target, err)
+			return answers
 		}
+		if targetQuery.Outcome != models.OutcomeHosts || records.CNAME == "" {
+			return append(answers, answerRecords(target, q.Qtype, records)...)
+		}
+		name, target = target, records.CNAME
+	}
 
+	logging.Warnf("CNAME chain of %s is longer than %d", q.Name, maxCNAMEChain)
+	return answers
+}
+
+// getRecords returns the records for address as seen by client, filling
+// in how they were found on query. Answers tailored to subnet are cached
+// apart from the others.
+func (d *DnsServer) getRecords(ctx context.Context, client netip.Addr, subnet netip.Prefix, address string, queryType dohDns.Type, query *models.Query) (*models.Record, error) {
+	// remove the "." from the end of the passed in address (google.com.)
+	address = address[:len(address)-1]
+
+	record, outcome, err := d.db.GetRecord(client, address, queryType)
+	if err == nil {
+		query.Outcome = outcome
+		return record, nil
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return nil, fmt.Errorf("error getting record: %w", err)
+	}
+	if record, ok := d.db.GetSubnetRecord(address, subnet, queryType); ok {
+		query.Outcome = models.OutcomeCached
 		return record, nil
 	}
 
+	// forward zones are internal, their answers skip the filters below
+	if upstreams := d.db.ForwardZone(address); upstreams != nil {
+		return d.forward(ctx, upstreams, subnet, address, queryType, query)
+	}
+
+	// reverse names of private ranges without a local answer don't
+	// exist, and asking upstream would leak internal addresses
+	if _, ok := database.PrivateReverse(address); ok {
+		query.Outcome = models.OutcomeZone
+		return &models.Record{Rcode: dns.RcodeNameError}, nil
+	}
+
+	if d.db.GetConfig().DNSSEC.Validate {
+		return d.resolveValidated(ctx, subnet, address, queryType, query)
+	}
+
+	start := time.Now()
+	resp, provider := d.dohClient.QueryAuthority(ctx, address, queryType, subnet)
+	query.Upstream = provider
+	query.UpstreamLatency = time.Since(start)
+	if len(resp) == 0 {
+		return record, fmt.Errorf("no response found")
+	}
+
+	// DoH providers don't say which part of subnet an answer applies to
+	now := time.Now().UTC()
+	record, err = d.db.AddRecord(now, database.CacheKey(address, subnet, subnet.Bits()), queryType, resp)
+	if err != nil {
+		return record, fmt.Errorf("error adding record: %w", err)
+	}
+	query.Outcome = models.OutcomeForwarded
+
+	return record, nil
+}
+
+// privateReverseSOA returns the SOA of a private reverse zone answered
+// locally, with the values RFC 6303 2.1 gives
+func privateReverseSOA(zone string) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 10800},
+		Ns:      dns.Fqdn(zone),
+		Mbox:    "nobody.invalid.",
+		Serial:  1,
+		Refresh: 604800,
+		Retry:   86400,
+		Expire:  2419200,
+		Minttl:  10800,
+	}
+}
+
+// forward looks up address with the upstreams of its forward zone.
+// Negative answers are passed on but not cached.
+func (d *DnsServer) forward(ctx context.Context, upstreams []string, subnet netip.Prefix, address string, queryType dohDns.Type, query *models.Query) (*models.Record, error) {
+	start := time.Now()
+	result, err := forwarder.Query(ctx, upstreams, address, dns.StringToType[string(queryType)], subnet)
+	query.Upstream = result.Upstream
+	query.UpstreamLatency = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	query.Outcome = models.OutcomeForwarded
+
+	if result.Rcode != dns.RcodeSuccess || len(result.Data) == 0 {
+		return &models.Record{Rcode: result.Rcode}, nil
+	}
+
+	record, err := d.db.AddRecord(time.Now().UTC(), database.CacheKey(address, subnet, result.Scope), queryType, result.Data)
+	if err != nil {
+		return record, fmt.Errorf("error adding record: %w", err)
+	}
+
 	return record, nil
 }
@@ -0,0 +1,445 @@
+package dnsClient
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dumbdns/database"
+	"dumbdns/models"
+
+	dohDns "github.com/likexian/doh-go/dns"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `{
+  "version": 1,
+  "hostsFile": {"nas.lan": "192.168.0.10"},
+  "zones": {"home.lan": "home.lan.zone"},
+  "chaos": {"version": "DumbDNS"}
+}`
+
+const testZone = `$ORIGIN home.lan.
+@   3600 IN SOA ns admin 1 7200 900 1209600 300
+web 3600 IN A   192.168.0.30
+`
+
+// testWriter is a dns.ResponseWriter that keeps the reply
+type testWriter struct {
+	remote net.Addr
+	reply  *dns.Msg
+}
+
+func (w *testWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+func (w *testWriter) RemoteAddr() net.Addr        { return w.remote }
+func (w *testWriter) WriteMsg(m *dns.Msg) error   { w.reply = m; return nil }
+func (w *testWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *testWriter) Close() error                { return nil }
+func (w *testWriter) TsigStatus() error           { return nil }
+func (w *testWriter) TsigTimersOnly(bool)         {}
+func (w *testWriter) Hijack()                     {}
+
+func testServer(t *testing.T) *DnsServer {
+	dir := t.TempDir()
+	// big has more addresses than fit in 512 bytes
+	zone := testZone
+	for i := range 40 {
+		zone += fmt.Sprintf("big 3600 IN A 192.168.1.%d\n", i)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "home.lan.zone"), []byte(zone), 0o644))
+	path := filepath.Join(dir, "dumbdns.json")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0o644))
+
+	db := database.Start(0)
+	require.NoError(t, db.LoadConfig(path))
+
+	return &DnsServer{db: db}
+}
+
+func question(name string, qtype uint16, qclass uint16) dns.Question {
+	return dns.Question{Name: dns.Fqdn(name), Qtype: qtype, Qclass: qclass}
+}
+
+func Test_handleDnsRequest(t *testing.T) {
+	d := testServer(t)
+
+	tests := []struct {
+		name string
+		// client defaults to 127.0.0.1
+		client         string
+		opcode         int
+		questions      []dns.Question
+		expectedRcode  int
+		expectedAA     bool
+		expectedAnswer []string
+		expectedNs     []string
+	}{
+		{
+			name:           "local zone",
+			questions:      []dns.Question{question("web.home.lan", dns.TypeA, dns.ClassINET)},
+			expectedRcode:  dns.RcodeSuccess,
+			expectedAA:     true,
+			expectedAnswer: []string{"web.home.lan.\t3600\tIN\tA\t192.168.0.30"},
+		},
+		{
+			name:           "hosts override",
+			questions:      []dns.Question{question("nas.lan", dns.TypeA, dns.ClassINET)},
+			expectedRcode:  dns.RcodeSuccess,
+			expectedAnswer: []string{"nas.lan.\t3600\tIN\tA\t192.168.0.10"},
+		},
+		{
+			name:          "private reverse zone",
+			questions:     []dns.Question{question("5.0.0.10.in-addr.arpa", dns.TypePTR, dns.ClassINET)},
+			expectedRcode: dns.RcodeNameError,
+			expectedNs:    []string{"10.in-addr.arpa.\t10800\tIN\tSOA\t10.in-addr.arpa. nobody.invalid. 1 604800 86400 2419200 10800"},
+		},
+		{
+			name:          "client not allowed",
+			client:        "203.0.113.5",
+			questions:     []dns.Question{question("web.home.lan", dns.TypeA, dns.ClassINET)},
+			expectedRcode: dns.RcodeRefused,
+		},
+		{
+			name:          "NOTIFY",
+			opcode:        dns.OpcodeNotify,
+			questions:     []dns.Question{question("home.lan", dns.TypeSOA, dns.ClassINET)},
+			expectedRcode: dns.RcodeNotImplemented,
+		},
+		{
+			name:          "IQUERY",
+			opcode:        dns.OpcodeIQuery,
+			expectedRcode: dns.RcodeNotImplemented,
+		},
+		{
+			name:          "STATUS",
+			opcode:        dns.OpcodeStatus,
+			expectedRcode: dns.RcodeNotImplemented,
+		},
+		{
+			name:          "unsigned UPDATE",
+			opcode:        dns.OpcodeUpdate,
+			questions:     []dns.Question{question("home.lan", dns.TypeSOA, dns.ClassINET)},
+			expectedRcode: dns.RcodeRefused,
+		},
+		{
+			name:          "no question",
+			expectedRcode: dns.RcodeFormatError,
+		},
+		{
+			name: "several questions",
+			questions: []dns.Question{
+				question("web.home.lan", dns.TypeA, dns.ClassINET),
+				question("nas.lan", dns.TypeA, dns.ClassINET),
+			},
+			expectedRcode: dns.RcodeFormatError,
+		},
+		{
+			name:          "HESIOD class",
+			questions:     []dns.Question{question("web.home.lan", dns.TypeA, dns.ClassHESIOD)},
+			expectedRcode: dns.RcodeRefused,
+		},
+		{
+			name:           "CHAOS version.bind",
+			questions:      []dns.Question{question("version.bind", dns.TypeTXT, dns.ClassCHAOS)},
+			expectedRcode:  dns.RcodeSuccess,
+			expectedAA:     true,
+			expectedAnswer: []string{"version.bind.\t0\tCH\tTXT\t\"DumbDNS\""},
+		},
+		{
+			name:          "CHAOS id.server isn't set",
+			questions:     []dns.Question{question("id.server", dns.TypeTXT, dns.ClassCHAOS)},
+			expectedRcode: dns.RcodeRefused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := "127.0.0.1"
+			if tt.client != "" {
+				client = tt.client
+			}
+			w := &testWriter{remote: &net.UDPAddr{IP: net.ParseIP(client), Port: 5353}}
+
+			r := new(dns.Msg)
+			r.Id = dns.Id()
+			r.Opcode = tt.opcode
+			r.RecursionDesired = true
+			r.Question = tt.questions
+			d.handleDnsRequest(w, r)
+
+			require.NotNil(t, w.reply)
+			assert.Equal(t, r.Id, w.reply.Id)
+			assert.True(t, w.reply.Response)
+			assert.Equal(t, dns.RcodeToString[tt.expectedRcode], dns.RcodeToString[w.reply.Rcode])
+			assert.Equal(t, tt.expectedAA, w.reply.Authoritative)
+
+			actual := []string{}
+			for _, rr := range w.reply.Answer {
+				actual = append(actual, rr.String())
+			}
+			assert.ElementsMatch(t, tt.expectedAnswer, actual)
+
+			actual = []string{}
+			for _, rr := range w.reply.Ns {
+				actual = append(actual, rr.String())
+			}
+			assert.ElementsMatch(t, tt.expectedNs, actual)
+		})
+	}
+}
+
+func Test_acceptMsg(t *testing.T) {
+	request := dns.Header{Bits: uint16(dns.OpcodeUpdate) << 11, Qdcount: 1, Ancount: 3, Nscount: 2}
+	assert.Equal(t, dns.MsgAccept, acceptMsg(request))
+
+	response := dns.Header{Bits: 1 << 15, Qdcount: 1}
+	assert.Equal(t, dns.MsgIgnore, acceptMsg(response))
+}
+
+func Test_edns(t *testing.T) {
+	d := testServer(t)
+
+	tests := []struct {
+		name string
+		tcp  bool
+		// udpSize is the client buffer size, no OPT record is sent when 0
+		udpSize         uint16
+		version         uint8
+		do              bool
+		expectedRcode   int
+		expectedOPT     bool
+		expectedTC      bool
+		expectedAnswers int
+	}{
+		{
+			name:            "no EDNS over UDP is truncated to 512 bytes",
+			expectedTC:      true,
+			expectedAnswers: 30,
+		},
+		{
+			name:            "no EDNS over TCP",
+			tcp:             true,
+			expectedAnswers: 40,
+		},
+		{
+			name:            "large buffer",
+			udpSize:         4096,
+			expectedOPT:     true,
+			expectedAnswers: 40,
+		},
+		{
+			name:            "small buffer is truncated",
+			udpSize:         600,
+			expectedOPT:     true,
+			expectedTC:      true,
+			expectedAnswers: 34,
+		},
+		{
+			name:            "DO bit is echoed",
+			udpSize:         4096,
+			do:              true,
+			expectedOPT:     true,
+			expectedAnswers: 40,
+		},
+		{
+			name:          "unknown version",
+			udpSize:       4096,
+			version:       1,
+			expectedRcode: dns.RcodeBadVers,
+			expectedOPT:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &testWriter{remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}}
+			if tt.tcp {
+				w.remote = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+			}
+
+			r := new(dns.Msg)
+			r.SetQuestion("big.home.lan.", dns.TypeA)
+			if tt.udpSize != 0 {
+				r.SetEdns0(tt.udpSize, tt.do)
+				r.IsEdns0().SetVersion(tt.version)
+			}
+			d.handleDnsRequest(w, r)
+
+			require.NotNil(t, w.reply)
+			// the reply must survive packing, e.g: BADVERS needs an OPT record
+			_, err := w.reply.Pack()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedRcode, w.reply.Rcode)
+			assert.Equal(t, tt.expectedTC, w.reply.Truncated)
+			assert.Len(t, w.reply.Answer, tt.expectedAnswers)
+
+			opt := w.reply.IsEdns0()
+			if !tt.expectedOPT {
+				assert.Nil(t, opt)
+				return
+			}
+			require.NotNil(t, opt)
+			assert.Equal(t, uint16(defaultUDPSize), opt.UDPSize())
+			assert.Equal(t, tt.do, opt.Do())
+			assert.Equal(t, uint8(0), opt.Version())
+		})
+	}
+}
+
+func Test_withoutDNSSEC(t *testing.T) {
+	a, _ := dns.NewRR("web.home.lan. 3600 IN A 192.168.0.30")
+	rrsig, _ := dns.NewRR("web.home.lan. 3600 IN RRSIG A 13 3 3600 20300101000000 20200101000000 12345 home.lan. aGVsbG8=")
+	nsec, _ := dns.NewRR("web.home.lan. 3600 IN NSEC x.home.lan. A RRSIG NSEC")
+
+	assert.Equal(t, []dns.RR{a}, withoutDNSSEC([]dns.RR{a, rrsig, nsec}, dns.TypeA))
+	assert.Equal(t, []dns.RR{rrsig}, withoutDNSSEC([]dns.RR{rrsig, nsec}, dns.TypeRRSIG))
+	assert.Equal(t, []dns.RR{a, rrsig, nsec}, withoutDNSSEC([]dns.RR{a, rrsig, nsec}, dns.TypeANY))
+}
+
+func Test_signatures(t *testing.T) {
+	d := testServer(t)
+	d.db.Config.DNSSEC.Validate = true
+	d.db.TTL = time.Minute
+	_, err := d.db.AddValidatedRecord(time.Now(), "signed.example", dohDns.TypeA, []string{"192.0.2.1"}, models.DNSSECSecure,
+		[]string{"A 13 2 300 20300101000000 20200101000000 12345 signed.example. aGVsbG8="})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		edns          bool
+		do            bool
+		expectedTypes []uint16
+	}{
+		{name: "DO set", edns: true, do: true, expectedTypes: []uint16{dns.TypeA, dns.TypeRRSIG}},
+		{name: "DO not set", edns: true, expectedTypes: []uint16{dns.TypeA}},
+		{name: "no EDNS", expectedTypes: []uint16{dns.TypeA}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &testWriter{remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}}
+			r := new(dns.Msg)
+			r.SetQuestion("signed.example.", dns.TypeA)
+			if tt.edns {
+				r.SetEdns0(4096, tt.do)
+			}
+			d.handleDnsRequest(w, r)
+
+			require.NotNil(t, w.reply)
+			types := []uint16{}
+			for _, rr := range w.reply.Answer {
+				types = append(types, rr.Header().Rrtype)
+			}
+			assert.Equal(t, tt.expectedTypes, types)
+			if tt.do {
+				sig := w.reply.Answer[1].(*dns.RRSIG)
+				assert.Equal(t, "signed.example.", sig.Hdr.Name)
+				assert.Equal(t, dns.TypeA, sig.TypeCovered)
+				assert.Equal(t, uint16(12345), sig.KeyTag)
+			}
+		})
+	}
+}
+
+func Test_ednsClientSubnet(t *testing.T) {
+	d := testServer(t)
+
+	tests := []struct {
+		name          string
+		requested     string
+		subnet        string
+		expectedScope uint8
+	}{
+		{name: "stripped subnet has no scope", requested: "203.0.113.77/24"},
+		{name: "replaced subnet has no scope", requested: "203.0.113.77/24", subnet: "198.51.100.0/24"},
+		{name: "passed through subnet", requested: "203.0.113.77/24", subnet: "203.0.113.0/24", expectedScope: 24},
+		{name: "IPv6 subnet", requested: "2001:db8:1:2::/56", subnet: "2001:db8:1::/56", expectedScope: 56},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &testWriter{remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}}
+			requested := netip.MustParsePrefix(tt.requested)
+			var subnet netip.Prefix
+			if tt.subnet != "" {
+				subnet = netip.MustParsePrefix(tt.subnet)
+			}
+
+			r := new(dns.Msg)
+			r.SetQuestion("web.home.lan.", dns.TypeA)
+			r.SetEdns0(4096, false)
+			r.IsEdns0().Option = append(r.IsEdns0().Option, &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: uint8(requested.Bits()),
+				Address:       requested.Addr().AsSlice(),
+			})
+			if requested.Addr().Is6() {
+				r.IsEdns0().Option[0].(*dns.EDNS0_SUBNET).Family = 2
+			}
+			opt, _ := requestEdns(r)
+			assert.Equal(t, requested.Masked(), requestSubnet(opt))
+
+			m := new(dns.Msg)
+			m.SetReply(r)
+			d.replyEdns(w, opt, requested, subnet, m)
+
+			_, err := m.Pack()
+			require.NoError(t, err)
+			require.NotNil(t, m.IsEdns0())
+			require.Len(t, m.IsEdns0().Option, 1)
+			ecs := m.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+			assert.Equal(t, uint8(requested.Bits()), ecs.SourceNetmask)
+			assert.Equal(t, tt.expectedScope, ecs.SourceScope)
+		})
+	}
+}
+
+func Test_authenticatedData(t *testing.T) {
+	d := testServer(t)
+	d.db.Config.DNSSEC.Validate = true
+	d.db.TTL = time.Minute
+	_, err := d.db.AddValidatedRecord(time.Now(), "signed.example", dohDns.TypeA, []string{"192.0.2.1"}, models.DNSSECSecure, nil)
+	require.NoError(t, err)
+	_, err = d.db.AddValidatedRecord(time.Now(), "unsigned.example", dohDns.TypeA, []string{"192.0.2.2"}, models.DNSSECInsecure, nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		question   string
+		do         bool
+		ad         bool
+		expectedAD bool
+	}{
+		{name: "secure answer with DO", question: "signed.example", do: true, expectedAD: true},
+		{name: "secure answer with AD", question: "signed.example", ad: true, expectedAD: true},
+		{name: "secure answer to an old client", question: "signed.example"},
+		{name: "insecure answer", question: "unsigned.example", do: true},
+		{name: "hosts override", question: "nas.lan", do: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &testWriter{remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}}
+			r := new(dns.Msg)
+			r.SetQuestion(dns.Fqdn(tt.question), dns.TypeA)
+			r.AuthenticatedData = tt.ad
+			if tt.do {
+				r.SetEdns0(4096, true)
+			}
+			d.handleDnsRequest(w, r)
+
+			require.NotNil(t, w.reply)
+			require.Len(t, w.reply.Answer, 1)
+			assert.Equal(t, tt.expectedAD, w.reply.AuthenticatedData)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package dnsClient
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"dumbdns/database"
+	"dumbdns/dnssec"
+	"dumbdns/forwarder"
+	"dumbdns/logging"
+	"dumbdns/models"
+
+	dohDns "github.com/likexian/doh-go/dns"
+	"github.com/miekg/dns"
+)
+
+// resolveValidated looks up address upstream along with the records
+// needed to validate the answer. Bogus answers are answered with
+// SERVFAIL, negative answers are passed on but not cached.
+func (d *DnsServer) resolveValidated(ctx context.Context, subnet netip.Prefix, address string, queryType dohDns.Type, query *models.Query) (*models.Record, error) {
+	start := time.Now()
+	resp, provider, err := d.dohClient.QueryDNSSEC(ctx, address, queryType, subnet)
+	query.Upstream = provider
+	if err != nil {
+		query.UpstreamLatency = time.Since(start)
+		return nil, err
+	}
+	// the keys of the chain of trust are looked up upstream too
+	status, err := d.validator.Validate(ctx, resp)
+	query.UpstreamLatency = time.Since(start)
+	if status == dnssec.Bogus {
+		logging.Warnf("Bogus answer for %s %s: %v", address, queryType, err)
+		query.Outcome = models.OutcomeBogus
+		return &models.Record{Rcode: dns.RcodeServerFailure}, nil
+	}
+	query.Outcome = models.OutcomeForwarded
+
+	state := models.DNSSECInsecure
+	if status == dnssec.Secure {
+		state = models.DNSSECSecure
+	}
+	data := forwarder.Answers(resp, dns.StringToType[string(queryType)])
+	if resp.Rcode != dns.RcodeSuccess || len(data) == 0 {
+		return &models.Record{Rcode: resp.Rcode, DNSSEC: map[dohDns.Type]string{queryType: state}}, nil
+	}
+
+	key := database.CacheKey(address, subnet, forwarder.SubnetScope(resp))
+	signatures := forwarder.Signatures(resp, address, dns.StringToType[string(queryType)])
+	record, err := d.db.AddValidatedRecord(time.Now().UTC(), key, queryType, data, state, signatures)
+	if err != nil {
+		return record, fmt.Errorf("error adding record: %w", err)
+	}
+
+	return record, nil
+}
@@ -0,0 +1,135 @@
+package dnsClient
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+// defaultUDPSize is advertised when edns.udpSize isn't set, it's the
+// size recommended by DNS flag day 2020 to avoid IP fragmentation
+const defaultUDPSize = 1232
+
+// requestEdns returns the OPT record of r, and an rcode other than
+// NOERROR when the record is malformed or of a version we don't know
+func requestEdns(r *dns.Msg) (*dns.OPT, int) {
+	var opt *dns.OPT
+	for _, rr := range r.Extra {
+		o, ok := rr.(*dns.OPT)
+		if !ok {
+			continue
+		}
+		// a FORMERR about the OPT record is sent without one
+		if opt != nil || o.Hdr.Name != "." {
+			return nil, dns.RcodeFormatError
+		}
+		opt = o
+	}
+	if opt != nil && opt.Version() != 0 {
+		return opt, dns.RcodeBadVers
+	}
+
+	return opt, dns.RcodeSuccess
+}
+
+// requestSubnet returns the subnet of the EDNS Client Subnet option of
+// opt, or an invalid prefix when there's none
+func requestSubnet(opt *dns.OPT) netip.Prefix {
+	if opt == nil {
+		return netip.Prefix{}
+	}
+	for _, o := range opt.Option {
+		ecs, ok := o.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ecs.Address)
+		if !ok {
+			return netip.Prefix{}
+		}
+		subnet, err := addr.Unmap().Prefix(int(ecs.SourceNetmask))
+		if err != nil {
+			return netip.Prefix{}
+		}
+		return subnet
+	}
+
+	return netip.Prefix{}
+}
+
+// replyEdns adds an OPT record to m when the request had one, drops the
+// DNSSEC records the client didn't ask for and truncates UDP replies
+// that are larger than the client can receive. Other options of the
+// request aren't echoed, they only apply to a single hop, but a client
+// subnet is, scoped to the whole subnet when it was sent upstream and to
+// no subnet when it was stripped or replaced, RFC 7871 7.2.2.
+func (d *DnsServer) replyEdns(w dns.ResponseWriter, opt *dns.OPT, requested netip.Prefix, subnet netip.Prefix, m *dns.Msg) {
+	size := dns.MinMsgSize
+	do := false
+	if opt != nil {
+		udpSize := d.db.GetConfig().EDNS.UDPSize
+		if udpSize == 0 {
+			udpSize = defaultUDPSize
+		}
+		do = opt.Do()
+		// the TSIG of a signed reply has to stay the last record
+		tsig := m.IsTsig()
+		if tsig != nil {
+			m.Extra = m.Extra[:len(m.Extra)-1]
+		}
+		m.SetEdns0(uint16(udpSize), do)
+		if requested.IsValid() {
+			ecs := &dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: uint8(requested.Bits()),
+				Address:       requested.Masked().Addr().AsSlice(),
+			}
+			if requested.Addr().Is6() {
+				ecs.Family = 2
+			}
+			if subnet == requested.Masked() {
+				ecs.SourceScope = ecs.SourceNetmask
+			}
+			reply := m.IsEdns0()
+			reply.Option = append(reply.Option, ecs)
+		}
+		if tsig != nil {
+			m.Extra = append(m.Extra, tsig)
+		}
+		size = min(int(opt.UDPSize()), udpSize)
+	}
+
+	if !do {
+		var qtype uint16
+		if len(m.Question) > 0 {
+			qtype = m.Question[0].Qtype
+		}
+		m.Answer = withoutDNSSEC(m.Answer, qtype)
+		m.Ns = withoutDNSSEC(m.Ns, 0)
+	}
+
+	// TCP replies can be as large as they need
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		m.Truncate(size)
+	}
+}
+
+// withoutDNSSEC drops the RRSIG, NSEC and NSEC3 records of rrs, unless
+// they are the type asked for, as RFC 4035 3.2.1 asks for clients that
+// don't set the DO bit
+func withoutDNSSEC(rrs []dns.RR, qtype uint16) []dns.RR {
+	kept := rrs[:0:0]
+	for _, rr := range rrs {
+		switch t := rr.Header().Rrtype; t {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			if t != qtype && qtype != dns.TypeANY {
+				continue
+			}
+		}
+		kept = append(kept, rr)
+	}
+
+	return kept
+}
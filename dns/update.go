@@ -0,0 +1,109 @@
+package dnsClient
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"time"
+
+	"dumbdns/database"
+	"dumbdns/logging"
+
+	"github.com/miekg/dns"
+)
+
+// tsigFudge is how far the clock of a client may be off, in seconds
+const tsigFudge = 300
+
+// handleUpdate applies an RFC 2136 dynamic update to a local zone. Only
+// updates signed with a TSIG key allowed to update the zone are applied.
+func (d *DnsServer) handleUpdate(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+	tsig := r.IsTsig()
+	if tsig == nil {
+		logging.Debugf("refusing unsigned update")
+		m.Rcode = dns.RcodeRefused
+		return
+	}
+	if err := w.TsigStatus(); err != nil {
+		// the reply can't be signed with a key we don't share
+		logging.Warnf("refusing update signed with key %s: %v", tsig.Hdr.Name, err)
+		m.Rcode = dns.RcodeNotAuth
+		return
+	}
+	defer m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsigFudge, time.Now().Unix())
+
+	// the zone section holds a single SOA question for the zone
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		m.Rcode = dns.RcodeFormatError
+		return
+	}
+	zone := r.Question[0].Name
+
+	rcode, err := d.db.UpdateZone(zone, tsig.Hdr.Name, r.Answer, r.Ns)
+	if err != nil {
+		logging.Errorf("error updating zone %s: %v", zone, err)
+	} else if rcode == dns.RcodeSuccess {
+		logging.Infof("Zone %s updated with key %s\n", database.CleanDomain(zone), database.CleanDomain(tsig.Hdr.Name))
+	}
+	m.Rcode = rcode
+}
+
+// tsigProvider signs and verifies messages with the TSIG keys of the
+// config, so keys can change without restarting the server
+type tsigProvider struct {
+	db *database.Database
+}
+
+func (p tsigProvider) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	key, ok := p.db.TSIGKey(t.Hdr.Name)
+	if !ok {
+		return nil, dns.ErrSecret
+	}
+	if !strings.EqualFold(dns.CanonicalName(t.Algorithm), key.Algorithm) {
+		return nil, dns.ErrKeyAlg
+	}
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var h hash.Hash
+	switch key.Algorithm {
+	case dns.HmacSHA1:
+		h = hmac.New(sha1.New, secret)
+	case dns.HmacSHA224:
+		h = hmac.New(sha256.New224, secret)
+	case dns.HmacSHA256:
+		h = hmac.New(sha256.New, secret)
+	case dns.HmacSHA384:
+		h = hmac.New(sha512.New384, secret)
+	case dns.HmacSHA512:
+		h = hmac.New(sha512.New, secret)
+	default:
+		return nil, dns.ErrKeyAlg
+	}
+	h.Write(msg)
+
+	return h.Sum(nil), nil
+}
+
+func (p tsigProvider) Verify(msg []byte, t *dns.TSIG) error {
+	expected, err := p.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, mac) {
+		return dns.ErrSig
+	}
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package dnssec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"dumbdns/logging"
+
+	"github.com/miekg/dns"
+)
+
+// rootAnchors are the DS records of the root key signing keys published
+// by IANA at https://data.iana.org/root-anchors/root-anchors.xml,
+// KSK-2017 and KSK-2024
+var rootAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// holdDown is how long a new key has to be seen before it's trusted, and
+// how long a revoked key is remembered, RFC 5011 2.4.1
+const holdDown = 30 * 24 * time.Hour
+
+// Anchor states, RFC 5011 4
+const (
+	// StateAddPend keys have been seen but aren't trusted until the
+	// hold down time has passed
+	StateAddPend = "addpend"
+	StateValid   = "valid"
+	// StateMissing keys are still trusted but have left the key set
+	StateMissing = "missing"
+	// StateRevoked keys were revoked by their owner and are never
+	// trusted again
+	StateRevoked = "revoked"
+)
+
+// Anchor is a trust anchor for the root zone
+type Anchor struct {
+	// DNSKEY is the key, once it has been seen in the root key set
+	DNSKEY string `json:"dnskey,omitempty"`
+	// DS is set for the built in anchors, which are only known by their
+	// digest until the key is first seen
+	DS    string `json:"ds,omitempty"`
+	State string `json:"state"`
+	// Changed is when the anchor entered its state
+	Changed time.Time `json:"changed"`
+}
+
+// Anchors are the root trust anchors, kept up to date with the root key
+// set as RFC 5011 describes. They are saved to path on every change so
+// a rollover that happened while the server ran isn't forgotten.
+type Anchors struct {
+	path    string
+	mux     *sync.Mutex
+	anchors []Anchor
+}
+
+// LoadAnchors reads the trust anchors saved to path. The built in root
+// anchors are used when path is empty or doesn't exist yet.
+func LoadAnchors(path string) (*Anchors, error) {
+	a := &Anchors{path: path, mux: &sync.Mutex{}}
+	for _, ds := range rootAnchors {
+		a.anchors = append(a.anchors, Anchor{DS: ds, State: StateValid})
+	}
+	if path == "" {
+		return a, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading trust anchors: %w", err)
+	}
+	var anchors []Anchor
+	err = json.Unmarshal(data, &anchors)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding trust anchors %s: %w", path, err)
+	}
+	for _, anchor := range anchors {
+		if _, err := anchor.key(); err != nil {
+			return nil, fmt.Errorf("invalid trust anchor in %s: %w", path, err)
+		}
+	}
+	a.anchors = anchors
+
+	return a, nil
+}
+
+// NewAnchors returns anchors trusting the given DS or DNSKEY records of
+// the root zone, which aren't saved anywhere
+func NewAnchors(records ...string) (*Anchors, error) {
+	a := &Anchors{mux: &sync.Mutex{}}
+	for _, record := range records {
+		anchor := Anchor{State: StateValid}
+		if strings.Contains(record, "DNSKEY") {
+			anchor.DNSKEY = record
+		} else {
+			anchor.DS = record
+		}
+		if _, err := anchor.key(); err != nil {
+			return nil, err
+		}
+		a.anchors = append(a.anchors, anchor)
+	}
+
+	return a, nil
+}
+
+// Anchors returns a copy of the current anchors
+func (a *Anchors) Anchors() []Anchor {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return append([]Anchor{}, a.anchors...)
+}
+
+// key parses the record of the anchor, a *dns.DNSKEY or a *dns.DS
+func (anchor Anchor) key() (dns.RR, error) {
+	record := anchor.DNSKEY
+	if record == "" {
+		record = anchor.DS
+	}
+	rr, err := dns.NewRR(record)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trust anchor %q: %w", record, err)
+	}
+	switch rr.(type) {
+	case *dns.DNSKEY, *dns.DS:
+	default:
+		return nil, fmt.Errorf("invalid trust anchor %q: not a DNSKEY or DS record", record)
+	}
+	if rr.Header().Name != "." {
+		return nil, fmt.Errorf("invalid trust anchor %q: not for the root zone", record)
+	}
+
+	return rr, nil
+}
+
+// matches reports whether key is the key of the anchor. The revoke flag
+// is left out, as it changes the key tag but not the key.
+func (anchor Anchor) matches(key *dns.DNSKEY) bool {
+	rr, err := anchor.key()
+	if err != nil {
+		return false
+	}
+	switch anchorKey := rr.(type) {
+	case *dns.DNSKEY:
+		return anchorKey.Algorithm == key.Algorithm && anchorKey.PublicKey == key.PublicKey
+	case *dns.DS:
+		unrevoked := *key
+		unrevoked.Flags &^= dns.REVOKE
+		ds := unrevoked.ToDS(anchorKey.DigestType)
+		return ds != nil && strings.EqualFold(ds.Digest, anchorKey.Digest)
+	}
+
+	return false
+}
+
+// trusted returns the keys of set that match a valid or missing anchor
+func (a *Anchors) trusted(set []*dns.DNSKEY) []*dns.DNSKEY {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	keys := []*dns.DNSKEY{}
+	for _, key := range set {
+		if key.Flags&dns.REVOKE != 0 {
+			continue
+		}
+		for _, anchor := range a.anchors {
+			if (anchor.State == StateValid || anchor.State == StateMissing) && anchor.matches(key) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+
+	return keys
+}
+
+// update moves the anchors through the states of RFC 5011 once the root
+// key set has been validated: new keys are added pending, pending keys
+// are trusted after the hold down time, keys that sign their own
+// revocation are revoked, and keys that leave the set are missing
+func (a *Anchors) update(set []*dns.DNSKEY, sigs []*dns.RRSIG, now time.Time) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	rrs := make([]dns.RR, len(set))
+	for i, key := range set {
+		rrs[i] = key
+	}
+	changed := false
+	seen := make([]bool, len(a.anchors))
+	for _, key := range set {
+		// only key signing keys are trust anchors
+		if key.Flags&dns.SEP == 0 {
+			continue
+		}
+		i := -1
+		for j, anchor := range a.anchors {
+			if anchor.matches(key) {
+				i = j
+				break
+			}
+		}
+
+		if key.Flags&dns.REVOKE != 0 {
+			// the key is still published, whether or not the revocation
+			// counts, so it isn't missing
+			if i != -1 {
+				seen[i] = true
+			}
+			// the revocation only counts when the key signed it
+			if i == -1 || a.anchors[i].State == StateRevoked || !selfSigned(key, rrs, sigs, now) {
+				continue
+			}
+			a.anchors[i] = Anchor{DNSKEY: key.String(), State: StateRevoked, Changed: now}
+			logging.Warnf("Root trust anchor %d was revoked", key.KeyTag())
+			changed = true
+			continue
+		}
+
+		if i == -1 {
+			a.anchors = append(a.anchors, Anchor{DNSKEY: key.String(), State: StateAddPend, Changed: now})
+			seen = append(seen, true)
+			logging.Infof("New root key %d, trusted once it has been published for %s", key.KeyTag(), holdDown)
+			changed = true
+			continue
+		}
+		seen[i] = true
+		anchor := &a.anchors[i]
+		if anchor.DNSKEY == "" {
+			// a built in anchor, now known by its key
+			anchor.DNSKEY = key.String()
+			changed = true
+		}
+		switch {
+		case anchor.State == StateAddPend && now.Sub(anchor.Changed) >= holdDown,
+			anchor.State == StateMissing:
+			anchor.State = StateValid
+			anchor.Changed = now
+			logging.Infof("Root trust anchor %d is valid", key.KeyTag())
+			changed = true
+		}
+	}
+
+	kept := a.anchors[:0]
+	for i, anchor := range a.anchors {
+		switch {
+		case anchor.State == StateRevoked:
+			if now.Sub(anchor.Changed) >= holdDown {
+				changed = true
+				continue
+			}
+		case seen[i]:
+		case anchor.State == StateAddPend:
+			// pending keys have to stay published for the whole hold down
+			changed = true
+			continue
+		case anchor.State == StateValid:
+			anchor.State = StateMissing
+			anchor.Changed = now
+			changed = true
+		}
+		kept = append(kept, anchor)
+	}
+	a.anchors = kept
+
+	if changed && a.path != "" {
+		err := a.save()
+		if err != nil {
+			logging.Errorf("error saving trust anchors: %v", err)
+		}
+	}
+}
+
+// selfSigned reports whether key signed the key set rrs
+func selfSigned(key *dns.DNSKEY, rrs []dns.RR, sigs []*dns.RRSIG, now time.Time) bool {
+	for _, sig := range sigs {
+		if sig.KeyTag == key.KeyTag() && sig.Algorithm == key.Algorithm &&
+			sig.ValidityPeriod(now) && sig.Verify(key, rrs) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// save writes the anchors to a temporary file first, so a crash never
+// leaves half of them behind
+func (a *Anchors) save() error {
+	data, err := json.MarshalIndent(a.anchors, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), a.path)
+}
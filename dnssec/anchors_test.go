@@ -0,0 +1,144 @@
+package dnssec
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// update gives a the key set keys signed by each of signers, as if it
+// had been validated at now
+func update(t *testing.T, a *Anchors, now time.Time, keys []*dns.DNSKEY, signers ...testKey) {
+	rrs := make([]dns.RR, len(keys))
+	for i, key := range keys {
+		rrs[i] = key
+	}
+	sigs := []*dns.RRSIG{}
+	for _, signer := range signers {
+		signed := signer.signAt(t, now, rrs...)
+		sigs = append(sigs, signed[len(signed)-1].(*dns.RRSIG))
+	}
+	a.update(keys, sigs, now)
+}
+
+func states(a *Anchors) map[uint16]string {
+	states := map[uint16]string{}
+	for _, anchor := range a.Anchors() {
+		key, err := anchor.key()
+		if err != nil {
+			continue
+		}
+		if k, ok := key.(*dns.DNSKEY); ok {
+			unrevoked := *k
+			unrevoked.Flags &^= dns.REVOKE
+			states[unrevoked.KeyTag()] = anchor.State
+		}
+	}
+
+	return states
+}
+
+func Test_anchorsRollover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "anchors.json")
+	old := newKey(t, ".")
+	next := newKey(t, ".")
+	start := time.Now()
+	day := 24 * time.Hour
+
+	a, err := LoadAnchors(path)
+	require.NoError(t, err)
+	// the built in anchors are swapped for a test key
+	a.anchors = []Anchor{{DS: old.key.ToDS(dns.SHA256).String(), State: StateValid}}
+
+	// the built in anchor is learnt by its key
+	update(t, a, start, []*dns.DNSKEY{old.key}, old)
+	assert.Equal(t, map[uint16]string{old.key.KeyTag(): StateValid}, states(a))
+
+	// a new key is pending for the hold down time
+	update(t, a, start, []*dns.DNSKEY{old.key, next.key}, old)
+	assert.Equal(t, map[uint16]string{old.key.KeyTag(): StateValid, next.key.KeyTag(): StateAddPend}, states(a))
+	assert.Empty(t, a.trusted([]*dns.DNSKEY{next.key}))
+
+	update(t, a, start.Add(29*day), []*dns.DNSKEY{old.key, next.key}, old)
+	assert.Equal(t, StateAddPend, states(a)[next.key.KeyTag()])
+	update(t, a, start.Add(31*day), []*dns.DNSKEY{old.key, next.key}, old)
+	assert.Equal(t, StateValid, states(a)[next.key.KeyTag()])
+	assert.Equal(t, []*dns.DNSKEY{next.key}, a.trusted([]*dns.DNSKEY{next.key}))
+
+	// the old key signs its own revocation
+	revoked := *old.key
+	revoked.Flags |= dns.REVOKE
+	revoker := testKey{key: &revoked, signer: old.signer}
+	update(t, a, start.Add(32*day), []*dns.DNSKEY{&revoked, next.key}, revoker, next)
+	assert.Equal(t, map[uint16]string{old.key.KeyTag(): StateRevoked, next.key.KeyTag(): StateValid}, states(a))
+	assert.Empty(t, a.trusted([]*dns.DNSKEY{old.key}))
+
+	// the rollover is kept across restarts
+	loaded, err := LoadAnchors(path)
+	require.NoError(t, err)
+	assert.Equal(t, states(a), states(loaded))
+
+	// revoked keys are forgotten after the hold down time, and trusted
+	// keys that leave the set are still trusted while missing
+	other := newKey(t, ".")
+	update(t, a, start.Add(63*day), []*dns.DNSKEY{other.key}, next)
+	assert.Equal(t, map[uint16]string{next.key.KeyTag(): StateMissing, other.key.KeyTag(): StateAddPend}, states(a))
+	assert.Equal(t, []*dns.DNSKEY{next.key}, a.trusted([]*dns.DNSKEY{next.key}))
+}
+
+func Test_anchorsPendingKeyRemoved(t *testing.T) {
+	trusted := newKey(t, ".")
+	pending := newKey(t, ".")
+	a, err := NewAnchors(trusted.key.String())
+	require.NoError(t, err)
+	start := time.Now()
+
+	update(t, a, start, []*dns.DNSKEY{trusted.key, pending.key}, trusted)
+	assert.Equal(t, StateAddPend, states(a)[pending.key.KeyTag()])
+
+	// a pending key has to be published for the whole hold down time
+	update(t, a, start, []*dns.DNSKEY{trusted.key}, trusted)
+	assert.Equal(t, map[uint16]string{trusted.key.KeyTag(): StateValid}, states(a))
+}
+
+func Test_revocationNotSelfSigned(t *testing.T) {
+	trusted := newKey(t, ".")
+	other := newKey(t, ".")
+	revoked := *trusted.key
+	revoked.Flags |= dns.REVOKE
+
+	tests := []struct {
+		name    string
+		signers []testKey
+	}{
+		{name: "Signed by another key", signers: []testKey{other}},
+		{name: "Unsigned"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAnchors(trusted.key.String())
+			require.NoError(t, err)
+
+			// only the key itself can revoke it, and it's still published
+			update(t, a, time.Now(), []*dns.DNSKEY{&revoked, other.key}, tt.signers...)
+			assert.Equal(t, StateValid, states(a)[trusted.key.KeyTag()])
+			assert.Equal(t, []*dns.DNSKEY{trusted.key}, a.trusted([]*dns.DNSKEY{trusted.key}))
+		})
+	}
+}
+
+func Test_rootAnchors(t *testing.T) {
+	a, err := LoadAnchors("")
+	require.NoError(t, err)
+	require.Len(t, a.Anchors(), 2)
+	for _, anchor := range a.Anchors() {
+		key, err := anchor.key()
+		require.NoError(t, err)
+		assert.IsType(t, &dns.DS{}, key)
+		assert.Equal(t, StateValid, anchor.State)
+	}
+}
@@ -0,0 +1,906 @@
+// Package dnssec validates answers from upstream resolvers with the
+// chain of trust from the root zone, RFC 4033 to 4035
+package dnssec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Status is the security status of an answer, RFC 4035 4.3
+type Status int
+
+const (
+	// Insecure answers come from zones proven not to be signed
+	Insecure Status = iota
+	// Secure answers are signed by keys chained to a trust anchor
+	Secure
+	// Bogus answers should be signed but aren't, or not properly
+	Bogus
+)
+
+func (s Status) String() string {
+	switch s {
+	case Secure:
+		return "secure"
+	case Bogus:
+		return "bogus"
+	default:
+		return "insecure"
+	}
+}
+
+// Exchanger sends m to an upstream resolver and returns its reply
+type Exchanger func(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
+
+// maxKeysTTL limits how long the keys of a zone are cached, so the root
+// key set is checked for new and revoked keys often enough
+const maxKeysTTL = time.Hour
+
+// maxZones limits how many zones have their keys cached
+const maxZones = 10000
+
+// maxIterations is the most NSEC3 hash iterations worked through, zones
+// that use more are treated as insecure, RFC 9276 3.2
+const maxIterations = 150
+
+// Validator validates answers, looking up the DS and DNSKEY records of
+// the zones in the chain of trust with the same upstream
+type Validator struct {
+	exchange Exchanger
+	anchors  *Anchors
+	mux      *sync.Mutex
+	// zones caches the keys of each zone, and of names found not to be
+	// zone cuts
+	zones map[string]zoneKeys
+}
+
+// zoneKeys are the validated keys of a zone, or no keys for an insecure
+// zone
+type zoneKeys struct {
+	status    Status
+	keys      []*dns.DNSKEY
+	expiresAt time.Time
+}
+
+// rrset is the records of a name and type with their signatures
+type rrset struct {
+	rrs  []dns.RR
+	sigs []*dns.RRSIG
+}
+
+func (s *rrset) name() string {
+	return s.rrs[0].Header().Name
+}
+
+func (s *rrset) rrtype() uint16 {
+	return s.rrs[0].Header().Rrtype
+}
+
+func (s *rrset) ttl() time.Duration {
+	ttl := s.rrs[0].Header().Ttl
+	for _, rr := range s.rrs {
+		ttl = min(ttl, rr.Header().Ttl)
+	}
+
+	return time.Duration(ttl) * time.Second
+}
+
+// New returns a validator trusting anchors
+func New(exchange Exchanger, anchors *Anchors) *Validator {
+	return &Validator{
+		exchange: exchange,
+		anchors:  anchors,
+		mux:      &sync.Mutex{},
+		zones:    map[string]zoneKeys{},
+	}
+}
+
+// Validate returns the security status of resp, the reply to a query
+// sent with the DO and CD bits set, and the reason an answer is bogus
+func (v *Validator) Validate(ctx context.Context, resp *dns.Msg) (Status, error) {
+	if len(resp.Question) != 1 {
+		return Bogus, errors.New("reply without a question")
+	}
+	q := resp.Question[0]
+	now := time.Now()
+
+	status := Secure
+	answers := rrsets(resp.Answer)
+	for _, set := range answers {
+		if set.rrtype() == dns.TypeCNAME && len(set.sigs) == 0 && synthesized(set, answers) {
+			// CNAMEs synthesized from a DNAME aren't signed
+			continue
+		}
+		s, err := v.verify(ctx, set, now)
+		if err != nil {
+			return Bogus, err
+		}
+		status = min(status, s)
+	}
+
+	// the name the answer is for, at the end of any CNAME chain
+	name := q.Name
+	for range answers {
+		if q.Qtype == dns.TypeCNAME {
+			break
+		}
+		for _, set := range answers {
+			if set.rrtype() == dns.TypeCNAME && strings.EqualFold(set.name(), name) {
+				name = set.rrs[0].(*dns.CNAME).Target
+			}
+		}
+	}
+	found := false
+	// nextCloser is the name one label below the wildcard an answer was
+	// expanded from, which mustn't exist, RFC 5155 8.8
+	nextCloser := ""
+	for _, set := range answers {
+		if strings.EqualFold(set.name(), name) && (set.rrtype() == q.Qtype || q.Qtype == dns.TypeANY) {
+			found = true
+		}
+		for _, sig := range set.sigs {
+			if labels := dns.SplitDomainName(set.name()); int(sig.Labels) < len(labels) {
+				nextCloser = dns.Fqdn(strings.Join(labels[len(labels)-int(sig.Labels)-1:], "."))
+			}
+		}
+	}
+	if found && nextCloser == "" || status == Insecure {
+		return status, nil
+	}
+	if found {
+		name = nextCloser
+	}
+
+	// negative answers and wildcard expansions are proven by the
+	// NSEC or NSEC3 records of the authority section
+	s, err := v.verifyDenial(ctx, resp, name, found, len(answers) > 0, now)
+	if err != nil {
+		return Bogus, err
+	}
+
+	return min(status, s), nil
+}
+
+// synthesized reports whether the unsigned CNAME set comes from a DNAME
+// of answers
+func synthesized(set *rrset, answers []*rrset) bool {
+	for _, other := range answers {
+		if other.rrtype() == dns.TypeDNAME && dns.IsSubDomain(other.name(), set.name()) && other.name() != set.name() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rrsets groups rrs by name and type, with the signatures of each
+func rrsets(rrs []dns.RR) []*rrset {
+	sets := []*rrset{}
+	index := map[string]*rrset{}
+	key := func(name string, rrtype uint16) string {
+		return strings.ToLower(name) + "/" + dns.TypeToString[rrtype]
+	}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeRRSIG || rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		k := key(rr.Header().Name, rr.Header().Rrtype)
+		if set, ok := index[k]; ok {
+			set.rrs = append(set.rrs, rr)
+			continue
+		}
+		set := &rrset{rrs: []dns.RR{rr}}
+		index[k] = set
+		sets = append(sets, set)
+	}
+	for _, rr := range rrs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		if set, ok := index[key(sig.Hdr.Name, sig.TypeCovered)]; ok {
+			set.sigs = append(set.sigs, sig)
+		}
+	}
+
+	return sets
+}
+
+// verify checks the signatures of set with the keys of the zone that
+// signed it. An unsigned set is only insecure in an unsigned zone.
+func (v *Validator) verify(ctx context.Context, set *rrset, now time.Time) (Status, error) {
+	if len(set.sigs) == 0 {
+		zone, err := v.keys(ctx, set.name(), now)
+		if err != nil {
+			return Bogus, err
+		}
+		if zone.status == Insecure {
+			return Insecure, nil
+		}
+		return Bogus, fmt.Errorf("%s %s isn't signed", set.name(), dns.TypeToString[set.rrtype()])
+	}
+
+	var errs []error
+	for _, sig := range set.sigs {
+		if !dns.IsSubDomain(sig.SignerName, set.name()) {
+			errs = append(errs, fmt.Errorf("%s isn't signed by a parent zone", set.name()))
+			continue
+		}
+		zone, err := v.keys(ctx, sig.SignerName, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if zone.status == Insecure {
+			return Insecure, nil
+		}
+		if err := verifySig(sig, zone.keys, set.rrs, now); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return Secure, nil
+	}
+
+	return Bogus, errors.Join(errs...)
+}
+
+// verifySigned checks set is signed by zone with one of keys
+func verifySigned(set *rrset, zone string, keys []*dns.DNSKEY, now time.Time) error {
+	var errs []error
+	for _, sig := range set.sigs {
+		if !strings.EqualFold(sig.SignerName, zone) {
+			continue
+		}
+		if err := verifySig(sig, keys, set.rrs, now); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return nil
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("%s %s isn't signed by %s", set.name(), dns.TypeToString[set.rrtype()], zone)
+	}
+
+	return errors.Join(errs...)
+}
+
+// parentName returns name without its first label
+func parentName(name string) string {
+	labels := dns.SplitDomainName(name)
+	if len(labels) <= 1 {
+		return "."
+	}
+
+	return dns.Fqdn(strings.Join(labels[1:], "."))
+}
+
+// verifySig checks sig over rrs with the key of keys it names
+func verifySig(sig *dns.RRSIG, keys []*dns.DNSKEY, rrs []dns.RR, now time.Time) error {
+	if !sig.ValidityPeriod(now) {
+		return fmt.Errorf("signature of %s %s has expired or isn't valid yet", sig.Hdr.Name, dns.TypeToString[sig.TypeCovered])
+	}
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm || key.Flags&dns.ZONE == 0 || key.Flags&dns.REVOKE != 0 {
+			continue
+		}
+		if sig.Verify(key, rrs) == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signature of %s %s doesn't match key %d of %s", sig.Hdr.Name, dns.TypeToString[sig.TypeCovered], sig.KeyTag, sig.SignerName)
+}
+
+// supported reports whether signatures of algorithm can be verified,
+// zones only signed with other algorithms are insecure, RFC 4035 5.2
+func supported(algorithm uint8) bool {
+	switch algorithm {
+	case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512,
+		dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dns.ED25519:
+		return true
+	}
+
+	return false
+}
+
+// keys returns the validated keys of the zone name is in, following the
+// chain of trust down from the root
+func (v *Validator) keys(ctx context.Context, name string, now time.Time) (zoneKeys, error) {
+	name = dns.CanonicalName(name)
+	v.mux.Lock()
+	zone, ok := v.zones[name]
+	v.mux.Unlock()
+	if ok && now.Before(zone.expiresAt) {
+		return zone, nil
+	}
+
+	if name == "." {
+		zone, err := v.rootKeys(ctx, now)
+		if err != nil {
+			return zoneKeys{}, err
+		}
+		v.store(name, zone)
+		return zone, nil
+	}
+
+	resp, err := v.query(ctx, name, dns.TypeDS)
+	if err != nil {
+		return zoneKeys{}, err
+	}
+	for _, set := range rrsets(resp.Answer) {
+		if set.rrtype() == dns.TypeDS && strings.EqualFold(set.name(), name) {
+			zone, err = v.delegation(ctx, name, set, now)
+			if err != nil {
+				return zoneKeys{}, err
+			}
+			v.store(name, zone)
+			return zone, nil
+		}
+	}
+
+	// without a DS, name is either an insecure delegation or a name in
+	// the zone that answered
+	zone, err = v.noDS(ctx, name, resp, now)
+	if err != nil {
+		return zoneKeys{}, err
+	}
+	v.store(name, zone)
+
+	return zone, nil
+}
+
+// rootKeys returns the root key set, signed by a trust anchor
+func (v *Validator) rootKeys(ctx context.Context, now time.Time) (zoneKeys, error) {
+	resp, err := v.query(ctx, ".", dns.TypeDNSKEY)
+	if err != nil {
+		return zoneKeys{}, err
+	}
+	set, keys := keySet(resp, ".")
+	if set == nil {
+		return zoneKeys{}, errors.New("no root DNSKEY records")
+	}
+
+	var errs []error
+	for _, sig := range set.sigs {
+		if err := verifySig(sig, v.anchors.trusted(keys), set.rrs, now); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		v.anchors.update(keys, set.sigs, now)
+		return zoneKeys{status: Secure, keys: keys, expiresAt: now.Add(min(set.ttl(), maxKeysTTL))}, nil
+	}
+
+	return zoneKeys{}, fmt.Errorf("root DNSKEY records aren't signed by a trust anchor: %w", errors.Join(errs...))
+}
+
+// delegation validates ds, the DS set of zone, with the keys of the
+// parent zone and returns the keys of zone it vouches for
+func (v *Validator) delegation(ctx context.Context, zone string, ds *rrset, now time.Time) (zoneKeys, error) {
+	parent := ""
+	for _, sig := range ds.sigs {
+		if dns.IsSubDomain(sig.SignerName, zone) && !strings.EqualFold(sig.SignerName, zone) {
+			parent = sig.SignerName
+			break
+		}
+	}
+	if parent == "" {
+		// only an insecure parent hands out unsigned DS records
+		p, err := v.keys(ctx, parentName(zone), now)
+		if err != nil || p.status == Insecure {
+			return p, err
+		}
+		return zoneKeys{}, fmt.Errorf("DS records of %s aren't signed", zone)
+	}
+	p, err := v.keys(ctx, parent, now)
+	if err != nil || p.status == Insecure {
+		return p, err
+	}
+	if err := verifySigned(ds, parent, p.keys, now); err != nil {
+		return zoneKeys{}, err
+	}
+	expiresAt := now.Add(min(ds.ttl(), maxKeysTTL))
+	usable := false
+	for _, rr := range ds.rrs {
+		usable = usable || supported(rr.(*dns.DS).Algorithm)
+	}
+	if !usable {
+		return zoneKeys{status: Insecure, expiresAt: expiresAt}, nil
+	}
+
+	resp, err := v.query(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return zoneKeys{}, err
+	}
+	set, keys := keySet(resp, zone)
+	if set == nil {
+		return zoneKeys{}, fmt.Errorf("no DNSKEY records for %s", zone)
+	}
+	// the key set has to be signed by a key the parent has a DS for
+	signers := []*dns.DNSKEY{}
+	for _, key := range keys {
+		for _, rr := range ds.rrs {
+			d := rr.(*dns.DS)
+			digest := key.ToDS(d.DigestType)
+			if digest != nil && digest.KeyTag == d.KeyTag && strings.EqualFold(digest.Digest, d.Digest) {
+				signers = append(signers, key)
+				break
+			}
+		}
+	}
+	var errs []error
+	for _, sig := range set.sigs {
+		if err := verifySig(sig, signers, set.rrs, now); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ttl := min(set.ttl(), ds.ttl(), maxKeysTTL)
+		return zoneKeys{status: Secure, keys: keys, expiresAt: now.Add(ttl)}, nil
+	}
+
+	return zoneKeys{}, fmt.Errorf("DNSKEY records of %s don't match its DS records: %w", zone, errors.Join(errs...))
+}
+
+// noDS works out where name is from the reply to a DS query without
+// any: an insecure delegation when the parent proves there's no DS, or
+// a name in the zone that answered, which has the same keys
+func (v *Validator) noDS(ctx context.Context, name string, resp *dns.Msg, now time.Time) (zoneKeys, error) {
+	// the zone that answered signs the answer or names itself in the SOA
+	// of the authority section
+	zone := ""
+	for _, rr := range resp.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok && strings.EqualFold(sig.Hdr.Name, name) {
+			zone = sig.SignerName
+		}
+	}
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok && zone == "" {
+			zone = soa.Hdr.Name
+		}
+	}
+	zone = dns.CanonicalName(zone)
+	if zone == "" || zone == name || !dns.IsSubDomain(zone, name) {
+		return zoneKeys{}, fmt.Errorf("can't tell which zone %s is in", name)
+	}
+
+	parent, err := v.keys(ctx, zone, now)
+	if err != nil || parent.status == Insecure {
+		return parent, err
+	}
+	if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0 {
+		// an answer other than a DS, e.g: a CNAME, which can't be at a
+		// zone cut
+		return parent, nil
+	}
+
+	// the missing DS has to be proven, by records signed by the zone
+	proof := []*rrset{}
+	for _, set := range rrsets(resp.Ns) {
+		if set.rrtype() != dns.TypeNSEC && set.rrtype() != dns.TypeNSEC3 {
+			continue
+		}
+		if err := verifySigned(set, zone, parent.keys, now); err != nil {
+			return zoneKeys{}, err
+		}
+		proof = append(proof, set)
+	}
+
+	cut, optOut, err := delegationProof(name, proof)
+	if err != nil {
+		return zoneKeys{}, err
+	}
+	if cut || optOut {
+		return zoneKeys{status: Insecure, expiresAt: parent.expiresAt}, nil
+	}
+
+	return parent, nil
+}
+
+// delegationProof checks the NSEC or NSEC3 records of proof deny a DS
+// at name. cut is set when name is a delegation without DS, optOut when
+// an NSEC3 opt out span covers it, and neither when name is in the zone.
+func delegationProof(name string, proof []*rrset) (cut bool, optOut bool, err error) {
+	for _, set := range proof {
+		switch rr := set.rrs[0].(type) {
+		case *dns.NSEC:
+			if strings.EqualFold(rr.Hdr.Name, name) {
+				if hasType(rr.TypeBitMap, dns.TypeDS) {
+					return false, false, fmt.Errorf("NSEC of %s says it has a DS", name)
+				}
+				return hasType(rr.TypeBitMap, dns.TypeNS) && !hasType(rr.TypeBitMap, dns.TypeSOA), false, nil
+			}
+			if covers(rr, name) {
+				return false, false, nil
+			}
+		case *dns.NSEC3:
+			if rr.Iterations > maxIterations {
+				return false, true, nil
+			}
+			if rr.Match(name) {
+				if hasType(rr.TypeBitMap, dns.TypeDS) {
+					return false, false, fmt.Errorf("NSEC3 of %s says it has a DS", name)
+				}
+				return hasType(rr.TypeBitMap, dns.TypeNS) && !hasType(rr.TypeBitMap, dns.TypeSOA), false, nil
+			}
+		}
+	}
+
+	// names without a matching NSEC3 are proven with the closest
+	// encloser, RFC 5155 8.6
+	nsec3 := nsec3s(proof)
+	if len(nsec3) > 0 {
+		_, nextCloser, ok := closestEncloser(name, nsec3)
+		if !ok {
+			return false, false, fmt.Errorf("no closest encloser proof for %s", name)
+		}
+		for _, rr := range nsec3 {
+			if covers3(rr, nextCloser) {
+				return false, rr.Flags&1 == 1, nil
+			}
+		}
+	}
+
+	return false, false, fmt.Errorf("no proof %s has no DS", name)
+}
+
+// verifyDenial checks the authority section of resp proves name doesn't
+// exist, or has no records of the asked type. For a wildcard answer,
+// found is set and name is the next closer name, which has to be
+// proven missing. signed is set when the answer section was secure.
+func (v *Validator) verifyDenial(ctx context.Context, resp *dns.Msg, name string, found bool, signed bool, now time.Time) (Status, error) {
+	qtype := resp.Question[0].Qtype
+	proof := []*rrset{}
+	status := Secure
+	for _, set := range rrsets(resp.Ns) {
+		switch set.rrtype() {
+		case dns.TypeSOA, dns.TypeNSEC, dns.TypeNSEC3:
+		default:
+			continue
+		}
+		s, err := v.verify(ctx, set, now)
+		if err != nil {
+			return Bogus, err
+		}
+		status = min(status, s)
+		signed = signed || s == Secure
+		if set.rrtype() != dns.TypeSOA {
+			proof = append(proof, set)
+		}
+	}
+	if status == Insecure {
+		return Insecure, nil
+	}
+	if len(proof) == 0 && !signed {
+		// an unsigned zone doesn't prove anything
+		zone, err := v.keys(ctx, name, now)
+		if err != nil {
+			return Bogus, err
+		}
+		if zone.status == Insecure {
+			return Insecure, nil
+		}
+	}
+	if len(proof) == 0 {
+		return Bogus, fmt.Errorf("no proof %s %s doesn't exist", name, dns.TypeToString[qtype])
+	}
+
+	nsec3 := nsec3s(proof)
+	for _, rr := range nsec3 {
+		if rr.Iterations > maxIterations {
+			return Insecure, nil
+		}
+	}
+	var err error
+	switch {
+	case len(nsec3) > 0:
+		err = nsec3Denial(name, qtype, resp.Rcode, found, nsec3)
+	default:
+		err = nsecDenial(name, qtype, resp.Rcode, found, proof)
+	}
+	if err != nil {
+		return Bogus, err
+	}
+
+	return Secure, nil
+}
+
+// nsecDenial proves name or its records of qtype don't exist with NSEC
+// records, RFC 4035 5.4
+func nsecDenial(name string, qtype uint16, rcode int, found bool, proof []*rrset) error {
+	var matching *dns.NSEC
+	var covering []*dns.NSEC
+	for _, set := range proof {
+		for _, rr := range set.rrs {
+			nsec, ok := rr.(*dns.NSEC)
+			if !ok {
+				continue
+			}
+			if strings.EqualFold(nsec.Hdr.Name, name) {
+				matching = nsec
+			} else if covers(nsec, name) {
+				covering = append(covering, nsec)
+			}
+		}
+	}
+
+	if rcode == dns.RcodeSuccess && !found && matching != nil {
+		if hasType(matching.TypeBitMap, qtype) || hasType(matching.TypeBitMap, dns.TypeCNAME) {
+			return fmt.Errorf("NSEC of %s says it has %s records", name, dns.TypeToString[qtype])
+		}
+		return nil
+	}
+	if len(covering) == 0 {
+		return fmt.Errorf("no NSEC proves %s doesn't exist", name)
+	}
+	if found {
+		// a wildcard answer only needs the name to be missing
+		return nil
+	}
+	nsec := covering[0]
+	if rcode == dns.RcodeSuccess && dns.IsSubDomain(name, nsec.NextDomain) {
+		// an empty non-terminal, which has no records of any type
+		return nil
+	}
+
+	// the wildcard at the closest encloser has to be missing too, or
+	// lack records of qtype
+	encloser := commonAncestor(name, nsec.Hdr.Name)
+	if other := commonAncestor(name, nsec.NextDomain); dns.CountLabel(other) > dns.CountLabel(encloser) {
+		encloser = other
+	}
+	wildcard := "*." + encloser
+	if encloser == "." {
+		wildcard = "*."
+	}
+	for _, set := range proof {
+		for _, rr := range set.rrs {
+			w, ok := rr.(*dns.NSEC)
+			if !ok {
+				continue
+			}
+			if rcode == dns.RcodeNameError && covers(w, wildcard) {
+				return nil
+			}
+			if rcode == dns.RcodeSuccess && strings.EqualFold(w.Hdr.Name, wildcard) &&
+				!hasType(w.TypeBitMap, qtype) && !hasType(w.TypeBitMap, dns.TypeCNAME) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no NSEC proves %s doesn't exist", wildcard)
+}
+
+// nsec3Denial proves name or its records of qtype don't exist with NSEC3
+// records, RFC 5155 8
+func nsec3Denial(name string, qtype uint16, rcode int, found bool, nsec3 []*dns.NSEC3) error {
+	if rcode == dns.RcodeSuccess && !found {
+		for _, rr := range nsec3 {
+			if rr.Match(name) {
+				if hasType(rr.TypeBitMap, qtype) || hasType(rr.TypeBitMap, dns.TypeCNAME) {
+					return fmt.Errorf("NSEC3 of %s says it has %s records", name, dns.TypeToString[qtype])
+				}
+				return nil
+			}
+		}
+	}
+
+	if found {
+		// a wildcard answer only needs the next closer name to be
+		// missing, its encloser is given by the labels of the signature
+		for _, rr := range nsec3 {
+			if covers3(rr, name) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no NSEC3 proves %s doesn't exist", name)
+	}
+	encloser, nextCloser, ok := closestEncloser(name, nsec3)
+	if !ok {
+		return fmt.Errorf("no closest encloser proof for %s", name)
+	}
+
+	wildcard := "*." + encloser
+	if encloser == "." {
+		wildcard = "*."
+	}
+	for _, rr := range nsec3 {
+		if !covers3(rr, nextCloser) {
+			continue
+		}
+		// an opt out span may hide an unsigned delegation
+		if rcode == dns.RcodeSuccess && qtype == dns.TypeDS && rr.Flags&1 == 1 {
+			return nil
+		}
+		for _, w := range nsec3 {
+			if rcode == dns.RcodeNameError && covers3(w, wildcard) {
+				return nil
+			}
+			if rcode == dns.RcodeSuccess && w.Match(wildcard) &&
+				!hasType(w.TypeBitMap, qtype) && !hasType(w.TypeBitMap, dns.TypeCNAME) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no NSEC3 proves %s doesn't exist", name)
+}
+
+// closestEncloser returns the closest ancestor of name with an NSEC3 and
+// the name one label below it towards name, RFC 5155 8.3
+func closestEncloser(name string, nsec3 []*dns.NSEC3) (string, string, bool) {
+	labels := dns.SplitDomainName(name)
+	for i := 1; i <= len(labels); i++ {
+		encloser := dns.Fqdn(strings.Join(labels[i:], "."))
+		for _, rr := range nsec3 {
+			if rr.Match(encloser) {
+				return encloser, dns.Fqdn(strings.Join(labels[i-1:], ".")), true
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// commonAncestor returns the longest name both a and b are in
+func commonAncestor(a string, b string) string {
+	n := dns.CompareDomainName(a, b)
+	labels := dns.SplitDomainName(a)
+
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+// covers reports whether name falls between the owner and next name of
+// nsec in canonical order, RFC 4034 6.1
+func covers(nsec *dns.NSEC, name string) bool {
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	// the last NSEC of a zone wraps around to the apex
+	return canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0
+}
+
+// covers3 reports whether the hash of name falls between the owner and
+// next hash of nsec3, RFC 5155 8.3. Unlike NSEC3.Cover, the owner hash
+// itself isn't covered.
+func covers3(nsec3 *dns.NSEC3, name string) bool {
+	if !dns.IsSubDomain(parentName(nsec3.Hdr.Name), name) {
+		return false
+	}
+	hash := strings.ToUpper(dns.HashName(name, nsec3.Hash, nsec3.Iterations, nsec3.Salt))
+	owner := strings.ToUpper(dns.SplitDomainName(nsec3.Hdr.Name)[0])
+	next := strings.ToUpper(nsec3.NextDomain)
+	if owner < next {
+		return owner < hash && hash < next
+	}
+	// the last NSEC3 of a zone wraps around to the first
+	return owner < hash || hash < next
+}
+
+// canonicalCompare orders names label by label from the root, comparing
+// lower cased labels as bytes, RFC 4034 6.1
+func canonicalCompare(a string, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(unescape(la[i]), unescape(lb[j])); c != 0 {
+			return c
+		}
+	}
+
+	return len(la) - len(lb)
+}
+
+// unescape returns the octets of a label written with \DDD or \X
+// escapes
+func unescape(label string) string {
+	if !strings.Contains(label, "\\") {
+		return label
+	}
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 == len(label) {
+			b.WriteByte(label[i])
+			continue
+		}
+		if i+3 < len(label) && isDigit(label[i+1]) && isDigit(label[i+2]) && isDigit(label[i+3]) {
+			b.WriteByte((label[i+1]-'0')*100 + (label[i+2]-'0')*10 + label[i+3] - '0')
+			i += 3
+			continue
+		}
+		b.WriteByte(label[i+1])
+		i++
+	}
+
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func hasType(bitmap []uint16, rrtype uint16) bool {
+	for _, t := range bitmap {
+		if t == rrtype {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nsec3s returns the NSEC3 records of proof
+func nsec3s(proof []*rrset) []*dns.NSEC3 {
+	records := []*dns.NSEC3{}
+	for _, set := range proof {
+		for _, rr := range set.rrs {
+			if nsec3, ok := rr.(*dns.NSEC3); ok {
+				records = append(records, nsec3)
+			}
+		}
+	}
+
+	return records
+}
+
+// keySet returns the DNSKEY set of zone in resp
+func keySet(resp *dns.Msg, zone string) (*rrset, []*dns.DNSKEY) {
+	for _, set := range rrsets(resp.Answer) {
+		if set.rrtype() != dns.TypeDNSKEY || !strings.EqualFold(set.name(), zone) {
+			continue
+		}
+		keys := make([]*dns.DNSKEY, 0, len(set.rrs))
+		for _, rr := range set.rrs {
+			keys = append(keys, rr.(*dns.DNSKEY))
+		}
+		return set, keys
+	}
+
+	return nil, nil
+}
+
+// query asks the upstream for the records of name with their
+// signatures, leaving the validation to us
+func (v *Validator) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(dns.DefaultMsgSize, true)
+	m.CheckingDisabled = true
+
+	resp, err := v.exchange(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s looking up %s %s", dns.RcodeToString[resp.Rcode], name, dns.TypeToString[qtype])
+	}
+
+	return resp, nil
+}
+
+// store caches the keys of zone, dropping expired zones when the cache
+// is full
+func (v *Validator) store(zone string, keys zoneKeys) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	if len(v.zones) >= maxZones {
+		now := time.Now()
+		for name, cached := range v.zones {
+			if now.After(cached.expiresAt) {
+				delete(v.zones, name)
+			}
+		}
+		if len(v.zones) >= maxZones {
+			v.zones = map[string]zoneKeys{}
+		}
+	}
+	v.zones[zone] = keys
+}
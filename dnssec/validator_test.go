@@ -0,0 +1,307 @@
+package dnssec
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey is the key of a zone and what signs with it
+type testKey struct {
+	key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+func newKey(t *testing.T, zone string) testKey {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	require.NoError(t, err)
+
+	return testKey{key: key, signer: private.(crypto.Signer)}
+}
+
+// sign returns rrs followed by their signature
+func (k testKey) sign(t *testing.T, rrs ...dns.RR) []dns.RR {
+	return k.signAt(t, time.Now(), rrs...)
+}
+
+// signAt returns rrs followed by a signature that is valid at now
+func (k testKey) signAt(t *testing.T, now time.Time, rrs ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  k.key.Algorithm,
+		Expiration: uint32(now.Add(24 * time.Hour).Unix()),
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:     k.key.KeyTag(),
+		SignerName: k.key.Hdr.Name,
+	}
+	require.NoError(t, sig.Sign(k.signer, rrs))
+
+	return append(append([]dns.RR{}, rrs...), sig)
+}
+
+func rr(t *testing.T, record string) dns.RR {
+	r, err := dns.NewRR(record)
+	require.NoError(t, err)
+
+	return r
+}
+
+func reply(name string, qtype uint16, rcode int, answer []dns.RR, ns []dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.Response = true
+	m.Rcode = rcode
+	m.Answer = answer
+	m.Ns = ns
+
+	return m
+}
+
+// testTree is a signed root with a signed example. zone and an unsigned
+// insecure. zone, answering from canned replies
+type testTree struct {
+	root    testKey
+	example testKey
+	replies map[string]*dns.Msg
+}
+
+func newTree(t *testing.T) *testTree {
+	root := newKey(t, ".")
+	example := newKey(t, "example.")
+	tree := &testTree{root: root, example: example, replies: map[string]*dns.Msg{}}
+
+	rootSOA := root.sign(t, rr(t, ". 86400 IN SOA a.root-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400"))
+	exampleSOA := example.sign(t, rr(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 900 1209600 300"))
+	ds := example.key.ToDS(dns.SHA256)
+	ds.Hdr.Ttl = 3600
+
+	tree.add(reply(".", dns.TypeDNSKEY, dns.RcodeSuccess, root.sign(t, root.key), nil))
+	tree.add(reply("example.", dns.TypeDS, dns.RcodeSuccess, root.sign(t, ds), nil))
+	tree.add(reply("example.", dns.TypeDNSKEY, dns.RcodeSuccess, example.sign(t, example.key), nil))
+	tree.add(reply("insecure.", dns.TypeDS, dns.RcodeSuccess, nil,
+		append(rootSOA, root.sign(t, rr(t, "insecure. 86400 IN NSEC zzz. NS RRSIG NSEC"))...)))
+	tree.add(reply("host.insecure.", dns.TypeDS, dns.RcodeSuccess, nil,
+		[]dns.RR{rr(t, "insecure. 3600 IN SOA ns.insecure. admin.insecure. 1 7200 900 1209600 300")}))
+	tree.add(reply("www.example.", dns.TypeDS, dns.RcodeSuccess, nil,
+		append(exampleSOA, example.sign(t, rr(t, "www.example. 300 IN NSEC example. A RRSIG NSEC"))...)))
+
+	return tree
+}
+
+func (tree *testTree) add(m *dns.Msg) {
+	q := m.Question[0]
+	tree.replies[fmt.Sprintf("%s/%d", dns.CanonicalName(q.Name), q.Qtype)] = m
+}
+
+func (tree *testTree) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	q := m.Question[0]
+	resp, ok := tree.replies[fmt.Sprintf("%s/%d", dns.CanonicalName(q.Name), q.Qtype)]
+	if !ok {
+		return nil, fmt.Errorf("unexpected query for %s %s", q.Name, dns.TypeToString[q.Qtype])
+	}
+
+	return resp.Copy(), nil
+}
+
+func Test_validate(t *testing.T) {
+	tree := newTree(t)
+	ex := tree.example
+
+	www := rr(t, "www.example. 300 IN A 192.0.2.1")
+	wildcard := ex.sign(t, rr(t, "*.wild.example. 300 IN A 192.0.2.9"))
+	expanded := []dns.RR{rr(t, "foo.wild.example. 300 IN A 192.0.2.9"), dns.Copy(wildcard[1])}
+	expanded[1].Header().Name = "foo.wild.example."
+	soa := ex.sign(t, rr(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 900 1209600 300"))
+	nsecApex := ex.sign(t, rr(t, "example. 300 IN NSEC *.wild.example. SOA NS RRSIG NSEC DNSKEY"))
+	nsecWild := ex.sign(t, rr(t, "*.wild.example. 300 IN NSEC www.example. A RRSIG NSEC"))
+	nsecWWW := ex.sign(t, rr(t, "www.example. 300 IN NSEC example. A RRSIG NSEC"))
+	tampered := ex.sign(t, rr(t, "www.example. 300 IN A 192.0.2.1"))
+	tampered[0].(*dns.A).A = []byte{192, 0, 2, 66}
+
+	tests := []struct {
+		name           string
+		resp           *dns.Msg
+		expected       Status
+		expectedErrMsg string
+	}{
+		{
+			name:     "signed answer",
+			resp:     reply("www.example.", dns.TypeA, dns.RcodeSuccess, ex.sign(t, www), nil),
+			expected: Secure,
+		},
+		{
+			name:           "changed answer",
+			resp:           reply("www.example.", dns.TypeA, dns.RcodeSuccess, tampered, nil),
+			expected:       Bogus,
+			expectedErrMsg: "signature of www.example. A doesn't match key",
+		},
+		{
+			name:           "unsigned answer in a signed zone",
+			resp:           reply("www.example.", dns.TypeA, dns.RcodeSuccess, []dns.RR{www}, nil),
+			expected:       Bogus,
+			expectedErrMsg: "www.example. A isn't signed",
+		},
+		{
+			name:     "unsigned answer in an insecure zone",
+			resp:     reply("host.insecure.", dns.TypeA, dns.RcodeSuccess, []dns.RR{rr(t, "host.insecure. 300 IN A 198.51.100.1")}, nil),
+			expected: Insecure,
+		},
+		{
+			name:     "NXDOMAIN",
+			resp:     reply("missing.example.", dns.TypeA, dns.RcodeNameError, nil, append(soa, nsecApex...)),
+			expected: Secure,
+		},
+		{
+			name:           "NXDOMAIN without proof",
+			resp:           reply("missing.example.", dns.TypeA, dns.RcodeNameError, nil, soa),
+			expected:       Bogus,
+			expectedErrMsg: "no proof missing.example. A doesn't exist",
+		},
+		{
+			name:     "no data",
+			resp:     reply("www.example.", dns.TypeAAAA, dns.RcodeSuccess, nil, append(soa, nsecWWW...)),
+			expected: Secure,
+		},
+		{
+			name:           "no data denied for a type that exists",
+			resp:           reply("www.example.", dns.TypeA, dns.RcodeSuccess, nil, append(soa, nsecWWW...)),
+			expected:       Bogus,
+			expectedErrMsg: "NSEC of www.example. says it has A records",
+		},
+		{
+			name:     "wildcard answer",
+			resp:     reply("foo.wild.example.", dns.TypeA, dns.RcodeSuccess, expanded, nsecWild),
+			expected: Secure,
+		},
+		{
+			name:           "wildcard answer without proof",
+			resp:           reply("foo.wild.example.", dns.TypeA, dns.RcodeSuccess, expanded, nil),
+			expected:       Bogus,
+			expectedErrMsg: "no proof foo.wild.example. A doesn't exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anchors, err := NewAnchors(tree.root.key.ToDS(dns.SHA256).String())
+			require.NoError(t, err)
+			v := New(tree.exchange, anchors)
+
+			actual, err := v.Validate(context.Background(), tt.resp)
+			assert.Equal(t, tt.expected, actual)
+			if tt.expectedErrMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// nsec3Chain returns the signed NSEC3 records of the example. zone
+// holding names, with the types of each
+func nsec3Chain(t *testing.T, key testKey, names map[string][]uint16) []dns.RR {
+	hashes := []string{}
+	types := map[string][]uint16{}
+	for name, bitmap := range names {
+		hash := strings.ToLower(dns.HashName(name, dns.SHA1, 0, ""))
+		hashes = append(hashes, hash)
+		types[hash] = bitmap
+	}
+	slices.Sort(hashes)
+
+	rrs := []dns.RR{}
+	for i, hash := range hashes {
+		nsec3 := &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: hash + ".example.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+			Hash:       dns.SHA1,
+			NextDomain: strings.ToUpper(hashes[(i+1)%len(hashes)]),
+			HashLength: 20,
+			TypeBitMap: types[hash],
+		}
+		rrs = append(rrs, key.sign(t, nsec3)...)
+	}
+
+	return rrs
+}
+
+func Test_validateNSEC3(t *testing.T) {
+	tree := newTree(t)
+	ex := tree.example
+	soa := ex.sign(t, rr(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 900 1209600 300"))
+	chain := nsec3Chain(t, ex, map[string][]uint16{
+		"example.":        {dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+		"www.example.":    {dns.TypeA, dns.TypeRRSIG},
+		"wild.example.":   {},
+		"*.wild.example.": {dns.TypeA, dns.TypeRRSIG},
+	})
+
+	tests := []struct {
+		name     string
+		resp     *dns.Msg
+		expected Status
+	}{
+		{
+			name:     "NXDOMAIN",
+			resp:     reply("missing.example.", dns.TypeA, dns.RcodeNameError, nil, append(soa, chain...)),
+			expected: Secure,
+		},
+		{
+			name:     "no data",
+			resp:     reply("www.example.", dns.TypeAAAA, dns.RcodeSuccess, nil, append(soa, chain...)),
+			expected: Secure,
+		},
+		{
+			name:     "NXDOMAIN for a name that exists",
+			resp:     reply("www.example.", dns.TypeA, dns.RcodeNameError, nil, append(soa, chain...)),
+			expected: Bogus,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anchors, err := NewAnchors(tree.root.key.ToDS(dns.SHA256).String())
+			require.NoError(t, err)
+			v := New(tree.exchange, anchors)
+
+			actual, _ := v.Validate(context.Background(), tt.resp)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_validateUntrustedRoot(t *testing.T) {
+	tree := newTree(t)
+	other := newKey(t, ".")
+	anchors, err := NewAnchors(other.key.ToDS(dns.SHA256).String())
+	require.NoError(t, err)
+	v := New(tree.exchange, anchors)
+
+	resp := reply("www.example.", dns.TypeA, dns.RcodeSuccess, tree.example.sign(t, rr(t, "www.example. 300 IN A 192.0.2.1")), nil)
+	status, err := v.Validate(context.Background(), resp)
+	assert.Equal(t, Bogus, status)
+	assert.ErrorContains(t, err, "root DNSKEY records aren't signed by a trust anchor")
+}
+
+func Test_canonicalCompare(t *testing.T) {
+	// RFC 4034 6.1
+	ordered := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.", "z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example."}
+	for i := 1; i < len(ordered); i++ {
+		assert.Negative(t, canonicalCompare(ordered[i-1], ordered[i]), "%s < %s", ordered[i-1], ordered[i])
+	}
+}
@@ -2,35 +2,94 @@ package dohClient
 
 import (
 	"context"
+	"dumbdns/forwarder"
+	"dumbdns/logging"
 	"dumbdns/models"
-	"log"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
 
 	"github.com/likexian/doh-go"
 	dohDns "github.com/likexian/doh-go/dns"
+	"github.com/miekg/dns"
 )
 
 type DohClient struct {
 	Doh *doh.DoH
+	// providers are asked for whole DNS messages, in order, when the
+	// JSON API isn't enough, e.g: for DNSSEC records
+	providers []int
+}
+
+// providers maps the names used in config to doh-go providers
+var providers = map[string]int{
+	"cloudflare": doh.CloudflareProvider,
+	"dnspod":     doh.DNSPodProvider,
+	"google":     doh.GoogleProvider,
+	"quad9":      doh.Quad9Provider,
+}
+
+// messageURLs are the RFC 8484 endpoints of the providers, which answer
+// with DNS messages rather than JSON
+var messageURLs = map[int]string{
+	doh.CloudflareProvider: "https://cloudflare-dns.com/dns-query",
+	doh.DNSPodProvider:     "https://doh.pub/dns-query",
+	doh.GoogleProvider:     "https://dns.google/dns-query",
+	doh.Quad9Provider:      "https://dns.quad9.net/dns-query",
+}
+
+// ParseProviders converts provider names, e.g: "quad9", to doh-go
+// providers
+func ParseProviders(names []string) ([]int, error) {
+	parsed := make([]int, 0, len(names))
+	for _, name := range names {
+		provider, ok := providers[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown upstream provider: %q", name)
+		}
+		parsed = append(parsed, provider)
+	}
+
+	return parsed, nil
 }
 
 func Start(provider ...int) *DohClient {
+	// doh-go uses every provider when none are given
+	asked := provider
+	if len(asked) == 0 {
+		asked = doh.Providers
+	}
+
 	return &DohClient{
-		Doh: doh.Use(provider...),
+		Doh:       doh.Use(provider...),
+		providers: asked,
 	}
 }
 
-// QueryAuthority makes DNS over HTTPS request
-func (d *DohClient) QueryAuthority(ctx context.Context, address string, questionQueryType dohDns.Type) []string {
-	dohResp, err := d.Doh.Query(ctx, dohDns.Domain(address), questionQueryType)
+// noSubnet asks providers not to send the subnet they see queries from
+// to authoritative servers, RFC 7871 7.1.2
+const noSubnet = dohDns.ECS("0.0.0.0/0")
+
+// QueryAuthority makes DNS over HTTPS request, returning the answers
+// and the name of the provider that answered. subnet is sent in the
+// EDNS Client Subnet option, an invalid prefix opts out of ECS.
+func (d *DohClient) QueryAuthority(ctx context.Context, address string, questionQueryType dohDns.Type, subnet netip.Prefix) ([]string, string) {
+	ecs := noSubnet
+	if subnet.IsValid() {
+		ecs = dohDns.ECS(subnet.String())
+	}
+
+	dohResp, err := d.Doh.ECSQuery(ctx, dohDns.Domain(address), questionQueryType, ecs)
 	if err != nil {
 		// retry failed lookup
-		dohResp, err = d.Doh.Query(ctx, dohDns.Domain(address), questionQueryType)
+		dohResp, err = d.Doh.ECSQuery(ctx, dohDns.Domain(address), questionQueryType, ecs)
 		if err != nil {
-			return []string{}
+			return []string{}, ""
 		}
 	}
 	if dohResp == nil {
-		return []string{}
+		return []string{}, ""
 	}
 
 	queryResp := []string{}
@@ -39,7 +98,7 @@ func (d *DohClient) QueryAuthority(ctx context.Context, address string, question
 		// e.g: ipv6.googlg.com returns type 5 (CNAME) and 28 (AAAA) which would break AAAA response
 		responseQueryType, err := models.QueryToDoHType(uint16(answer.Type))
 		if err != nil {
-			log.Printf("error finding doh query type response for type %d with error %s", answer.Type, err)
+			logging.Debugf("error finding doh query type response for type %d with error %s", answer.Type, err)
 			continue
 		}
 		if responseQueryType != questionQueryType {
@@ -49,5 +108,39 @@ func (d *DohClient) QueryAuthority(ctx context.Context, address string, question
 		queryResp = append(queryResp, answer.Data)
 	}
 
-	return queryResp
+	return queryResp, dohResp.Provider
+}
+
+// QueryDNSSEC asks for the answers to address along with the records
+// needed to validate them, returning the reply and the name of the
+// provider that answered. subnet is sent like QueryAuthority does.
+func (d *DohClient) QueryDNSSEC(ctx context.Context, address string, questionQueryType dohDns.Type, subnet netip.Prefix) (*dns.Msg, string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(address), dns.StringToType[string(questionQueryType)])
+	m.SetEdns0(dns.DefaultMsgSize, true)
+	// the answer is validated here, providers mustn't drop bogus ones
+	m.CheckingDisabled = true
+	if !subnet.IsValid() {
+		subnet = netip.MustParsePrefix(string(noSubnet))
+	}
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, forwarder.ClientSubnet(subnet))
+
+	return d.Exchange(ctx, m)
+}
+
+// Exchange sends m to each provider in turn until one answers, returning
+// the reply and the name of the provider
+func (d *DohClient) Exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, string, error) {
+	var errs []error
+	for _, provider := range d.providers {
+		resp, err := forwarder.Exchange(ctx, messageURLs[provider], m)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return resp, doh.New(provider).String(), nil
+	}
+
+	return nil, "", errors.Join(errs...)
 }
@@ -0,0 +1,210 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Schemes are the upstream url schemes that can be forwarded to
+var Schemes = []string{"udp", "tcp", "https"}
+
+// Result is the answer to a forwarded question
+type Result struct {
+	// Data holds the answers of the asked type, e.g: "192.168.0.1"
+	Data     []string
+	Rcode    int
+	Upstream string
+	// Scope is the prefix length of the client subnet the answer
+	// applies to, 0 when it applies to every client
+	Scope int
+}
+
+// Query asks each upstream in turn until one answers. Upstreams are
+// urls like udp://10.0.0.2, tcp://10.0.0.2:5353 or
+// https://dns.example.com/dns-query. subnet is sent in the EDNS Client
+// Subnet option when it's valid.
+func Query(ctx context.Context, upstreams []string, name string, qtype uint16, subnet netip.Prefix) (Result, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	if subnet.IsValid() {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, ClientSubnet(subnet))
+	}
+
+	var errs []error
+	for _, upstream := range upstreams {
+		resp, err := Exchange(ctx, upstream, m)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		return Result{Rcode: resp.Rcode, Upstream: upstream, Data: Answers(resp, qtype), Scope: SubnetScope(resp)}, nil
+	}
+
+	return Result{}, errors.Join(errs...)
+}
+
+// Answers returns the data of the answers of type qtype in resp, e.g:
+// "10 mail.example.com." for an MX record
+func Answers(resp *dns.Msg, qtype uint16) []string {
+	data := []string{}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		data = append(data, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+
+	return data
+}
+
+// Signatures returns the data of the RRSIG records of resp that sign
+// the answers of qtype owned by name, answers reached through a CNAME
+// are signed by another owner and left out
+func Signatures(resp *dns.Msg, name string, qtype uint16) []string {
+	data := []string{}
+	for _, rr := range resp.Answer {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok || sig.TypeCovered != qtype || !strings.EqualFold(sig.Hdr.Name, dns.Fqdn(name)) {
+			continue
+		}
+		data = append(data, strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+
+	return data
+}
+
+// ClientSubnet returns the EDNS Client Subnet option for subnet
+func ClientSubnet(subnet netip.Prefix) *dns.EDNS0_SUBNET {
+	ecs := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: uint8(subnet.Bits()),
+		Address:       subnet.Addr().AsSlice(),
+	}
+	if subnet.Addr().Is6() {
+		ecs.Family = 2
+	}
+
+	return ecs
+}
+
+// SubnetScope returns the scope of the EDNS Client Subnet option of
+// resp, an answer without one isn't tailored to the subnet
+func SubnetScope(resp *dns.Msg) int {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return 0
+	}
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return int(ecs.SourceScope)
+		}
+	}
+
+	return 0
+}
+
+// Exchange sends m to upstream and returns its reply
+func Exchange(ctx context.Context, upstream string, m *dns.Msg) (*dns.Msg, error) {
+	u, err := ParseUpstream(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "https":
+		return exchangeHTTPS(ctx, u.String(), m)
+	default:
+		client := &dns.Client{Net: u.Scheme}
+		resp, _, err := client.ExchangeContext(ctx, m, u.Host)
+		if err == nil && resp.Truncated && u.Scheme == "udp" {
+			// the answer didn't fit in a datagram, ask again over TCP
+			client.Net = "tcp"
+			resp, _, err = client.ExchangeContext(ctx, m, u.Host)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error querying %s: %w", upstream, err)
+		}
+		return resp, nil
+	}
+}
+
+// ParseUpstream parses an upstream url, adding port 53 to udp and tcp
+// upstreams that don't give one
+func ParseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", upstream, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q: missing host", upstream)
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(strings.Trim(u.Host, "[]"), "53")
+		}
+	case "https":
+	default:
+		return nil, fmt.Errorf("invalid upstream %q: scheme must be one of %s", upstream, strings.Join(Schemes, ", "))
+	}
+
+	return u, nil
+}
+
+// exchangeHTTPS sends m as an RFC 8484 DNS over HTTPS POST request
+func exchangeHTTPS(ctx context.Context, upstream string, m *dns.Msg) (*dns.Msg, error) {
+	// the id is always 0 so responses can be cached by HTTP caches
+	query := m.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("error packing query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstream, bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %w", upstream, err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %w", upstream, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error querying %s: %s", upstream, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %s: %w", upstream, err)
+	}
+	reply := new(dns.Msg)
+	err = reply.Unpack(body)
+	if err != nil {
+		return nil, fmt.Errorf("error unpacking response from %s: %w", upstream, err)
+	}
+	reply.Id = m.Id
+
+	return reply, nil
+}
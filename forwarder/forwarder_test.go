@@ -0,0 +1,200 @@
+package forwarder
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// answer replies to A questions for wiki.corp.internal and NXDOMAIN to
+// anything else. A client subnet is echoed with a /16 scope.
+func answer(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(opt.UDPSize(), false)
+		for _, o := range opt.Option {
+			if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+				ecs.SourceScope = 16
+				m.IsEdns0().Option = append(m.IsEdns0().Option, ecs)
+			}
+		}
+	}
+	q := r.Question[0]
+	if q.Name != "wiki.corp.internal." {
+		m.Rcode = dns.RcodeNameError
+		return m
+	}
+	if q.Qtype == dns.TypeA {
+		rr, _ := dns.NewRR("wiki.corp.internal. 60 IN A 10.0.0.20")
+		m.Answer = append(m.Answer, rr)
+	}
+
+	return m
+}
+
+func startServer(t *testing.T, network string) string {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		w.WriteMsg(answer(r))
+	})
+	started := make(chan struct{})
+	server := &dns.Server{Net: network, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server.PacketConn = conn
+	} else {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server.Listener = listener
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	if network == "udp" {
+		return "udp://" + server.PacketConn.LocalAddr().String()
+	}
+	return "tcp://" + server.Listener.Addr().String()
+}
+
+func startHTTPS(t *testing.T) string {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		m := new(dns.Msg)
+		if r.Header.Get("Content-Type") != "application/dns-message" || m.Unpack(body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		packed, _ := answer(m).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packed)
+	}))
+	t.Cleanup(server.Close)
+
+	// trust the test server certificate
+	previous := http.DefaultClient
+	http.DefaultClient = server.Client()
+	t.Cleanup(func() { http.DefaultClient = previous })
+
+	return server.URL + "/dns-query"
+}
+
+func Test_query(t *testing.T) {
+	upstreams := map[string]string{
+		"udp":   startServer(t, "udp"),
+		"tcp":   startServer(t, "tcp"),
+		"https": startHTTPS(t),
+	}
+
+	tests := []struct {
+		name     string
+		qname    string
+		qtype    uint16
+		subnet   netip.Prefix
+		expected Result
+	}{
+		{
+			name:     "Answer",
+			qname:    "wiki.corp.internal",
+			qtype:    dns.TypeA,
+			expected: Result{Data: []string{"10.0.0.20"}, Rcode: dns.RcodeSuccess},
+		},
+		{
+			name:     "No data",
+			qname:    "wiki.corp.internal",
+			qtype:    dns.TypeAAAA,
+			expected: Result{Data: []string{}, Rcode: dns.RcodeSuccess},
+		},
+		{
+			name:     "Client subnet",
+			qname:    "wiki.corp.internal",
+			qtype:    dns.TypeA,
+			subnet:   netip.MustParsePrefix("203.0.113.0/24"),
+			expected: Result{Data: []string{"10.0.0.20"}, Rcode: dns.RcodeSuccess, Scope: 16},
+		},
+		{
+			name:     "NXDOMAIN",
+			qname:    "missing.corp.internal",
+			qtype:    dns.TypeA,
+			expected: Result{Data: []string{}, Rcode: dns.RcodeNameError},
+		},
+	}
+
+	for scheme, upstream := range upstreams {
+		for _, tt := range tests {
+			t.Run(scheme+" "+tt.name, func(t *testing.T) {
+				actual, err := Query(context.Background(), []string{upstream}, tt.qname, tt.qtype, tt.subnet)
+				require.NoError(t, err)
+
+				tt.expected.Upstream = upstream
+				assert.Equal(t, tt.expected, actual)
+			})
+		}
+	}
+}
+
+func Test_queryFallback(t *testing.T) {
+	upstream := startServer(t, "udp")
+
+	actual, err := Query(context.Background(), []string{"tcp://127.0.0.1:1", upstream}, "wiki.corp.internal", dns.TypeA, netip.Prefix{})
+	require.NoError(t, err)
+	assert.Equal(t, upstream, actual.Upstream)
+}
+
+func Test_parseUpstream(t *testing.T) {
+	tests := []struct {
+		upstream    string
+		expected    string
+		expectedErr string
+	}{
+		{upstream: "udp://10.0.0.2", expected: "udp://10.0.0.2:53"},
+		{upstream: "tcp://10.0.0.2:5353", expected: "tcp://10.0.0.2:5353"},
+		{upstream: "udp://[fd00::2]", expected: "udp://[fd00::2]:53"},
+		{upstream: "https://dns.example.com/dns-query", expected: "https://dns.example.com/dns-query"},
+		{upstream: "10.0.0.2", expectedErr: `invalid upstream "10.0.0.2": missing host`},
+		{upstream: "tls://10.0.0.2", expectedErr: `invalid upstream "tls://10.0.0.2": scheme must be one of udp, tcp, https`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.upstream, func(t *testing.T) {
+			actual, err := ParseUpstream(tt.upstream)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, actual.String())
+		})
+	}
+}
+
+func Test_Signatures(t *testing.T) {
+	resp := new(dns.Msg)
+	for _, s := range []string{
+		"www.example.com. 300 IN CNAME web.example.com.",
+		"www.example.com. 300 IN RRSIG CNAME 13 3 300 20300101000000 20200101000000 1 example.com. aGVsbG8=",
+		"web.example.com. 300 IN A 192.0.2.1",
+		"web.example.com. 300 IN RRSIG A 13 3 300 20300101000000 20200101000000 2 example.com. aGVsbG8=",
+		"example.com. 300 IN A 192.0.2.2",
+		"Example.com. 300 IN RRSIG A 13 2 300 20300101000000 20200101000000 3 example.com. aGVsbG8=",
+		"example.com. 300 IN RRSIG AAAA 13 2 300 20300101000000 20200101000000 4 example.com. aGVsbG8=",
+	} {
+		rr, err := dns.NewRR(s)
+		require.NoError(t, err)
+		resp.Answer = append(resp.Answer, rr)
+	}
+
+	assert.Equal(t, []string{"A 13 2 300 20300101000000 20200101000000 3 example.com. aGVsbG8="}, Signatures(resp, "example.com", dns.TypeA))
+	// the A record of a CNAME target is signed by the target
+	assert.Empty(t, Signatures(resp, "www.example.com", dns.TypeA))
+}
@@ -0,0 +1,67 @@
+package lifecycle
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"dumbdns/logging"
+)
+
+// Readiness tracks whether the server is answering queries. It is
+// reported to systemd (Type=notify) and served over HTTP for
+// orchestrators.
+type Readiness struct {
+	ready atomic.Bool
+}
+
+func (r *Readiness) Ready() bool {
+	return r.ready.Load()
+}
+
+// SetReady marks the server as serving
+func (r *Readiness) SetReady() {
+	r.ready.Store(true)
+	notify("READY=1")
+	logging.Infof("DumbDNS is ready\n")
+}
+
+// SetStopping marks the server as shutting down
+func (r *Readiness) SetStopping() {
+	r.ready.Store(false)
+	notify("STOPPING=1")
+}
+
+// ServeHTTP answers 200 once ready and 503 otherwise
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.Ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// notify sends state to systemd when running as a Type=notify service
+func notify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// abstract sockets are given with a leading "@"
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		logging.Warnf("error notifying systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		logging.Warnf("error notifying systemd: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package lifecycle
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// notifySocket listens on a unixgram socket set as NOTIFY_SOCKET, the
+// way systemd does for Type=notify services
+func notifySocket(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+
+	return string(buf[:n])
+}
+
+func Test_Readiness(t *testing.T) {
+	conn := notifySocket(t)
+	r := &Readiness{}
+
+	tests := []struct {
+		name           string
+		change         func()
+		expectedReady  bool
+		expectedNotify string
+		expectedStatus int
+	}{
+		{name: "Starting", expectedStatus: http.StatusServiceUnavailable},
+		{name: "Ready", change: r.SetReady, expectedReady: true, expectedNotify: "READY=1", expectedStatus: http.StatusOK},
+		{name: "Stopping", change: r.SetStopping, expectedNotify: "STOPPING=1", expectedStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.change != nil {
+				tt.change()
+				assert.Equal(t, tt.expectedNotify, readNotify(t, conn))
+			}
+			assert.Equal(t, tt.expectedReady, r.Ready())
+
+			recorder := httptest.NewRecorder()
+			r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tt.expectedStatus, recorder.Code)
+		})
+	}
+}
+
+func Test_notify(t *testing.T) {
+	t.Run("Without NOTIFY_SOCKET", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+		// nothing to send to, and nothing to fail
+		notify("READY=1")
+	})
+
+	t.Run("Missing socket", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+		// systemd going away doesn't stop the server
+		notify("READY=1")
+	})
+
+	t.Run("Abstract socket", func(t *testing.T) {
+		name := "dumbdns-test-" + filepath.Base(t.TempDir())
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "\x00" + name, Net: "unixgram"})
+		if err != nil {
+			t.Skipf("abstract sockets aren't supported: %v", err)
+		}
+		defer conn.Close()
+		t.Setenv("NOTIFY_SOCKET", "@"+name)
+
+		notify("READY=1")
+		assert.Equal(t, "READY=1", readNotify(t, conn))
+	})
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var level atomic.Int32
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+// ParseLevel converts "debug", "info", "warn" or "error" to a Level
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level: %q", s)
+	}
+}
+
+// SetLevel hides every message below l
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+func Debugf(format string, v ...interface{}) {
+	logf(LevelDebug, format, v...)
+}
+
+func Infof(format string, v ...interface{}) {
+	logf(LevelInfo, format, v...)
+}
+
+func Warnf(format string, v ...interface{}) {
+	logf(LevelWarn, format, v...)
+}
+
+func Errorf(format string, v ...interface{}) {
+	logf(LevelError, format, v...)
+}
+
+func logf(l Level, format string, v ...interface{}) {
+	if l < Level(level.Load()) {
+		return
+	}
+	log.Printf(format, v...)
+}
@@ -1,39 +1,172 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	// schedules can use any timezone without tzdata installed
+	_ "time/tzdata"
 
+	"dumbdns/admin"
 	"dumbdns/database"
 	dnsServer "dumbdns/dns"
 	"dumbdns/dohClient"
-
-	dohGo "github.com/likexian/doh-go"
+	"dumbdns/lifecycle"
+	"dumbdns/logging"
+	"dumbdns/metrics"
+	"dumbdns/querylog"
+	"dumbdns/settings"
+	"dumbdns/stats"
 )
 
 const (
-	port                 = ":53"
-	blockListRefreshRate = 2 * time.Hour
-	cacheTTL             = 5 * time.Minute
+	configWatchRate = 5 * time.Second
+	// shutdownTimeout is how long queries in flight are given to finish
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
-	doh := dohClient.Start(dohGo.Quad9Provider, dohGo.CloudflareProvider)
-	defer doh.Doh.Close()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-config":
+			os.Exit(checkConfig(os.Args[2:]))
+		case "pause":
+			os.Exit(pauseBlocking(os.Args[2:]))
+		case "resume":
+			os.Exit(resumeBlocking(os.Args[2:]))
+		}
+	}
 
-	db := database.Start(cacheTTL)
-	go db.UpdateBlockList(blockListRefreshRate)
+	// flags and environment variables take precedence over the config
+	// file, which takes precedence over the defaults
+	flags, err := settings.Parse(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Failed to parse settings: %s\n", err.Error())
+	}
 
-	server, err := dnsServer.Start(port, doh, db)
+	db := database.Start(settings.Defaults.CacheTTL)
+	err = db.LoadConfig(flags.ConfigPath)
 	if err != nil {
-		log.Fatalf("Failed to start service: %s\n ", err.Error())
+		log.Fatalf("Failed to load config: %s\n", err.Error())
+	}
+	s := flags.Resolve(db.GetConfig().Server)
+	db.TTL = s.CacheTTL
+
+	level, err := logging.ParseLevel(s.LogLevel)
+	if err != nil {
+		log.Fatalf("Failed to parse settings: %s\n", err.Error())
+	}
+	logging.SetLevel(level)
+
+	providers, err := dohClient.ParseProviders(s.Upstream)
+	if err != nil {
+		log.Fatalf("Failed to parse settings: %s\n", err.Error())
 	}
+	doh := dohClient.Start(providers...)
 
-	defer func() {
-		if r := recover(); r != nil {
-			log.Println("recovered error:\n%w", err)
+	if s.CacheFile != "" {
+		err := db.LoadCache(s.CacheFile)
+		if err != nil {
+			logging.Warnf("Starting with an empty cache: %v", err)
+		}
+	}
+
+	// SIGINT and SIGTERM cancel ctx, which stops the background work
+	// and starts the graceful shutdown below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go db.UpdateBlockList(ctx, s.Refresh)
+	go db.WatchConfig(ctx, configWatchRate)
+	go db.WatchLeases(ctx, configWatchRate)
+
+	// reload the config on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			err := db.ReloadConfig()
+			if err != nil {
+				logging.Errorf("Keeping previous config: %v", err)
+			}
 		}
 	}()
 
-	defer server.DnsServer.Shutdown()
+	readiness := &lifecycle.Readiness{}
+	queryStats := stats.Start()
+	queryMetrics := metrics.New(db)
+
+	if metricsConfig := db.GetConfig().Metrics; metricsConfig.Listen != "" {
+		err := queryMetrics.Serve(metricsConfig.Listen, readiness)
+		if err != nil {
+			log.Fatalf("Failed to start metrics: %s\n", err.Error())
+		}
+	}
+
+	var adminAPI *admin.Admin
+	if adminConfig := db.GetConfig().Admin; adminConfig.Listen != "" {
+		adminAPI, err = admin.Start(adminConfig.Listen, adminConfig.Token, db, queryStats, readiness)
+		if err != nil {
+			log.Fatalf("Failed to start admin API: %s\n", err.Error())
+		}
+	}
+
+	recorders := []dnsServer.QueryRecorder{queryStats, queryMetrics}
+	var queryLog *querylog.QueryLog
+	if queryLogConfig := db.GetConfig().QueryLog; queryLogConfig.Path != "" {
+		queryLog, err = querylog.Start(queryLogConfig)
+		if err != nil {
+			log.Fatalf("Failed to start query log: %s\n", err.Error())
+		}
+		recorders = append(recorders, queryLog)
+	}
+
+	server, err := dnsServer.Start(s.Listen, doh, db, recorders...)
+	if err != nil {
+		log.Fatalf("Failed to start service: %s\n ", err.Error())
+	}
+
+	// we're ready once queries are being answered with the block lists
+	// in place, so nothing slips through while they're downloaded
+	select {
+	case <-db.BlockListLoaded():
+		readiness.SetReady()
+	case <-ctx.Done():
+	}
+
+	<-ctx.Done()
+	logging.Infof("Shutting down\n")
+	readiness.SetStopping()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logging.Errorf("Failed to stop service: %v", err)
+	}
+	if adminAPI != nil {
+		adminAPI.HttpServer.Shutdown(shutdownCtx)
+	}
+	if queryMetrics.HttpServer != nil {
+		queryMetrics.HttpServer.Shutdown(shutdownCtx)
+	}
+	if queryLog != nil {
+		queryLog.Close()
+	}
+	if s.CacheFile != "" {
+		err := db.SaveCache(s.CacheFile)
+		if err != nil {
+			logging.Errorf("Failed to save cache: %v", err)
+		}
+	}
+	doh.Doh.Close()
 }
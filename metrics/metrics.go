@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"dumbdns/database"
+	"dumbdns/logging"
+	"dumbdns/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Metrics struct {
+	HttpServer *http.Server
+	db         *database.Database
+
+	queries         *prometheus.CounterVec
+	upstreamLatency *prometheus.HistogramVec
+	cacheHits       prometheus.Counter
+	cacheMisses     prometheus.Counter
+
+	// hits and misses back the ratio gauge, counters can't be read back
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// New registers the DumbDNS metrics. Metrics are only served once
+// Serve is called, but queries can be recorded either way.
+func New(db *database.Database) *Metrics {
+	m := &Metrics{
+		db: db,
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dumbdns",
+			Name:      "queries_total",
+			Help:      "Questions answered, by query type, response code and outcome.",
+		}, []string{"type", "rcode", "outcome"}),
+		upstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "dumbdns",
+			Name:      "upstream_latency_seconds",
+			Help:      "Latency of upstream DoH lookups, by the provider that answered.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"provider"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "dumbdns",
+			Name:      "cache_hits_total",
+			Help:      "Cacheable questions answered from the cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "dumbdns",
+			Name:      "cache_misses_total",
+			Help:      "Cacheable questions that had to be forwarded.",
+		}),
+	}
+
+	prometheus.MustRegister(
+		m.queries,
+		m.upstreamLatency,
+		m.cacheHits,
+		m.cacheMisses,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "dumbdns",
+			Name:      "cache_entries",
+			Help:      "Domains held in the cache.",
+		}, func() float64 {
+			return float64(db.Stats().CacheSize)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "dumbdns",
+			Name:      "cache_hit_ratio",
+			Help:      "Ratio of cacheable questions answered from the cache since start.",
+		}, m.cacheHitRatio),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "dumbdns",
+			Name:      "blocklist_last_refresh_age_seconds",
+			Help:      "Seconds since every block list source was last fetched successfully.",
+		}, func() float64 {
+			lastRefresh := db.LastRefresh()
+			if lastRefresh.IsZero() {
+				return -1
+			}
+			return time.Since(lastRefresh).Seconds()
+		}),
+		blockListCollector{db: db},
+	)
+
+	return m
+}
+
+// Serve starts the /metrics endpoint on addr, along with the /readyz
+// check served by readiness
+func (m *Metrics) Serve(addr string, readiness http.Handler) error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.Handle("GET /readyz", readiness)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error starting metrics endpoint: %w", err)
+	}
+
+	m.HttpServer = &http.Server{Handler: mux}
+	go func() {
+		err := m.HttpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Errorf("metrics endpoint stopped: %v", err)
+		}
+	}()
+	logging.Infof("Serving metrics at %s/metrics\n", listener.Addr())
+
+	return nil
+}
+
+// Record counts a single answered question
+func (m *Metrics) Record(q models.Query) {
+	m.queries.WithLabelValues(q.Type, q.Rcode, string(q.Outcome)).Inc()
+
+	switch q.Outcome {
+	case models.OutcomeCached:
+		m.cacheHits.Inc()
+		m.hits.Add(1)
+	case models.OutcomeForwarded, models.OutcomeBogus:
+		m.cacheMisses.Inc()
+		m.misses.Add(1)
+	}
+
+	if q.Upstream != "" {
+		m.upstreamLatency.WithLabelValues(q.Upstream).Observe(q.UpstreamLatency.Seconds())
+	}
+}
+
+func (m *Metrics) cacheHitRatio() float64 {
+	hits := m.hits.Load()
+	total := hits + m.misses.Load()
+	if total == 0 {
+		return 0
+	}
+
+	return float64(hits) / float64(total)
+}
+
+var blockListEntriesDesc = prometheus.NewDesc(
+	"dumbdns_blocklist_entries",
+	"Entries read from each block list source during the last refresh.",
+	[]string{"source"}, nil,
+)
+
+// blockListCollector reports the entries per source. Sources come and
+// go with the config, so they are collected on scrape rather than kept
+// in a gauge vector.
+type blockListCollector struct {
+	db *database.Database
+}
+
+func (c blockListCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- blockListEntriesDesc
+}
+
+func (c blockListCollector) Collect(ch chan<- prometheus.Metric) {
+	for source, count := range c.db.BlockListSources() {
+		ch <- prometheus.MustNewConstMetric(blockListEntriesDesc, prometheus.GaugeValue, float64(count), source)
+	}
+}
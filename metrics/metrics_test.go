@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dumbdns/database"
+	"dumbdns/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_scrape(t *testing.T) {
+	// New registers on the default registry, so it's only called once
+	db := database.Start(time.Minute)
+	db.Config = &models.Config{}
+	m := New(db)
+
+	queries := []models.Query{
+		{Type: "A", Rcode: "NOERROR", Outcome: models.OutcomeForwarded, Upstream: "quad9", UpstreamLatency: 20 * time.Millisecond},
+		{Type: "A", Rcode: "NOERROR", Outcome: models.OutcomeCached},
+		{Type: "A", Rcode: "NOERROR", Outcome: models.OutcomeCached},
+		{Type: "AAAA", Rcode: "NXDOMAIN", Outcome: models.OutcomeBlocked},
+		{Type: "A", Rcode: "SERVFAIL", Outcome: models.OutcomeBogus},
+	}
+	for _, q := range queries {
+		m.Record(q)
+	}
+
+	expected := `
+# HELP dumbdns_blocklist_last_refresh_age_seconds Seconds since every block list source was last fetched successfully.
+# TYPE dumbdns_blocklist_last_refresh_age_seconds gauge
+dumbdns_blocklist_last_refresh_age_seconds -1
+# HELP dumbdns_cache_entries Domains held in the cache.
+# TYPE dumbdns_cache_entries gauge
+dumbdns_cache_entries 0
+# HELP dumbdns_cache_hit_ratio Ratio of cacheable questions answered from the cache since start.
+# TYPE dumbdns_cache_hit_ratio gauge
+dumbdns_cache_hit_ratio 0.5
+# HELP dumbdns_cache_hits_total Cacheable questions answered from the cache.
+# TYPE dumbdns_cache_hits_total counter
+dumbdns_cache_hits_total 2
+# HELP dumbdns_cache_misses_total Cacheable questions that had to be forwarded.
+# TYPE dumbdns_cache_misses_total counter
+dumbdns_cache_misses_total 2
+# HELP dumbdns_queries_total Questions answered, by query type, response code and outcome.
+# TYPE dumbdns_queries_total counter
+dumbdns_queries_total{outcome="blocked",rcode="NXDOMAIN",type="AAAA"} 1
+dumbdns_queries_total{outcome="bogus",rcode="SERVFAIL",type="A"} 1
+dumbdns_queries_total{outcome="cached",rcode="NOERROR",type="A"} 2
+dumbdns_queries_total{outcome="forwarded",rcode="NOERROR",type="A"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected),
+		"dumbdns_blocklist_last_refresh_age_seconds",
+		"dumbdns_cache_entries",
+		"dumbdns_cache_hit_ratio",
+		"dumbdns_cache_hits_total",
+		"dumbdns_cache_misses_total",
+		"dumbdns_queries_total",
+	))
+
+	// the endpoint serves the same registry
+	recorder := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `dumbdns_upstream_latency_seconds_bucket{provider="quad9",le="0.025"} 1`)
+	assert.Contains(t, string(body), `dumbdns_upstream_latency_seconds_count{provider="quad9"} 1`)
+	assert.Contains(t, string(body), `dumbdns_queries_total{outcome="forwarded",rcode="NOERROR",type="A"} 1`)
+}
@@ -1,12 +1,319 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
 type Config struct {
+	Server           ServerConfig
 	Blocklists       []Sources
 	WhitelistDomains map[string]interface{}
-	Hosts            map[string]string
+	BlockedDomains   map[string]interface{}
+	Hosts            map[string]HostEntry
+	// HostsFiles are read in /etc/hosts format, they give A, AAAA and
+	// PTR answers to every client
+	HostsFiles []string
+	BlockMode  string
+	SafeSearch bool
+	Groups     map[string]GroupConfig
+	Schedules  []ScheduleConfig
+	Timezone   string
+	// ForwardZones sends names in a zone to its own upstreams, keyed by
+	// zone, e.g: "corp.internal": ["udp://10.0.0.2"]
+	ForwardZones map[string][]string
+	// Zones are answered authoritatively from zone files and never
+	// forwarded, keyed by zone, e.g: "home.lan": "home.lan.zone"
+	Zones map[string]string
+	// TSIGKeys authenticate dynamic updates, keyed by key name
+	TSIGKeys map[string]TSIGKey
+	// DynamicUpdates lists the TSIG keys allowed to update each zone,
+	// e.g: "home.lan": ["containers"]
+	DynamicUpdates map[string][]string
+	DHCP           DHCPConfig
+	Chaos          ChaosConfig
+	EDNS           EDNSConfig
+	ECS            ECSConfig
+	DNSSEC         DNSSECConfig
+	Admin          AdminConfig
+	Metrics        MetricsConfig
+	QueryLog       QueryLogConfig
+	Access         AccessConfig
+}
+
+// Clone returns a copy of the config whose maps can be changed without
+// affecting c
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Server.Upstream = slices.Clone(c.Server.Upstream)
+	clone.WhitelistDomains = maps.Clone(c.WhitelistDomains)
+	clone.BlockedDomains = maps.Clone(c.BlockedDomains)
+	clone.Hosts = CloneHosts(c.Hosts)
+	clone.Zones = maps.Clone(c.Zones)
+	clone.TSIGKeys = maps.Clone(c.TSIGKeys)
+	if c.DynamicUpdates != nil {
+		clone.DynamicUpdates = make(map[string][]string, len(c.DynamicUpdates))
+		for zone, keys := range c.DynamicUpdates {
+			clone.DynamicUpdates[zone] = slices.Clone(keys)
+		}
+	}
+	clone.HostsFiles = slices.Clone(c.HostsFiles)
+	clone.DHCP.LeaseFiles = slices.Clone(c.DHCP.LeaseFiles)
+	clone.Access.Allow = slices.Clone(c.Access.Allow)
+	clone.Access.Deny = slices.Clone(c.Access.Deny)
+	if c.ForwardZones != nil {
+		clone.ForwardZones = make(map[string][]string, len(c.ForwardZones))
+		for zone, upstreams := range c.ForwardZones {
+			clone.ForwardZones[zone] = slices.Clone(upstreams)
+		}
+	}
+	if c.Schedules != nil {
+		clone.Schedules = make([]ScheduleConfig, len(c.Schedules))
+		for i, schedule := range c.Schedules {
+			clone.Schedules[i] = schedule.Clone()
+		}
+	}
+	if c.Groups != nil {
+		clone.Groups = make(map[string]GroupConfig, len(c.Groups))
+		for name, group := range c.Groups {
+			clone.Groups[name] = group.Clone()
+		}
+	}
+
+	return &clone
+}
+
+// ServerConfig holds the runtime settings that can also be set with
+// command line flags and environment variables
+type ServerConfig struct {
+	Listen    string   `json:"listen,omitempty"`
+	CacheTTL  Duration `json:"cacheTTL,omitzero"`
+	Refresh   Duration `json:"refresh,omitzero"`
+	Upstream  []string `json:"upstream,omitempty"`
+	LogLevel  string   `json:"logLevel,omitempty"`
+	CacheFile string   `json:"cacheFile,omitempty"`
+}
+
+// Block modes decide how a blocked domain is answered
+const (
+	// BlockModeLocalhost answers with 127.0.0.1 and ::1
+	BlockModeLocalhost = "localhost"
+	// BlockModeNull answers with 0.0.0.0 and ::
+	BlockModeNull     = "null"
+	BlockModeNXDomain = "nxdomain"
+	BlockModeRefused  = "refused"
+)
+
+// GroupConfig is the filtering policy of a group of clients, given as
+// IPs, CIDRs or hosts file names. Fields left nil use the top level
+// config, which is also used for clients in no group.
+type GroupConfig struct {
+	Clients          []string
+	Blocklists       []Sources
+	WhitelistDomains map[string]interface{}
+	BlockedDomains   map[string]interface{}
+	Hosts            map[string]HostEntry
+	BlockMode        string
+	SafeSearch       *bool
+	ECS              *ECSConfig
+}
+
+// Clone returns a copy of the group whose maps and slices can be
+// changed without affecting g
+func (g GroupConfig) Clone() GroupConfig {
+	g.Clients = slices.Clone(g.Clients)
+	g.Blocklists = slices.Clone(g.Blocklists)
+	g.WhitelistDomains = maps.Clone(g.WhitelistDomains)
+	g.BlockedDomains = maps.Clone(g.BlockedDomains)
+	g.Hosts = CloneHosts(g.Hosts)
+	if g.SafeSearch != nil {
+		safeSearch := *g.SafeSearch
+		g.SafeSearch = &safeSearch
+	}
+	if g.ECS != nil {
+		ecs := *g.ECS
+		g.ECS = &ecs
+	}
+
+	return g
+}
+
+// ScheduleConfig blocks extra domains for some groups during a weekly
+// time window, e.g: social media on school nights. Start and End are
+// written as "15:04" and the window ends the next day when End is
+// before Start.
+type ScheduleConfig struct {
+	Name string `json:"name"`
+	// Groups the schedule applies to, "default" is the clients in no
+	// group. Every client is included when empty.
+	Groups []string `json:"groups,omitempty"`
+	// Days the window starts on, e.g: "mon". Every day when empty.
+	Days           []string  `json:"days,omitempty"`
+	Start          string    `json:"start"`
+	End            string    `json:"end"`
+	Blocklists     []Sources `json:"blockLists,omitempty"`
+	BlockedDomains []string  `json:"blockList,omitempty"`
+}
+
+// Clone returns a copy of the schedule whose slices can be changed
+// without affecting s
+func (s ScheduleConfig) Clone() ScheduleConfig {
+	s.Groups = slices.Clone(s.Groups)
+	s.Days = slices.Clone(s.Days)
+	s.Blocklists = slices.Clone(s.Blocklists)
+	s.BlockedDomains = slices.Clone(s.BlockedDomains)
+
+	return s
 }
 
 type Sources struct {
 	Regex string `json:"regex"`
 	Url   string `json:"url"`
 }
+
+// AdminConfig configures the local HTTP admin API.
+// The API is only started when Listen is set.
+type AdminConfig struct {
+	Listen string `json:"listen,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// MetricsConfig configures the Prometheus metrics endpoint.
+// The endpoint is only started when Listen is set.
+type MetricsConfig struct {
+	Listen string `json:"listen,omitempty"`
+}
+
+// QueryLogConfig configures the JSONL query log.
+// The log is only written when Path is set.
+type QueryLogConfig struct {
+	Path string `json:"path,omitempty"`
+	// MaxSizeMB and MaxAge rotate the log once either is reached
+	MaxSizeMB  int      `json:"maxSizeMB,omitempty"`
+	MaxAge     Duration `json:"maxAge,omitzero"`
+	MaxBackups int      `json:"maxBackups,omitempty"`
+	// ClientIP is one of "full", "hash" or "truncate"
+	ClientIP string `json:"clientIP,omitempty"`
+	// HashSalt is mixed into hashed client IPs, a random salt is used
+	// when empty so hashes only match within a single run
+	HashSalt string `json:"hashSalt,omitempty"`
+}
+
+// AccessConfig lists the clients allowed to query the server, as IPs or
+// CIDRs. Deny takes precedence over Allow, and an empty Allow lets in
+// private and loopback addresses.
+type AccessConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// DHCPConfig publishes the names of DHCP clients from the lease files of
+// a DHCP server, e.g: laptop.home.lan
+type DHCPConfig struct {
+	// LeaseFiles in dnsmasq or ISC dhcpd format
+	LeaseFiles []string `json:"leaseFiles,omitempty"`
+	// Domain the client names are published under
+	Domain string `json:"domain,omitempty"`
+}
+
+// EDNSConfig configures the EDNS0 (RFC 6891) options of replies
+type EDNSConfig struct {
+	// UDPSize is the largest UDP reply advertised to clients, 1232 by
+	// default. Larger replies are truncated and retried over TCP.
+	UDPSize int `json:"udpSize,omitempty"`
+}
+
+// ECS modes decide what upstreams learn of the subnet of a client
+// through the EDNS Client Subnet option, RFC 7871
+const (
+	// ECSStrip asks upstreams not to use the subnet they see queries from
+	ECSStrip = "strip"
+	// ECSPassThrough sends the subnet the client sent, if any
+	ECSPassThrough = "passthrough"
+	// ECSReplace sends the configured subnet
+	ECSReplace = "replace"
+)
+
+// ECSConfig is the EDNS Client Subnet policy of a group of clients
+type ECSConfig struct {
+	// Mode is one of strip, the default, passthrough or replace
+	Mode string `json:"mode,omitempty"`
+	// Subnet is sent in replace mode, e.g: "203.0.113.0/24"
+	Subnet string `json:"subnet,omitempty"`
+}
+
+// DNSSECConfig turns on DNSSEC validation of upstream answers
+type DNSSECConfig struct {
+	Validate bool `json:"validate,omitempty"`
+	// TrustAnchorFile keeps the root trust anchors up to date across
+	// restarts as the root keys roll over. The built in anchors are used
+	// on every start when empty.
+	TrustAnchorFile string `json:"trustAnchorFile,omitempty"`
+}
+
+// ChaosConfig answers the CHAOS class TXT questions used to identify a
+// server, e.g: dig CH TXT version.bind. Questions are refused when the
+// answer isn't set.
+type ChaosConfig struct {
+	// Version answers version.bind and version.server
+	Version string `json:"version,omitempty"`
+	// ID answers id.server and hostname.bind
+	ID string `json:"id,omitempty"`
+}
+
+// TSIGKey is a shared secret clients sign dynamic updates with
+type TSIGKey struct {
+	// Algorithm is an HMAC algorithm, e.g: "hmac-sha256", the default
+	Algorithm string `json:"algorithm,omitempty"`
+	// Secret is base64 encoded, as generated by tsig-keygen
+	Secret string `json:"secret"`
+}
+
+// Duration is a time.Duration written as a string in config files,
+// e.g: "5m" or "2h"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	d.Duration, err = time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+
+	return nil
+}
+
+// Stats is a point in time summary of the server state
+type Stats struct {
+	CacheSize      int `json:"cacheSize"`
+	BlockListSize  int `json:"blockListSize"`
+	WhitelistSize  int `json:"whitelistSize"`
+	BlockedDomains int `json:"blockedDomains"`
+	HostsSize      int `json:"hostsSize"`
+	// ActiveSchedules are the names of the schedules blocking right now
+	ActiveSchedules []string `json:"activeSchedules"`
+	// Pauses are the clients blocking is paused for
+	Pauses []Pause `json:"pauses"`
+}
+
+// Pause is blocking turned off for a while, for every client when
+// Client is empty
+type Pause struct {
+	Client    string    `json:"client,omitempty"`
+	Until     time.Time `json:"until"`
+	Remaining Duration  `json:"remaining"`
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+)
+
+// HostEntry is a hosts override. In config files it is written as an
+// ip, a list of ips, or an object with "a", "aaaa" and "cname".
+type HostEntry struct {
+	A     []string `json:"a,omitempty"`
+	AAAA  []string `json:"aaaa,omitempty"`
+	CNAME string   `json:"cname,omitempty"`
+}
+
+// HostIPs returns an entry for ips, split into A and AAAA values.
+// Anything that isn't an IPv6 address is kept as an A value so it can
+// be reported when the config is checked.
+func HostIPs(ips ...string) HostEntry {
+	e := HostEntry{}
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() && !addr.Is4In6() {
+			e.AAAA = append(e.AAAA, ip)
+		} else {
+			e.A = append(e.A, ip)
+		}
+	}
+
+	return e
+}
+
+// IPs returns the A and AAAA values of the entry
+func (e HostEntry) IPs() []string {
+	return slices.Concat(e.A, e.AAAA)
+}
+
+// MarshalJSON writes the shortest form of the entry, so a single ip is
+// still written as a string
+func (e HostEntry) MarshalJSON() ([]byte, error) {
+	ips := e.IPs()
+	switch {
+	case e.CNAME == "" && len(ips) == 1:
+		return json.Marshal(ips[0])
+	case e.CNAME == "" && len(ips) > 0:
+		return json.Marshal(ips)
+	}
+
+	// the alias stops MarshalJSON calling itself
+	type entry HostEntry
+	return json.Marshal(entry(e))
+}
+
+func (e *HostEntry) UnmarshalJSON(data []byte) error {
+	var ip string
+	if json.Unmarshal(data, &ip) == nil {
+		*e = HostIPs(ip)
+		return nil
+	}
+
+	var ips []string
+	if json.Unmarshal(data, &ips) == nil {
+		*e = HostIPs(ips...)
+		return nil
+	}
+
+	type entry HostEntry
+	var object entry
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return fmt.Errorf("hosts entry must be an ip, a list of ips or an object with a, aaaa and cname: %w", err)
+	}
+	*e = HostEntry(object)
+
+	return nil
+}
+
+// CloneHosts returns a copy of hosts whose entries can be changed
+// without affecting hosts
+func CloneHosts(hosts map[string]HostEntry) map[string]HostEntry {
+	if hosts == nil {
+		return nil
+	}
+	clone := maps.Clone(hosts)
+	for name, e := range clone {
+		e.A = slices.Clone(e.A)
+		e.AAAA = slices.Clone(e.AAAA)
+		clone[name] = e
+	}
+
+	return clone
+}
@@ -46,6 +46,46 @@ func QueryToDoHType(t uint16) (dohDns.Type, error) {
 	}
 }
 
+// Outcome describes how a query was answered
+type Outcome string
+
+const (
+	OutcomeBlocked   = Outcome("blocked")
+	OutcomeCached    = Outcome("cached")
+	OutcomeForwarded = Outcome("forwarded")
+	OutcomeHosts     = Outcome("hosts")
+	OutcomeError     = Outcome("error")
+	OutcomeRefused   = Outcome("refused")
+	// OutcomeSafeSearch is a search engine sent to its safe variant
+	OutcomeSafeSearch = Outcome("safesearch")
+	// OutcomeZone is answered authoritatively from a local zone
+	OutcomeZone = Outcome("zone")
+	// OutcomeBogus is an upstream answer that failed DNSSEC validation
+	OutcomeBogus = Outcome("bogus")
+)
+
+// DNSSEC validation states of cached answers
+const (
+	DNSSECSecure   = "secure"
+	DNSSECInsecure = "insecure"
+)
+
+// Query describes how a single question was answered
+type Query struct {
+	Time   time.Time
+	Client string
+	// ClientName is the DHCP or hosts file name of the client
+	ClientName      string
+	Name            string
+	Type            string
+	Outcome         Outcome
+	Rcode           string
+	Answers         []string
+	Latency         time.Duration
+	Upstream        string
+	UpstreamLatency time.Duration
+}
+
 // Record represents a DNS record with multiple supported types
 type Record struct {
 	ExpiresAt time.Time
@@ -60,4 +100,15 @@ type Record struct {
 	SOA   string
 	PTR   []string
 	KX    []string
+
+	// Rcode is set on records answered locally with an error, e.g:
+	// blocked domains answered with NXDOMAIN
+	Rcode int
+
+	// DNSSEC is the validation state of the answers of each type, for
+	// answers looked up with DNSSEC validation on
+	DNSSEC map[dohDns.Type]string
+	// RRSIG holds the signatures of the validated answers of each type,
+	// passed on to clients that set the DO bit
+	RRSIG map[dohDns.Type][]string
 }
@@ -0,0 +1,306 @@
+package querylog
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dumbdns/logging"
+	"dumbdns/models"
+)
+
+const (
+	ClientIPFull     = "full"
+	ClientIPHash     = "hash"
+	ClientIPTruncate = "truncate"
+
+	defaultMaxSizeMB = 100
+
+	// backupFormat is the timestamp suffix of rotated logs
+	backupFormat = "20060102T150405.000"
+)
+
+// QueryLog writes every answered question as a line of JSON, rotating
+// the file once it reaches the configured size or age
+type QueryLog struct {
+	mux    *sync.Mutex
+	config models.QueryLogConfig
+	salt   []byte
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// entry is a single line of the query log
+type entry struct {
+	Time            time.Time `json:"time"`
+	Client          string    `json:"client"`
+	ClientName      string    `json:"clientName,omitempty"`
+	Name            string    `json:"qname"`
+	Type            string    `json:"qtype"`
+	Outcome         string    `json:"outcome"`
+	Rcode           string    `json:"rcode"`
+	Answers         []string  `json:"answers"`
+	LatencyMs       float64   `json:"latencyMs"`
+	Upstream        string    `json:"upstream,omitempty"`
+	UpstreamLatency float64   `json:"upstreamLatencyMs,omitempty"`
+}
+
+func Start(config models.QueryLogConfig) (*QueryLog, error) {
+	switch config.ClientIP {
+	case "":
+		config.ClientIP = ClientIPFull
+	case ClientIPFull, ClientIPHash, ClientIPTruncate:
+	default:
+		return nil, fmt.Errorf("unknown client ip mode: %q", config.ClientIP)
+	}
+	if config.MaxSizeMB == 0 {
+		config.MaxSizeMB = defaultMaxSizeMB
+	}
+
+	salt := []byte(config.HashSalt)
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, fmt.Errorf("error generating hash salt: %w", err)
+		}
+	}
+
+	l := &QueryLog{
+		mux:    &sync.Mutex{},
+		config: config,
+		salt:   salt,
+	}
+
+	err := l.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Record writes q to the log
+func (l *QueryLog) Record(q models.Query) {
+	line, err := json.Marshal(entry{
+		Time:            q.Time.UTC(),
+		Client:          l.clientIP(q.Client),
+		ClientName:      l.clientName(q.ClientName),
+		Name:            q.Name,
+		Type:            q.Type,
+		Outcome:         outcome(q.Outcome),
+		Rcode:           q.Rcode,
+		Answers:         q.Answers,
+		LatencyMs:       milliseconds(q.Latency),
+		Upstream:        q.Upstream,
+		UpstreamLatency: milliseconds(q.UpstreamLatency),
+	})
+	if err != nil {
+		logging.Errorf("error encoding query log entry: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.file != nil && l.shouldRotate(int64(len(line))) {
+		err := l.rotate()
+		if err != nil {
+			logging.Errorf("error rotating query log: %v", err)
+		}
+	}
+	// a log that couldn't be reopened is tried again on every query
+	if l.file == nil {
+		err := l.open()
+		if err != nil {
+			logging.Errorf("error writing query log: %v", err)
+			return
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		logging.Errorf("error writing query log: %v", err)
+	}
+}
+
+// outcome returns how the log names o. Forwarded queries have been
+// logged as upstream from the start, so that is kept.
+func outcome(o models.Outcome) string {
+	if o == models.OutcomeForwarded {
+		return "upstream"
+	}
+
+	return string(o)
+}
+
+func (l *QueryLog) Close() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
+
+// clientName returns the name of a client when addresses are kept as
+// is, as a name gives away who the client is just like its address
+func (l *QueryLog) clientName(name string) string {
+	if l.config.ClientIP != ClientIPFull {
+		return ""
+	}
+
+	return name
+}
+
+func (l *QueryLog) clientIP(client string) string {
+	switch l.config.ClientIP {
+	case ClientIPHash:
+		mac := hmac.New(sha256.New, l.salt)
+		mac.Write([]byte(client))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	case ClientIPTruncate:
+		return truncateIP(client)
+	default:
+		return client
+	}
+}
+
+// truncateIP zeroes the host part of ip, keeping the /24 of an IPv4
+// address and the /48 of an IPv6 address
+func truncateIP(client string) string {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func (l *QueryLog) open() error {
+	file, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("error opening query log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading query log: %w", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	l.opened = time.Now()
+	// a log appended to across restarts is as old as its first entry
+	if first, ok := l.firstEntry(); ok {
+		l.opened = first
+	}
+
+	return nil
+}
+
+// firstEntry returns the time of the first line of the log
+func (l *QueryLog) firstEntry() (time.Time, bool) {
+	file, err := os.Open(l.config.Path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return time.Time{}, false
+	}
+	var first entry
+	if json.Unmarshal(line, &first) != nil || first.Time.IsZero() {
+		return time.Time{}, false
+	}
+
+	return first.Time, true
+}
+
+func (l *QueryLog) shouldRotate(next int64) bool {
+	if l.size > 0 && l.size+next > int64(l.config.MaxSizeMB)*1024*1024 {
+		return true
+	}
+
+	return l.config.MaxAge.Duration > 0 && time.Since(l.opened) > l.config.MaxAge.Duration
+}
+
+// rotate renames the current log with a timestamp suffix, opens a new
+// one and removes backups beyond MaxBackups. The log is reopened even
+// when it couldn't be renamed, so queries are still logged to it.
+func (l *QueryLog) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err != nil {
+		err = fmt.Errorf("error closing query log: %w", err)
+	} else {
+		backup := l.config.Path + "." + time.Now().UTC().Format(backupFormat)
+		err = os.Rename(l.config.Path, backup)
+		if err != nil {
+			err = fmt.Errorf("error renaming query log: %w", err)
+		}
+	}
+
+	openErr := l.open()
+	if err != nil || openErr != nil {
+		return errors.Join(err, openErr)
+	}
+
+	if l.config.MaxBackups > 0 {
+		l.removeOldBackups()
+	}
+
+	return nil
+}
+
+func (l *QueryLog) removeOldBackups() {
+	matches, err := filepath.Glob(l.config.Path + ".*")
+	if err != nil {
+		logging.Errorf("error listing query log backups: %v", err)
+		return
+	}
+	// other files next to the log, e.g: queries.jsonl.bak, aren't ours
+	backups := matches[:0]
+	for _, match := range matches {
+		if _, err := time.Parse(backupFormat, strings.TrimPrefix(match, l.config.Path+".")); err == nil {
+			backups = append(backups, match)
+		}
+	}
+
+	// timestamps sort in the same order as they were written
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for _, backup := range backups[min(len(backups), l.config.MaxBackups):] {
+		err := os.Remove(backup)
+		if err != nil {
+			logging.Errorf("error removing query log backup: %v", err)
+		}
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,179 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_clientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		client   string
+		expected string
+		// client names are only kept along with the full address
+		expectedName string
+	}{
+		{
+			name:         "full keeps the address",
+			mode:         ClientIPFull,
+			client:       "192.168.0.23",
+			expected:     "192.168.0.23",
+			expectedName: "laptop.home.lan",
+		},
+		{
+			name:     "truncate IPv4 to /24",
+			mode:     ClientIPTruncate,
+			client:   "192.168.0.23",
+			expected: "192.168.0.0",
+		},
+		{
+			name:     "truncate IPv6 to /48",
+			mode:     ClientIPTruncate,
+			client:   "2001:db8:1234:5678::1",
+			expected: "2001:db8:1234::",
+		},
+		{
+			name:     "hash with a fixed salt",
+			mode:     ClientIPHash,
+			client:   "192.168.0.23",
+			expected: "3f537a7b2249fdc7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &QueryLog{
+				config: models.QueryLogConfig{ClientIP: tt.mode},
+				salt:   []byte("salt"),
+			}
+			assert.Equal(t, tt.expected, l.clientIP(tt.client))
+			assert.Equal(t, tt.expectedName, l.clientName("laptop.home.lan"))
+		})
+	}
+}
+
+func Test_rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.jsonl")
+	l, err := Start(models.QueryLogConfig{
+		Path:       path,
+		MaxSizeMB:  1,
+		MaxBackups: 1,
+	})
+	require.NoError(t, err)
+	defer l.Close()
+
+	// files that merely share the name of the log are left alone
+	for _, other := range []string{path + ".tmp", path + ".bak"} {
+		require.NoError(t, os.WriteFile(other, []byte("keep"), 0o640))
+	}
+
+	q := models.Query{Time: time.Now(), Client: "192.168.0.23", Name: "example.com", Type: "A"}
+	l.Record(q)
+
+	// pretend the log is full so the next query rotates it, twice over
+	// so the oldest backup is removed
+	for i := 0; i < 2; i++ {
+		l.size = 1024 * 1024
+		time.Sleep(2 * time.Millisecond)
+		l.Record(q)
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	assert.Len(t, backups, 3)
+	assert.Contains(t, backups, path+".tmp")
+	assert.Contains(t, backups, path+".bak")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, l.size, info.Size())
+}
+
+func Test_rotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.jsonl")
+	l, err := Start(models.QueryLogConfig{Path: path, MaxSizeMB: 1})
+	require.NoError(t, err)
+	defer l.Close()
+
+	q := models.Query{Time: time.Now(), Client: "192.168.0.23", Name: "example.com", Type: "A"}
+	l.Record(q)
+
+	// the log can't be renamed once it's gone, it's opened again and
+	// queries are still logged
+	require.NoError(t, os.Remove(path))
+	l.size = 1024 * 1024
+	l.Record(q)
+	l.Record(q)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(data, []byte("\n")))
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+}
+
+func Test_outcome(t *testing.T) {
+	tests := []struct {
+		outcome  models.Outcome
+		expected string
+	}{
+		{outcome: models.OutcomeForwarded, expected: "upstream"},
+		{outcome: models.OutcomeCached, expected: "cached"},
+		{outcome: models.OutcomeBlocked, expected: "blocked"},
+		{outcome: models.OutcomeHosts, expected: "hosts"},
+		{outcome: models.OutcomeError, expected: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.outcome), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "queries.jsonl")
+			l, err := Start(models.QueryLogConfig{Path: path})
+			require.NoError(t, err)
+			l.Record(models.Query{Time: time.Now(), Client: "192.168.0.23", Name: "example.com", Type: "A", Outcome: tt.outcome})
+			require.NoError(t, l.Close())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			var actual entry
+			require.NoError(t, json.Unmarshal(data, &actual))
+			assert.Equal(t, tt.expected, actual.Outcome)
+		})
+	}
+}
+
+func Test_rotateAgeAcrossRestarts(t *testing.T) {
+	tests := []struct {
+		name            string
+		existing        string
+		expectedBackups int
+	}{
+		{name: "Old first entry", existing: `{"time":"2026-10-18T12:00:00Z","qname":"example.com"}` + "\n", expectedBackups: 1},
+		{name: "Recent first entry", existing: `{"time":"` + time.Now().UTC().Format(time.RFC3339) + `","qname":"example.com"}` + "\n"},
+		{name: "Unreadable first entry", existing: "not json\n"},
+		{name: "Empty log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "queries.jsonl")
+			require.NoError(t, os.WriteFile(path, []byte(tt.existing), 0o640))
+
+			l, err := Start(models.QueryLogConfig{Path: path, MaxAge: models.Duration{Duration: time.Hour}})
+			require.NoError(t, err)
+			defer l.Close()
+			l.Record(models.Query{Time: time.Now(), Client: "192.168.0.23", Name: "example.com", Type: "A"})
+
+			backups, err := filepath.Glob(path + ".*")
+			require.NoError(t, err)
+			assert.Len(t, backups, tt.expectedBackups)
+		})
+	}
+}
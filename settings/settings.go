@@ -0,0 +1,113 @@
+package settings
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"dumbdns/models"
+)
+
+// Defaults are used for any setting not given as a flag, environment
+// variable or in the config file
+var Defaults = Settings{
+	Listen:   ":53",
+	CacheTTL: 5 * time.Minute,
+	Refresh:  2 * time.Hour,
+	Upstream: []string{"quad9", "cloudflare"},
+	LogLevel: "info",
+}
+
+// envPrefix is prepended to the upper case flag name, with dashes
+// replaced by underscores, e.g: --cache-ttl is DUMBDNS_CACHE_TTL
+const envPrefix = "DUMBDNS_"
+
+// Settings are the runtime settings of the server
+type Settings struct {
+	ConfigPath string
+	Listen     string
+	CacheTTL   time.Duration
+	Refresh    time.Duration
+	Upstream   []string
+	LogLevel   string
+	CacheFile  string
+}
+
+// Parse reads the settings given as flags or environment variables,
+// flags taking precedence. Settings given in neither are left empty so
+// they can be filled in by Resolve.
+func Parse(name string, args []string, lookupEnv func(string) (string, bool)) (Settings, error) {
+	s := Settings{}
+	var upstream string
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&s.ConfigPath, "config", "", "path to the config file (default dumbdns.json next to the binary)")
+	fs.StringVar(&s.Listen, "listen", "", fmt.Sprintf("address to serve DNS on (default %q)", Defaults.Listen))
+	fs.DurationVar(&s.CacheTTL, "cache-ttl", 0, fmt.Sprintf("how long answers are cached (default %s)", Defaults.CacheTTL))
+	fs.DurationVar(&s.Refresh, "refresh", 0, fmt.Sprintf("how often block lists are refreshed (default %s)", Defaults.Refresh))
+	fs.StringVar(&upstream, "upstream", "", fmt.Sprintf("comma separated DoH providers (default %q)", strings.Join(Defaults.Upstream, ",")))
+	fs.StringVar(&s.CacheFile, "cache-file", "", "file the cache is saved to on shutdown and loaded from on start (default none)")
+	fs.StringVar(&s.LogLevel, "log-level", "", fmt.Sprintf("debug, info, warn or error (default %q)", Defaults.LogLevel))
+
+	// environment variables are applied as flag values first, so flags
+	// given on the command line replace them
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(EnvName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", EnvName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return s, err
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return s, err
+	}
+	if upstream != "" {
+		s.Upstream = strings.Split(upstream, ",")
+	}
+
+	return s, nil
+}
+
+// EnvName returns the environment variable for a flag
+func EnvName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Resolve fills in the settings missing from s with the config file,
+// then the defaults
+func (s Settings) Resolve(file models.ServerConfig) Settings {
+	s.Listen = first(s.Listen, file.Listen, Defaults.Listen)
+	s.CacheTTL = first(s.CacheTTL, file.CacheTTL.Duration, Defaults.CacheTTL)
+	s.Refresh = first(s.Refresh, file.Refresh.Duration, Defaults.Refresh)
+	s.LogLevel = first(s.LogLevel, file.LogLevel, Defaults.LogLevel)
+	s.CacheFile = first(s.CacheFile, file.CacheFile, Defaults.CacheFile)
+	if len(s.Upstream) == 0 {
+		s.Upstream = file.Upstream
+	}
+	if len(s.Upstream) == 0 {
+		s.Upstream = Defaults.Upstream
+	}
+
+	return s
+}
+
+// first returns the first value that isn't the zero value
+func first[T comparable](values ...T) T {
+	var zero T
+	for _, v := range values {
+		if v != zero {
+			return v
+		}
+	}
+
+	return zero
+}
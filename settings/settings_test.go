@@ -0,0 +1,95 @@
+package settings
+
+import (
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_precedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		file     models.ServerConfig
+		expected Settings
+	}{
+		{
+			name:     "defaults",
+			expected: Defaults,
+		},
+		{
+			name: "file replaces defaults",
+			file: models.ServerConfig{
+				Listen:   ":5353",
+				CacheTTL: models.Duration{Duration: time.Minute},
+				Upstream: []string{"google"},
+			},
+			expected: Settings{
+				Listen:   ":5353",
+				CacheTTL: time.Minute,
+				Refresh:  Defaults.Refresh,
+				Upstream: []string{"google"},
+				LogLevel: Defaults.LogLevel,
+			},
+		},
+		{
+			name: "env replaces file",
+			env: map[string]string{
+				"DUMBDNS_LISTEN":    ":5354",
+				"DUMBDNS_CACHE_TTL": "10m",
+				"DUMBDNS_UPSTREAM":  "cloudflare",
+			},
+			file: models.ServerConfig{
+				Listen:   ":5353",
+				CacheTTL: models.Duration{Duration: time.Minute},
+				Upstream: []string{"google"},
+			},
+			expected: Settings{
+				Listen:   ":5354",
+				CacheTTL: 10 * time.Minute,
+				Refresh:  Defaults.Refresh,
+				Upstream: []string{"cloudflare"},
+				LogLevel: Defaults.LogLevel,
+			},
+		},
+		{
+			name: "flags replace env",
+			args: []string{"--listen", ":5355", "--log-level=debug", "--config", "/etc/dumbdns.json"},
+			env: map[string]string{
+				"DUMBDNS_LISTEN":    ":5354",
+				"DUMBDNS_LOG_LEVEL": "warn",
+			},
+			expected: Settings{
+				ConfigPath: "/etc/dumbdns.json",
+				Listen:     ":5355",
+				CacheTTL:   Defaults.CacheTTL,
+				Refresh:    Defaults.Refresh,
+				Upstream:   Defaults.Upstream,
+				LogLevel:   "debug",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookupEnv := func(key string) (string, bool) {
+				v, ok := tt.env[key]
+				return v, ok
+			}
+			flags, err := Parse("dumbdns", tt.args, lookupEnv)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, flags.Resolve(tt.file))
+		})
+	}
+}
+
+func Test_invalidEnv(t *testing.T) {
+	lookupEnv := func(key string) (string, bool) {
+		return "soon", key == "DUMBDNS_REFRESH"
+	}
+	_, err := Parse("dumbdns", nil, lookupEnv)
+	assert.ErrorContains(t, err, "DUMBDNS_REFRESH")
+}
@@ -0,0 +1,192 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"dumbdns/models"
+)
+
+const (
+	bucketSize  = 10 * time.Minute
+	bucketCount = 144 // 24 hours of buckets
+
+	// maxTracked caps the number of distinct domains and clients counted
+	// so a client walking random names can't grow the maps forever
+	maxTracked = 10000
+)
+
+type Stats struct {
+	mux *sync.Mutex
+
+	started time.Time
+	total   int
+	blocked int
+
+	buckets  [bucketCount]bucket
+	queried  map[string]int
+	blockedD map[string]int
+	clients  map[string]int
+	upstream map[string]*latency
+}
+
+type bucket struct {
+	start   time.Time
+	total   int
+	blocked int
+}
+
+type latency struct {
+	count int
+	total time.Duration
+	max   time.Duration
+}
+
+// Summary is the dashboard view of the collected stats
+type Summary struct {
+	Since           time.Time                  `json:"since"`
+	Total           int                        `json:"total"`
+	Blocked         int                        `json:"blocked"`
+	BlockedPercent  float64                    `json:"blockedPercent"`
+	OverTime        []Bucket                   `json:"overTime"`
+	TopQueried      []Count                    `json:"topQueried"`
+	TopBlocked      []Count                    `json:"topBlocked"`
+	TopClients      []Count                    `json:"topClients"`
+	UpstreamLatency map[string]UpstreamLatency `json:"upstreamLatency"`
+}
+
+type Bucket struct {
+	Start   time.Time `json:"start"`
+	Total   int       `json:"total"`
+	Blocked int       `json:"blocked"`
+}
+
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type UpstreamLatency struct {
+	Queries int     `json:"queries"`
+	AvgMs   float64 `json:"avgMs"`
+	MaxMs   float64 `json:"maxMs"`
+}
+
+func Start() *Stats {
+	return &Stats{
+		mux:      &sync.Mutex{},
+		started:  time.Now(),
+		queried:  map[string]int{},
+		blockedD: map[string]int{},
+		clients:  map[string]int{},
+		upstream: map[string]*latency{},
+	}
+}
+
+// Record counts a single answered question
+func (s *Stats) Record(q models.Query) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	blocked := q.Outcome == models.OutcomeBlocked
+
+	s.total++
+	b := s.bucket(q.Time)
+	b.total++
+	if blocked {
+		s.blocked++
+		b.blocked++
+		increment(s.blockedD, q.Name)
+	}
+	increment(s.queried, q.Name)
+	increment(s.clients, q.Client)
+
+	if q.Upstream != "" {
+		l, ok := s.upstream[q.Upstream]
+		if !ok {
+			l = &latency{}
+			s.upstream[q.Upstream] = l
+		}
+		l.count++
+		l.total += q.UpstreamLatency
+		l.max = max(l.max, q.UpstreamLatency)
+	}
+}
+
+// bucket returns the bucket for t, resetting it if it last held data
+// from a previous day
+func (s *Stats) bucket(t time.Time) *bucket {
+	start := t.Truncate(bucketSize)
+	b := &s.buckets[(start.Unix()/int64(bucketSize.Seconds()))%bucketCount]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+
+	return b
+}
+
+// Summary returns the stats with the top n entries of each list
+func (s *Stats) Summary(now time.Time, n int) Summary {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	summary := Summary{
+		Since:           s.started,
+		Total:           s.total,
+		Blocked:         s.blocked,
+		TopQueried:      top(s.queried, n),
+		TopBlocked:      top(s.blockedD, n),
+		TopClients:      top(s.clients, n),
+		UpstreamLatency: map[string]UpstreamLatency{},
+	}
+	if s.total > 0 {
+		summary.BlockedPercent = float64(s.blocked) / float64(s.total) * 100
+	}
+
+	// oldest bucket first, skipping buckets that fell out of the window
+	first := now.Truncate(bucketSize).Add(-(bucketCount - 1) * bucketSize)
+	for i := 0; i < bucketCount; i++ {
+		start := first.Add(time.Duration(i) * bucketSize)
+		b := s.buckets[(start.Unix()/int64(bucketSize.Seconds()))%bucketCount]
+		if !b.start.Equal(start) {
+			b = bucket{start: start}
+		}
+		summary.OverTime = append(summary.OverTime, Bucket{Start: b.start, Total: b.total, Blocked: b.blocked})
+	}
+
+	for provider, l := range s.upstream {
+		summary.UpstreamLatency[provider] = UpstreamLatency{
+			Queries: l.count,
+			AvgMs:   float64(l.total.Microseconds()) / float64(l.count) / 1000,
+			MaxMs:   float64(l.max.Microseconds()) / 1000,
+		}
+	}
+
+	return summary
+}
+
+func increment(counts map[string]int, key string) {
+	if _, ok := counts[key]; !ok && len(counts) >= maxTracked {
+		return
+	}
+	counts[key]++
+}
+
+func top(counts map[string]int, n int) []Count {
+	list := make([]Count, 0, len(counts))
+	for name, count := range counts {
+		list = append(list, Count{Name: name, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count == list[j].Count {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Count > list[j].Count
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+
+	return list
+}
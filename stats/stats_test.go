@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"dumbdns/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var noon = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func query(at time.Time, name string, outcome models.Outcome) models.Query {
+	return models.Query{Time: at, Name: name, Client: "192.168.0.23", Outcome: outcome}
+}
+
+func Test_buckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []models.Query
+		now     time.Time
+		// expected maps bucket starts to their total and blocked counts,
+		// every other bucket of the window is empty
+		expected map[time.Time][2]int
+	}{
+		{
+			name: "Same bucket",
+			queries: []models.Query{
+				query(noon, "example.com", models.OutcomeForwarded),
+				query(noon.Add(9*time.Minute), "ads.example.com", models.OutcomeBlocked),
+			},
+			now:      noon.Add(9 * time.Minute),
+			expected: map[time.Time][2]int{noon: {2, 1}},
+		},
+		{
+			name: "Next bucket",
+			queries: []models.Query{
+				query(noon, "example.com", models.OutcomeForwarded),
+				query(noon.Add(10*time.Minute), "example.com", models.OutcomeCached),
+			},
+			now:      noon.Add(10 * time.Minute),
+			expected: map[time.Time][2]int{noon: {1, 0}, noon.Add(10 * time.Minute): {1, 0}},
+		},
+		{
+			name: "A day later the bucket is reused",
+			queries: []models.Query{
+				query(noon, "example.com", models.OutcomeForwarded),
+				query(noon.Add(24*time.Hour), "ads.example.com", models.OutcomeBlocked),
+			},
+			now:      noon.Add(24 * time.Hour),
+			expected: map[time.Time][2]int{noon.Add(24 * time.Hour): {1, 1}},
+		},
+		{
+			name: "Buckets out of the window are left out",
+			queries: []models.Query{
+				query(noon, "example.com", models.OutcomeForwarded),
+				query(noon.Add(time.Hour), "example.com", models.OutcomeForwarded),
+			},
+			now:      noon.Add(24*time.Hour + 30*time.Minute),
+			expected: map[time.Time][2]int{noon.Add(time.Hour): {1, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Start()
+			for _, q := range tt.queries {
+				s.Record(q)
+			}
+
+			summary := s.Summary(tt.now, 10)
+			require.Len(t, summary.OverTime, bucketCount)
+			assert.Equal(t, tt.now.Truncate(bucketSize), summary.OverTime[bucketCount-1].Start)
+			assert.Equal(t, tt.now.Truncate(bucketSize).Add(-(bucketCount-1)*bucketSize), summary.OverTime[0].Start)
+			for _, b := range summary.OverTime {
+				assert.Equal(t, tt.expected[b.Start], [2]int{b.Total, b.Blocked}, b.Start)
+			}
+			// the totals count every query since start
+			assert.Equal(t, len(tt.queries), summary.Total)
+		})
+	}
+}
+
+func Test_summary(t *testing.T) {
+	s := Start()
+	for i := 0; i < 3; i++ {
+		s.Record(query(noon, "example.com", models.OutcomeForwarded))
+	}
+	s.Record(query(noon, "ads.example.com", models.OutcomeBlocked))
+	s.Record(models.Query{Time: noon, Name: "b.example.com", Client: "192.168.0.24", Outcome: models.OutcomeForwarded,
+		Upstream: "quad9", UpstreamLatency: 10 * time.Millisecond})
+	s.Record(models.Query{Time: noon, Name: "a.example.com", Client: "192.168.0.24", Outcome: models.OutcomeForwarded,
+		Upstream: "quad9", UpstreamLatency: 30 * time.Millisecond})
+
+	summary := s.Summary(noon, 3)
+	assert.Equal(t, 6, summary.Total)
+	assert.Equal(t, 1, summary.Blocked)
+	assert.InDelta(t, 100.0/6, summary.BlockedPercent, 0.001)
+	// the most counted first, ties by name
+	assert.Equal(t, []Count{{"example.com", 3}, {"a.example.com", 1}, {"ads.example.com", 1}}, summary.TopQueried)
+	assert.Equal(t, []Count{{"ads.example.com", 1}}, summary.TopBlocked)
+	assert.Equal(t, []Count{{"192.168.0.23", 4}, {"192.168.0.24", 2}}, summary.TopClients)
+	assert.Equal(t, map[string]UpstreamLatency{"quad9": {Queries: 2, AvgMs: 20, MaxMs: 30}}, summary.UpstreamLatency)
+
+	assert.Zero(t, Start().Summary(noon, 3).BlockedPercent)
+}
+
+func Test_top(t *testing.T) {
+	counts := map[string]int{"c": 2, "a": 2, "b": 5, "d": 1}
+
+	tests := []struct {
+		name     string
+		n        int
+		expected []Count
+	}{
+		{name: "All", n: 10, expected: []Count{{"b", 5}, {"a", 2}, {"c", 2}, {"d", 1}}},
+		{name: "Cut off", n: 2, expected: []Count{{"b", 5}, {"a", 2}}},
+		{name: "None", n: 0, expected: []Count{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, top(counts, tt.n))
+		})
+	}
+}
+
+func Test_maxTracked(t *testing.T) {
+	s := Start()
+	for i := 0; i < maxTracked; i++ {
+		s.Record(query(noon, fmt.Sprintf("%d.example.com", i), models.OutcomeForwarded))
+	}
+
+	// names already counted keep counting, new ones are dropped
+	s.Record(query(noon, "0.example.com", models.OutcomeForwarded))
+	s.Record(query(noon, "new.example.com", models.OutcomeForwarded))
+
+	assert.Len(t, s.queried, maxTracked)
+	assert.Equal(t, 2, s.queried["0.example.com"])
+	assert.NotContains(t, s.queried, "new.example.com")
+	// the totals still count every query
+	assert.Equal(t, maxTracked+2, s.Summary(noon, 1).Total)
+}
@@ -0,0 +1,349 @@
+package zone
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Update applies an RFC 2136 dynamic update, prereqs and updates are
+// the records of an unpacked UPDATE message. The prerequisites are
+// checked first and the update is only applied when all of them hold,
+// either every record in updates is applied or none is. Zones loaded
+// from a file are written back before the change is visible, err is
+// only set when that write fails.
+func (z *Zone) Update(prereqs []dns.RR, updates []dns.RR) (rcode int, err error) {
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	if rcode := z.checkPrereqs(prereqs); rcode != dns.RcodeSuccess {
+		return rcode, nil
+	}
+	if rcode := z.prescan(updates); rcode != dns.RcodeSuccess {
+		return rcode, nil
+	}
+
+	records := make(map[string][]dns.RR, len(z.records))
+	for owner, rrs := range z.records {
+		records[owner] = append([]dns.RR(nil), rrs...)
+	}
+	soa := z.soa
+	changed, setSOA := false, false
+	for _, rr := range updates {
+		var applied bool
+		switch rr.Header().Class {
+		case dns.ClassINET:
+			applied = z.add(records, rr)
+			if s, ok := rr.(*dns.SOA); ok && applied {
+				soa, setSOA = s, true
+			}
+		case dns.ClassANY:
+			applied = z.removeRRset(records, rr)
+		case dns.ClassNONE:
+			applied = z.remove(records, rr)
+		}
+		changed = changed || applied
+	}
+	if !changed {
+		return dns.RcodeSuccess, nil
+	}
+
+	// secondaries and caches only notice the change with a new serial
+	if !setSOA {
+		bumped := dns.Copy(soa).(*dns.SOA)
+		bumped.Serial++
+		apex := records[z.Origin]
+		for i, rr := range apex {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				apex[i] = bumped
+			}
+		}
+		soa = bumped
+	}
+
+	if z.path != "" {
+		err := write(z.path, z.Origin, soa, records)
+		if err != nil {
+			return dns.RcodeServerFailure, err
+		}
+	}
+	z.soa, z.records = soa, records
+
+	return dns.RcodeSuccess, nil
+}
+
+// checkPrereqs checks the prerequisite section of RFC 2136 3.2
+func (z *Zone) checkPrereqs(prereqs []dns.RR) int {
+	// records of class IN must match a whole RRset of the zone
+	rrsets := map[string][]dns.RR{}
+	for _, rr := range prereqs {
+		hdr := rr.Header()
+		name := strings.ToLower(hdr.Name)
+		if hdr.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !z.Contains(name) {
+			return dns.RcodeNotZone
+		}
+
+		switch hdr.Class {
+		case dns.ClassANY:
+			if hasRdata(rr) {
+				return dns.RcodeFormatError
+			}
+			if hdr.Rrtype == dns.TypeANY && len(z.records[name]) == 0 {
+				return dns.RcodeNameError
+			}
+			if hdr.Rrtype != dns.TypeANY && len(rrset(z.records, name, hdr.Rrtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if hasRdata(rr) {
+				return dns.RcodeFormatError
+			}
+			if hdr.Rrtype == dns.TypeANY && len(z.records[name]) > 0 {
+				return dns.RcodeYXDomain
+			}
+			if hdr.Rrtype != dns.TypeANY && len(rrset(z.records, name, hdr.Rrtype)) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			key := fmt.Sprintf("%s/%d", name, hdr.Rrtype)
+			rrsets[key] = append(rrsets[key], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	for _, expected := range rrsets {
+		hdr := expected[0].Header()
+		actual := rrset(z.records, strings.ToLower(hdr.Name), hdr.Rrtype)
+		if !sameRRset(expected, actual) {
+			return dns.RcodeNXRrset
+		}
+	}
+
+	return dns.RcodeSuccess
+}
+
+// prescan checks the update section of RFC 2136 3.4.1 before anything
+// is changed
+func (z *Zone) prescan(updates []dns.RR) int {
+	for _, rr := range updates {
+		hdr := rr.Header()
+		if !z.Contains(hdr.Name) {
+			return dns.RcodeNotZone
+		}
+
+		switch hdr.Class {
+		case dns.ClassINET:
+			if isMetaType(hdr.Rrtype) || hdr.Rrtype == dns.TypeANY || !hasRdata(rr) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if hdr.Ttl != 0 || hasRdata(rr) || isMetaType(hdr.Rrtype) {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if hdr.Ttl != 0 || isMetaType(hdr.Rrtype) || hdr.Rrtype == dns.TypeANY {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	return dns.RcodeSuccess
+}
+
+// add adds rr to records unless it's already there, it reports whether
+// anything changed
+func (z *Zone) add(records map[string][]dns.RR, rr dns.RR) bool {
+	name := strings.ToLower(rr.Header().Name)
+	rrs := records[name]
+
+	// a CNAME can't share its name with other records
+	for _, existing := range rrs {
+		isCNAME := existing.Header().Rrtype == dns.TypeCNAME
+		if isCNAME != (rr.Header().Rrtype == dns.TypeCNAME) {
+			return false
+		}
+	}
+
+	switch rr := rr.(type) {
+	case *dns.SOA:
+		// the SOA is only replaced by one with a newer serial
+		if name != z.Origin || int32(rr.Serial-z.soa.Serial) <= 0 {
+			return false
+		}
+		return replace(records, name, rr)
+	case *dns.CNAME:
+		return replace(records, name, rr)
+	}
+
+	for i, existing := range rrs {
+		if dns.IsDuplicate(existing, rr) {
+			if existing.Header().Ttl == rr.Header().Ttl {
+				return false
+			}
+			rrs[i] = rr
+			return true
+		}
+	}
+	records[name] = append(rrs, rr)
+
+	return true
+}
+
+// removeRRset removes the RRset of rr, or every record of its name for
+// type ANY. The SOA and NS records of the apex are kept.
+func (z *Zone) removeRRset(records map[string][]dns.RR, rr dns.RR) bool {
+	name := strings.ToLower(rr.Header().Name)
+	qtype := rr.Header().Rrtype
+
+	return removeIf(records, name, func(existing dns.RR) bool {
+		t := existing.Header().Rrtype
+		if name == z.Origin && (t == dns.TypeSOA || t == dns.TypeNS) {
+			return false
+		}
+		return qtype == dns.TypeANY || t == qtype
+	})
+}
+
+// remove removes the record matching rr. The SOA and the last NS record
+// of the apex are kept.
+func (z *Zone) remove(records map[string][]dns.RR, rr dns.RR) bool {
+	name := strings.ToLower(rr.Header().Name)
+	target := dns.Copy(rr)
+	target.Header().Class = dns.ClassINET
+	if name == z.Origin {
+		switch rr.Header().Rrtype {
+		case dns.TypeSOA:
+			return false
+		case dns.TypeNS:
+			if len(rrset(records, name, dns.TypeNS)) <= 1 {
+				return false
+			}
+		}
+	}
+
+	return removeIf(records, name, func(existing dns.RR) bool {
+		return dns.IsDuplicate(existing, target)
+	})
+}
+
+// replace sets rr as the only record of its type at name
+func replace(records map[string][]dns.RR, name string, rr dns.RR) bool {
+	removeIf(records, name, func(existing dns.RR) bool {
+		return existing.Header().Rrtype == rr.Header().Rrtype
+	})
+	records[name] = append(records[name], rr)
+
+	return true
+}
+
+// removeIf removes the records of name matching match, it reports
+// whether any were removed
+func removeIf(records map[string][]dns.RR, name string, match func(dns.RR) bool) bool {
+	rrs := records[name]
+	kept := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if !match(rr) {
+			kept = append(kept, rr)
+		}
+	}
+	if len(kept) == len(rrs) {
+		return false
+	}
+	if len(kept) == 0 {
+		delete(records, name)
+	} else {
+		records[name] = kept
+	}
+
+	return true
+}
+
+// rrset returns the records of name with type rrtype
+func rrset(records map[string][]dns.RR, name string, rrtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, rr := range records[name] {
+		if rr.Header().Rrtype == rrtype {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	return rrs
+}
+
+// sameRRset reports whether expected and actual hold the same records,
+// ignoring their TTLs
+func sameRRset(expected []dns.RR, actual []dns.RR) bool {
+	contains := func(rrs []dns.RR, rr dns.RR) bool {
+		for _, r := range rrs {
+			if dns.IsDuplicate(r, rr) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rr := range expected {
+		if !contains(actual, rr) {
+			return false
+		}
+	}
+	for _, rr := range actual {
+		if !contains(expected, rr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasRdata reports whether rr was sent with rdata, rr must have been
+// unpacked from a message for its Rdlength to be set
+func hasRdata(rr dns.RR) bool {
+	switch rr.(type) {
+	case *dns.RR_Header, *dns.ANY:
+		return false
+	}
+
+	return rr.Header().Rdlength > 0
+}
+
+// isMetaType reports whether rrtype is a query or meta type, which
+// can't be stored in a zone
+func isMetaType(rrtype uint16) bool {
+	switch rrtype {
+	case dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB, dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY:
+		return true
+	}
+
+	return false
+}
+
+// write saves the zone to path in RFC 1035 format, the SOA first. The
+// file is written to a temporary file first so a failed write never
+// leaves a truncated zone behind.
+func write(path string, origin string, soa *dns.SOA, records map[string][]dns.RR) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ORIGIN %s\n%s\n", origin, soa.String())
+	for _, owner := range sortedOwners(records) {
+		for _, rr := range records[owner] {
+			if rr.Header().Rrtype != dns.TypeSOA {
+				b.WriteString(rr.String() + "\n")
+			}
+		}
+	}
+
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, []byte(b.String()), 0o644)
+	if err != nil {
+		return fmt.Errorf("error writing zone file: %w", err)
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,205 @@
+package zone
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rrs(t *testing.T, records ...string) []dns.RR {
+	var rrs []dns.RR
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		require.NoError(t, err)
+		rrs = append(rrs, rr)
+	}
+
+	return rrs
+}
+
+// wire packs and unpacks u, as records without rdata only differ from
+// the others once they have been on the wire
+func wire(t *testing.T, u *dns.Msg) *dns.Msg {
+	packed, err := u.Pack()
+	require.NoError(t, err)
+	m := new(dns.Msg)
+	require.NoError(t, m.Unpack(packed))
+
+	return m
+}
+
+func Test_update(t *testing.T) {
+	tests := []struct {
+		name          string
+		update        func(u *dns.Msg)
+		expectedRcode int
+		qname         string
+		qtype         uint16
+		// expectedAnswer is the answer to qname once the update is done
+		expectedAnswer []string
+	}{
+		{
+			name: "add a record",
+			update: func(u *dns.Msg) {
+				u.Insert(rrs(t, "web.home.lan. 300 IN A 192.168.0.30"))
+			},
+			qname:          "web.home.lan.",
+			qtype:          dns.TypeA,
+			expectedAnswer: []string{"web.home.lan.\t300\tIN\tA\t192.168.0.30"},
+		},
+		{
+			name: "add to an RRset",
+			update: func(u *dns.Msg) {
+				u.Insert(rrs(t, "nas.home.lan. 3600 IN A 192.168.0.11"))
+			},
+			qname:          "nas.home.lan.",
+			qtype:          dns.TypeA,
+			expectedAnswer: []string{"nas.home.lan.\t3600\tIN\tA\t192.168.0.10", "nas.home.lan.\t3600\tIN\tA\t192.168.0.11"},
+		},
+		{
+			name: "replace an RRset",
+			update: func(u *dns.Msg) {
+				u.RemoveRRset(rrs(t, "nas.home.lan. 0 IN A 0.0.0.0"))
+				u.Insert(rrs(t, "nas.home.lan. 60 IN A 192.168.0.12"))
+			},
+			qname:          "nas.home.lan.",
+			qtype:          dns.TypeA,
+			expectedAnswer: []string{"nas.home.lan.\t60\tIN\tA\t192.168.0.12"},
+		},
+		{
+			name: "remove a record",
+			update: func(u *dns.Msg) {
+				u.Remove(rrs(t, "nas.home.lan. 3600 IN AAAA fd00::10"))
+			},
+			qname: "nas.home.lan.",
+			qtype: dns.TypeAAAA,
+		},
+		{
+			name: "remove a name",
+			update: func(u *dns.Msg) {
+				u.RemoveName(rrs(t, "nas.home.lan. 0 IN A 0.0.0.0"))
+			},
+			qname: "nas.home.lan.",
+			qtype: dns.TypeTXT,
+		},
+		{
+			name: "the apex SOA and NS are kept",
+			update: func(u *dns.Msg) {
+				u.RemoveName(rrs(t, "home.lan. 0 IN A 0.0.0.0"))
+				u.Remove(rrs(t, "home.lan. 3600 IN NS ns.home.lan."))
+			},
+			qname:          "home.lan.",
+			qtype:          dns.TypeNS,
+			expectedAnswer: []string{"home.lan.\t3600\tIN\tNS\tns.home.lan."},
+		},
+		{
+			name: "a CNAME can't be added next to other records",
+			update: func(u *dns.Msg) {
+				u.Insert(rrs(t, "nas.home.lan. 3600 IN CNAME files.home.lan."))
+			},
+			qname:          "nas.home.lan.",
+			qtype:          dns.TypeCNAME,
+			expectedAnswer: []string{},
+		},
+		{
+			name: "name in use prerequisite",
+			update: func(u *dns.Msg) {
+				u.NameUsed(rrs(t, "nas.home.lan. 0 IN A 0.0.0.0"))
+				u.Insert(rrs(t, "nas.home.lan. 3600 IN TXT \"updated\""))
+			},
+			qname:          "nas.home.lan.",
+			qtype:          dns.TypeTXT,
+			expectedAnswer: []string{"nas.home.lan.\t3600\tIN\tTXT\t\"backups\"", "nas.home.lan.\t3600\tIN\tTXT\t\"updated\""},
+		},
+		{
+			name: "name not in use prerequisite fails",
+			update: func(u *dns.Msg) {
+				u.NameNotUsed(rrs(t, "nas.home.lan. 0 IN A 0.0.0.0"))
+				u.Insert(rrs(t, "nas.home.lan. 3600 IN A 192.168.0.11"))
+			},
+			expectedRcode: dns.RcodeYXDomain,
+		},
+		{
+			name: "RRset prerequisite fails",
+			update: func(u *dns.Msg) {
+				u.RRsetUsed(rrs(t, "web.home.lan. 0 IN A 0.0.0.0"))
+			},
+			expectedRcode: dns.RcodeNXRrset,
+		},
+		{
+			name: "RRset value prerequisite fails",
+			update: func(u *dns.Msg) {
+				u.Used(rrs(t, "nas.home.lan. 0 IN A 192.168.0.99"))
+			},
+			expectedRcode: dns.RcodeNXRrset,
+		},
+		{
+			name: "record outside the zone",
+			update: func(u *dns.Msg) {
+				u.Insert(rrs(t, "nas.example.com. 3600 IN A 192.168.0.10"))
+			},
+			expectedRcode: dns.RcodeNotZone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "home.lan.zone")
+			require.NoError(t, os.WriteFile(path, []byte(homeLan), 0o644))
+			z, err := Load("home.lan", path)
+			require.NoError(t, err)
+
+			u := new(dns.Msg)
+			u.SetUpdate("home.lan.")
+			tt.update(u)
+			u = wire(t, u)
+
+			rcode, err := z.Update(u.Answer, u.Ns)
+			require.NoError(t, err)
+			require.Equal(t, dns.RcodeToString[tt.expectedRcode], dns.RcodeToString[rcode])
+			if tt.expectedRcode != dns.RcodeSuccess {
+				return
+			}
+
+			// the update is written back to the zone file
+			reloaded, err := Load("home.lan", path)
+			require.NoError(t, err)
+			for _, z := range []*Zone{z, reloaded} {
+				answer, _, _ := z.Lookup(tt.qname, tt.qtype)
+				actual := []string{}
+				for _, rr := range answer {
+					actual = append(actual, rr.String())
+				}
+				assert.ElementsMatch(t, tt.expectedAnswer, actual)
+			}
+		})
+	}
+}
+
+func Test_updateSerial(t *testing.T) {
+	z, err := Parse("home.lan", "home.lan.zone", strings.NewReader(homeLan))
+	require.NoError(t, err)
+
+	u := new(dns.Msg)
+	u.SetUpdate("home.lan.")
+	u.Insert(rrs(t, "web.home.lan. 300 IN A 192.168.0.30"))
+	u = wire(t, u)
+
+	rcode, err := z.Update(u.Answer, u.Ns)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rcode)
+	_, authority, _ := z.Lookup("missing.home.lan.", dns.TypeA)
+	assert.Equal(t, uint32(2), authority[0].(*dns.SOA).Serial)
+
+	// an update that changes nothing keeps the serial
+	rcode, err = z.Update(u.Answer, u.Ns)
+	require.NoError(t, err)
+	require.Equal(t, dns.RcodeSuccess, rcode)
+	_, authority, _ = z.Lookup("missing.home.lan.", dns.TypeA)
+	assert.Equal(t, uint32(2), authority[0].(*dns.SOA).Serial)
+}
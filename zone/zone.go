@@ -0,0 +1,223 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain limits how many CNAMEs are followed inside a zone
+const maxCNAMEChain = 8
+
+// Zone is a zone answered authoritatively from an RFC 1035 zone file
+type Zone struct {
+	Origin string
+	// path the zone is written back to after dynamic updates, empty
+	// for zones that weren't loaded from a file
+	path string
+
+	// mux guards soa and records, which change with dynamic updates
+	mux *sync.RWMutex
+	soa *dns.SOA
+	// records are keyed by lower case owner name
+	records map[string][]dns.RR
+}
+
+// Load reads the zone file at path. origin is used for relative names
+// until the file sets its own $ORIGIN.
+func Load(origin string, path string) (*Zone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening zone file: %w", err)
+	}
+	defer f.Close()
+
+	z, err := Parse(origin, path, f)
+	if err != nil {
+		return nil, err
+	}
+	z.path = path
+
+	return z, nil
+}
+
+// Parse reads a zone file from r, file is only used in errors
+func Parse(origin string, file string, r io.Reader) (*Zone, error) {
+	z := &Zone{
+		Origin:  strings.ToLower(dns.Fqdn(origin)),
+		mux:     &sync.RWMutex{},
+		records: map[string][]dns.RR{},
+	}
+
+	zp := dns.NewZoneParser(r, z.Origin, file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		if !dns.IsSubDomain(z.Origin, name) {
+			return nil, fmt.Errorf("%s: %s is outside of zone %s", file, rr.Header().Name, z.Origin)
+		}
+		if soa, ok := rr.(*dns.SOA); ok {
+			if name != z.Origin {
+				return nil, fmt.Errorf("%s: SOA for %s isn't at the zone apex %s", file, rr.Header().Name, z.Origin)
+			}
+			z.soa = soa
+		}
+		z.records[name] = append(z.records[name], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if z.soa == nil {
+		return nil, errors.New(file + ": zone has no SOA record")
+	}
+
+	return z, nil
+}
+
+// Contains reports whether name is in the zone
+func (z *Zone) Contains(name string) bool {
+	return dns.IsSubDomain(z.Origin, strings.ToLower(dns.Fqdn(name)))
+}
+
+// Lookup answers a question about name, which must be in the zone. The
+// authority section holds the SOA of negative answers.
+func (z *Zone) Lookup(name string, qtype uint16) (answer []dns.RR, authority []dns.RR, rcode int) {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	owner := dns.Fqdn(name)
+	for range maxCNAMEChain {
+		rrs, exists := z.find(owner)
+		if !exists {
+			if len(answer) > 0 {
+				// a CNAME to a missing name, the answer is the chain so far
+				return answer, nil, dns.RcodeSuccess
+			}
+			return nil, z.negative(), dns.RcodeNameError
+		}
+
+		var cname *dns.CNAME
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == qtype || qtype == dns.TypeANY {
+				answer = append(answer, rr)
+			}
+			if c, ok := rr.(*dns.CNAME); ok {
+				cname = c
+			}
+		}
+		if cname == nil || qtype == dns.TypeCNAME || qtype == dns.TypeANY {
+			if len(answer) == 0 {
+				return nil, z.negative(), dns.RcodeSuccess
+			}
+			return answer, nil, dns.RcodeSuccess
+		}
+
+		answer = append(answer, cname)
+		if !z.Contains(cname.Target) {
+			// the rest of the chain is for the client to look up
+			return answer, nil, dns.RcodeSuccess
+		}
+		owner = cname.Target
+	}
+
+	return answer, nil, dns.RcodeServerFailure
+}
+
+// find returns the records of name with their owner set to name, using
+// a wildcard when name has no records of its own. exists is true for
+// names with records and for empty non-terminals.
+func (z *Zone) find(name string) (rrs []dns.RR, exists bool) {
+	lower := strings.ToLower(name)
+	if rrs, ok := z.records[lower]; ok {
+		return withOwner(rrs, name), true
+	}
+	if z.exists(lower) {
+		// an empty non-terminal exists but has no records of its own
+		return nil, true
+	}
+
+	// only the wildcard of the closest encloser, the nearest name above
+	// name that exists, can answer, RFC 4592 3.3.1. With *.home.lan. and
+	// b.home.lan., a.b.home.lan. doesn't exist.
+	labels := dns.SplitDomainName(lower)
+	for i := 1; i < len(labels); i++ {
+		parent := dns.Fqdn(strings.Join(labels[i:], "."))
+		if !dns.IsSubDomain(z.Origin, parent) {
+			break
+		}
+		if !z.exists(parent) {
+			continue
+		}
+		if rrs, ok := z.records["*."+parent]; ok {
+			return withOwner(rrs, name), true
+		}
+		break
+	}
+
+	return nil, false
+}
+
+// exists reports whether the lower case name has records or names below
+// it that do
+func (z *Zone) exists(lower string) bool {
+	if _, ok := z.records[lower]; ok {
+		return true
+	}
+	for owner := range z.records {
+		if strings.HasSuffix(owner, "."+lower) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negative returns the SOA sent with NXDOMAIN and NODATA answers, its
+// TTL is the negative caching TTL of RFC 2308
+func (z *Zone) negative() []dns.RR {
+	soa := dns.Copy(z.soa).(*dns.SOA)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+
+	return []dns.RR{soa}
+}
+
+// withOwner copies rrs with their owner name set to name, so answers
+// use the case of the question and wildcards are expanded
+func withOwner(rrs []dns.RR, name string) []dns.RR {
+	copies := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = name
+		copies = append(copies, rr)
+	}
+
+	return copies
+}
+
+// Records returns every record of the zone, sorted by owner name
+func (z *Zone) Records() []dns.RR {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	var rrs []dns.RR
+	for _, owner := range sortedOwners(z.records) {
+		rrs = append(rrs, z.records[owner]...)
+	}
+
+	return rrs
+}
+
+func sortedOwners(records map[string][]dns.RR) []string {
+	owners := make([]string, 0, len(records))
+	for owner := range records {
+		owners = append(owners, owner)
+	}
+	slices.Sort(owners)
+
+	return owners
+}
@@ -0,0 +1,194 @@
+package zone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const homeLan = `$ORIGIN home.lan.
+$TTL 3600
+@       IN SOA ns.home.lan. admin.home.lan. 1 7200 900 1209600 300
+        IN NS  ns
+ns      IN A   192.168.0.1
+nas     IN A   192.168.0.10
+        IN AAAA fd00::10
+        IN TXT "backups"
+files   IN CNAME nas
+photos  IN CNAME photos.example.com.
+@       IN MX  10 mail
+_smb._tcp IN SRV 0 0 445 nas
+*.dev   IN A   192.168.0.20
+`
+
+func Test_lookup(t *testing.T) {
+	z, err := Parse("home.lan", "home.lan.zone", strings.NewReader(homeLan))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name              string
+		qname             string
+		qtype             uint16
+		expectedAnswer    []string
+		expectedAuthority bool
+		expectedRcode     int
+	}{
+		{
+			name:           "A",
+			qname:          "nas.home.lan.",
+			qtype:          dns.TypeA,
+			expectedAnswer: []string{"nas.home.lan.\t3600\tIN\tA\t192.168.0.10"},
+		},
+		{
+			name:           "question case is kept",
+			qname:          "NAS.home.lan.",
+			qtype:          dns.TypeAAAA,
+			expectedAnswer: []string{"NAS.home.lan.\t3600\tIN\tAAAA\tfd00::10"},
+		},
+		{
+			name:           "CNAME inside the zone is followed",
+			qname:          "files.home.lan.",
+			qtype:          dns.TypeA,
+			expectedAnswer: []string{"files.home.lan.\t3600\tIN\tCNAME\tnas.home.lan.", "nas.home.lan.\t3600\tIN\tA\t192.168.0.10"},
+		},
+		{
+			name:           "CNAME outside the zone",
+			qname:          "photos.home.lan.",
+			qtype:          dns.TypeA,
+			expectedAnswer: []string{"photos.home.lan.\t3600\tIN\tCNAME\tphotos.example.com."},
+		},
+		{
+			name:           "MX at the apex",
+			qname:          "home.lan.",
+			qtype:          dns.TypeMX,
+			expectedAnswer: []string{"home.lan.\t3600\tIN\tMX\t10 mail.home.lan."},
+		},
+		{
+			name:           "SRV",
+			qname:          "_smb._tcp.home.lan.",
+			qtype:          dns.TypeSRV,
+			expectedAnswer: []string{"_smb._tcp.home.lan.\t3600\tIN\tSRV\t0 0 445 nas.home.lan."},
+		},
+		{
+			name:           "wildcard",
+			qname:          "api.dev.home.lan.",
+			qtype:          dns.TypeA,
+			expectedAnswer: []string{"api.dev.home.lan.\t3600\tIN\tA\t192.168.0.20"},
+		},
+		{
+			name:              "NODATA",
+			qname:             "ns.home.lan.",
+			qtype:             dns.TypeAAAA,
+			expectedAuthority: true,
+		},
+		{
+			name:              "empty non-terminal is NODATA",
+			qname:             "_tcp.home.lan.",
+			qtype:             dns.TypeA,
+			expectedAuthority: true,
+		},
+		{
+			name:              "NXDOMAIN",
+			qname:             "printer.home.lan.",
+			qtype:             dns.TypeA,
+			expectedAuthority: true,
+			expectedRcode:     dns.RcodeNameError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, authority, rcode := z.Lookup(tt.qname, tt.qtype)
+			actual := []string{}
+			for _, rr := range answer {
+				actual = append(actual, rr.String())
+			}
+			if tt.expectedAnswer == nil {
+				tt.expectedAnswer = []string{}
+			}
+			assert.Equal(t, tt.expectedAnswer, actual)
+			assert.Equal(t, tt.expectedRcode, rcode)
+			if !tt.expectedAuthority {
+				assert.Empty(t, authority)
+				return
+			}
+			require.Len(t, authority, 1)
+			// negative answers use the SOA minimum as their TTL
+			assert.Equal(t, "home.lan.\t300\tIN\tSOA\tns.home.lan. admin.home.lan. 1 7200 900 1209600 300", authority[0].String())
+		})
+	}
+}
+
+func Test_wildcardClosestEncloser(t *testing.T) {
+	data := `$ORIGIN home.lan.
+$TTL 3600
+@       IN SOA ns.home.lan. admin.home.lan. 1 7200 900 1209600 300
+*       IN A   192.168.0.20
+b       IN A   192.168.0.30
+_tcp.c  IN TXT "service"
+`
+	z, err := Parse("home.lan", "home.lan.zone", strings.NewReader(data))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		qname          string
+		expectedAnswer []string
+		expectedRcode  int
+	}{
+		{name: "Wildcard", qname: "a.home.lan.", expectedAnswer: []string{"a.home.lan.\t3600\tIN\tA\t192.168.0.20"}},
+		{name: "Wildcard below a missing name", qname: "a.x.home.lan.", expectedAnswer: []string{"a.x.home.lan.\t3600\tIN\tA\t192.168.0.20"}},
+		{name: "Name below an existing name", qname: "a.b.home.lan.", expectedRcode: dns.RcodeNameError},
+		{name: "Name below an empty non-terminal", qname: "a.c.home.lan.", expectedRcode: dns.RcodeNameError},
+		{name: "Existing name", qname: "b.home.lan.", expectedAnswer: []string{"b.home.lan.\t3600\tIN\tA\t192.168.0.30"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, _, rcode := z.Lookup(tt.qname, dns.TypeA)
+			actual := []string{}
+			for _, rr := range answer {
+				actual = append(actual, rr.String())
+			}
+			if tt.expectedAnswer == nil {
+				tt.expectedAnswer = []string{}
+			}
+			assert.Equal(t, tt.expectedAnswer, actual)
+			assert.Equal(t, tt.expectedRcode, rcode)
+		})
+	}
+}
+
+func Test_parseErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		expectedErr string
+	}{
+		{
+			name:        "missing SOA",
+			data:        "nas 3600 IN A 192.168.0.10\n",
+			expectedErr: "home.lan.zone: zone has no SOA record",
+		},
+		{
+			name:        "record outside the zone",
+			data:        "@ 3600 IN SOA ns admin 1 7200 900 1209600 300\nexample.com. 3600 IN A 192.168.0.10\n",
+			expectedErr: "home.lan.zone: example.com. is outside of zone home.lan.",
+		},
+		{
+			name:        "bad record",
+			data:        "nas 3600 IN A 192.168.0.300\n",
+			expectedErr: `home.lan.zone: dns: bad A A: "192.168.0.300" at line: 1:27`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("home.lan", "home.lan.zone", strings.NewReader(tt.data))
+			assert.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}